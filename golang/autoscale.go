@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// autoscaleInterval is how often the autoscaler reconsiders the worker
+	// count; decisions never happen more often than this.
+	autoscaleInterval = 2 * time.Second
+	// autoscaleHold is the number of intervals to keep the count unchanged
+	// after backing off, so the pool does not flap around its optimum.
+	autoscaleHold = 3
+	// lowMemoryFraction and growMemoryFraction are the fractions of system
+	// memory that must be available to keep, respectively grow, the pool.
+	lowMemoryFraction  = 0.10
+	growMemoryFraction = 0.25
+)
+
+// workersValue is the -workers flag: a fixed worker count or "auto".
+type workersValue struct {
+	n    int
+	auto bool
+}
+
+func (v *workersValue) String() string {
+	if v.auto {
+		return "auto"
+	}
+	return strconv.Itoa(v.n)
+}
+
+func (v *workersValue) Set(s string) error {
+	if s == "auto" {
+		v.auto = true
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("expected a number or \"auto\"")
+	}
+	v.n, v.auto = n, false
+	return nil
+}
+
+// scaleEvent is one entry of the worker-count timeline.
+type scaleEvent struct {
+	at      time.Duration
+	workers int
+	reason  string
+}
+
+// autoscaler bounds the number of workers actively processing images and
+// adjusts that bound during the run based on throughput and memory headroom.
+// All worker goroutines are started up front; the ones above the current
+// limit simply wait in acquire.
+type autoscaler struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	min    int
+	max    int
+
+	start    time.Time
+	images   int
+	latency  time.Duration
+	timeline []scaleEvent
+
+	prevThroughput float64
+	lastGrew       bool
+	hold           int
+	done           chan struct{}
+}
+
+func newAutoscaler(maxWorkers int) *autoscaler {
+	limit := runtime.NumCPU()
+	if limit > maxWorkers {
+		limit = maxWorkers
+	}
+	s := &autoscaler{
+		limit: limit,
+		min:   1,
+		max:   maxWorkers,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.timeline = []scaleEvent{{workers: s.limit, reason: "start at NumCPU"}}
+	return s
+}
+
+// acquire blocks until the worker may take another image.
+func (s *autoscaler) acquire() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for s.active >= s.limit {
+		s.cond.Wait()
+	}
+	s.active++
+	s.mu.Unlock()
+}
+
+// release records the images a worker finished and frees its slot.
+func (s *autoscaler) release(images int, latency time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.active--
+	s.images += images
+	s.latency += latency
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// run adjusts the limit every autoscaleInterval until stop is called.
+func (s *autoscaler) run() {
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+func (s *autoscaler) stop() {
+	close(s.done)
+}
+
+func (s *autoscaler) tick() {
+	s.mu.Lock()
+	images, latency := s.images, s.latency
+	s.images, s.latency = 0, 0
+	limit := s.limit
+	s.mu.Unlock()
+
+	throughput := float64(images) / autoscaleInterval.Seconds()
+	var avgLatency time.Duration
+	if images > 0 {
+		avgLatency = latency / time.Duration(images)
+	}
+	memFree := availableMemoryFraction()
+
+	newLimit, reason := limit, ""
+	switch {
+	case memFree >= 0 && memFree < lowMemoryFraction && limit > s.min:
+		newLimit = max(s.min, limit-max(1, limit/4))
+		reason = fmt.Sprintf("low memory (%.0f%% free)", memFree*100)
+		s.hold = autoscaleHold
+	case s.hold > 0:
+		s.hold--
+	case images == 0:
+		// Nothing finished this interval (huge images or idle): keep as is.
+	case s.lastGrew && throughput < s.prevThroughput*0.95:
+		newLimit = max(s.min, limit-1)
+		reason = fmt.Sprintf("throughput fell to %.1f img/s, avg latency %.2fs", throughput, avgLatency.Seconds())
+		s.hold = autoscaleHold
+	case limit < s.max && throughput >= s.prevThroughput && (memFree < 0 || memFree > growMemoryFraction):
+		newLimit = limit + max(1, limit/4)
+		if newLimit > s.max {
+			newLimit = s.max
+		}
+		reason = fmt.Sprintf("throughput %.1f img/s, avg latency %.2fs", throughput, avgLatency.Seconds())
+	}
+
+	s.lastGrew = newLimit > limit
+	if images > 0 {
+		s.prevThroughput = throughput
+	}
+	if newLimit == limit {
+		return
+	}
+
+	s.mu.Lock()
+	s.limit = newLimit
+	s.timeline = append(s.timeline, scaleEvent{at: time.Since(s.start), workers: newLimit, reason: reason})
+	s.mu.Unlock()
+	s.cond.Broadcast()
+
+	fmt.Printf("⚙️  Autoscale: %d → %d workers (%s)\n", limit, newLimit, reason)
+}
+
+func (s *autoscaler) printTimeline() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Printf("\n⚙️  Worker autoscaling (bounds %d-%d):\n", s.min, s.max)
+	for _, e := range s.timeline {
+		fmt.Printf("   %6.1fs: %d workers (%s)\n", e.at.Seconds(), e.workers, e.reason)
+	}
+}
+
+// availableMemoryFraction returns the fraction of system memory currently
+// available, or -1 when it cannot be determined on this platform.
+func availableMemoryFraction() float64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	var total, available float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = v
+		case "MemAvailable:":
+			available = v
+		}
+	}
+	if total == 0 || available == 0 {
+		return -1
+	}
+	return available / total
+}
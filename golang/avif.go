@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AVIF output goes through avifenc, the libavif command-line encoder, like
+// WebP and JPEG XL: there is no cgo-free AVIF encoder to build in.
+
+// avifencAvailable reports whether the libavif encoder is installed.
+func avifencAvailable() bool {
+	_, err := exec.LookPath("avifenc")
+	return err == nil
+}
+
+// validateAVIF checks the options of -output-encoding avif.
+func (c *Config) validateAVIF() error {
+	switch {
+	case !avifencAvailable():
+		return fmt.Errorf("-output-encoding avif needs the avifenc encoder from libavif, which was not found in PATH")
+	case c.avifQuality < 0 || c.avifQuality > 100:
+		return fmt.Errorf("-avif-quality must be between 0 and 100, got %d", c.avifQuality)
+	case c.avifSpeed < 0 || c.avifSpeed > 10:
+		return fmt.Errorf("-avif-speed must be between 0 and 10, got %d", c.avifSpeed)
+	case c.targetSSIM > 0:
+		return fmt.Errorf("-target-ssim only applies to JPEG outputs, not -output-encoding avif")
+	case c.verifyOutputs || c.measureQuality:
+		return fmt.Errorf("-verify-outputs and -measure-quality cannot decode AVIF outputs")
+	case c.backend == backendVips:
+		return fmt.Errorf("-backend vips does not support -output-encoding avif")
+	}
+	return nil
+}
+
+// encodeAVIF encodes canvas as AVIF with avifenc at quality (0-100) and
+// speed (0 slowest and smallest, 10 fastest) and writes the result to w.
+func encodeAVIF(w io.Writer, canvas *image.RGBA, quality, speed int) error {
+	tmpDir, err := os.MkdirTemp("", "wbi-avif-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// avifenc reads PNG, JPEG and Y4M but not PPM. Fast compression keeps
+	// the intermediate file cheap.
+	input := filepath.Join(tmpDir, "canvas.png")
+	f, err := os.Create(input)
+	if err != nil {
+		return err
+	}
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = enc.Encode(f, canvas)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	output := filepath.Join(tmpDir, "canvas.avif")
+	var stderr bytes.Buffer
+	cmd := exec.Command("avifenc", "-q", fmt.Sprint(quality), "-s", fmt.Sprint(speed), input, output)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("avifenc failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(w, out)
+	return err
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// backgroundAutoContrast picks the border color from the image's average
+// luminance instead of using a fixed color.
+const backgroundAutoContrast = "auto-contrast"
+
+// backgroundTransparent leaves the border fully transparent, for PNG
+// outputs composited into other designs.
+const backgroundTransparent = "transparent"
+
+// Border modes selectable with -border-mode.
+const (
+	borderModeSolid = "solid"
+	borderModeBlur  = "blur"
+	// borderModeAuto fills the border with the average color of the
+	// image's outer ring.
+	borderModeAuto = "auto"
+	// borderModeDominant fills the border with the most common color of
+	// the whole image.
+	borderModeDominant = "dominant"
+	// borderModeEdge fills the border with the average color of the
+	// image's outermost -edge-width pixels.
+	borderModeEdge = "edge"
+)
+
+// edgeRingFraction is the width of the ring -border-mode auto averages, as
+// a fraction of the image's width (left and right strips) and height (top
+// and bottom strips).
+const edgeRingFraction = 0.05
+
+// validBorderMode reports whether mode is a -border-mode value.
+func validBorderMode(mode string) bool {
+	switch mode {
+	case borderModeSolid, borderModeBlur, borderModeAuto, borderModeDominant, borderModeEdge:
+		return true
+	}
+	return false
+}
+
+// dominantBits is the number of high bits per channel dominantColor groups
+// colors by: 4 gives 4096 bins, wide enough that the noise and gradients of
+// a photo's sky or wall fall in one.
+const dominantBits = 4
+
+// luminanceSamples bounds the number of pixels averaged per side, so the
+// luminance of large images is estimated from an evenly spaced grid.
+const luminanceSamples = 256
+
+// parseColor parses a color given as a CSS color name (white, black,
+// navy, ...) or as #rgb or #rrggbb hex.
+func parseColor(s string) (color.RGBA, error) {
+	if c, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (expected a CSS color name such as white or black, #rgb or #rrggbb)", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (expected a CSS color name such as white or black, #rgb or #rrggbb)", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// parseBackground parses a -background color, which may also be
+// transparent.
+func parseBackground(s string) (color.RGBA, error) {
+	if s == backgroundTransparent {
+		return color.RGBA{}, nil
+	}
+	return parseColor(s)
+}
+
+// validateTransparent rejects the settings a transparent border cannot be
+// honored with.
+func (c *Config) validateTransparent() error {
+	if c.background != backgroundTransparent {
+		return nil
+	}
+	switch {
+	case c.jobsPath == "" && c.outputEncoding != encodingPNG:
+		return fmt.Errorf("-background transparent needs -output-encoding png: JPEG has no alpha channel, so the border would come out black")
+	case c.borderMode != borderModeSolid:
+		return fmt.Errorf("-background transparent cannot be combined with -border-mode %s, which fills the border", c.borderMode)
+	case c.pngPalette || c.paletteOutput:
+		return fmt.Errorf("-background transparent cannot be combined with -png-palette or -palette-output, whose palettes are opaque")
+	case c.autoKeyline:
+		return fmt.Errorf("-auto-keyline compares the image with the border color, which a transparent border does not have")
+	}
+	return nil
+}
+
+// formatColor returns c as #rrggbb.
+func formatColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// averageLuminance returns the mean luminance of img in [0, 1], sampled on a
+// fixed grid so the result is the same for a given image on every run.
+func averageLuminance(img image.Image) float64 {
+	b := img.Bounds()
+	stepX := max(1, b.Dx()/luminanceSamples)
+	stepY := max(1, b.Dy()/luminanceSamples)
+
+	var sum float64
+	var n int
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n) / 255
+}
+
+// edgeColor returns the average color of the outer ring of img, the
+// edgeRingFraction-wide strips along its four sides, sampled on the same
+// fixed grid as averageLuminance.
+func edgeColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	ringX := max(1, int(float64(b.Dx())*edgeRingFraction))
+	ringY := max(1, int(float64(b.Dy())*edgeRingFraction))
+	stepX := max(1, b.Dx()/luminanceSamples)
+	stepY := max(1, b.Dy()/luminanceSamples)
+
+	var r, g, bl, n uint64
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		inRow := y < b.Min.Y+ringY || y >= b.Max.Y-ringY
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			if !inRow && x >= b.Min.X+ringX && x < b.Max.X-ringX {
+				// Jump to the right strip.
+				x = max(x, b.Max.X-ringX-stepX)
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r += uint64(c.R)
+			g += uint64(c.G)
+			bl += uint64(c.B)
+			n++
+		}
+	}
+	if n == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	return color.RGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((bl + n/2) / n), 255}
+}
+
+// stripColor returns the average color of the outermost width pixels of
+// img along its four sides. Every row or column across a strip is read,
+// sampled along it on the same fixed grid as averageLuminance, so even a
+// 1-pixel strip is averaged in full. Fully transparent pixels show the
+// border rather than the image and are left out; an image whose edges are
+// all transparent gets white.
+func stripColor(img image.Image, width int) color.RGBA {
+	b := img.Bounds()
+	wx, wy := width, width
+	if wx > b.Dx() {
+		wx = b.Dx()
+	}
+	if wy > b.Dy() {
+		wy = b.Dy()
+	}
+	stepX := max(1, b.Dx()/luminanceSamples)
+	stepY := max(1, b.Dy()/luminanceSamples)
+
+	var r, g, bl, n uint64
+	add := func(x, y int) {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A == 0 {
+			return
+		}
+		r += uint64(c.R)
+		g += uint64(c.G)
+		bl += uint64(c.B)
+		n++
+	}
+	for i := 0; i < wy; i++ {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			add(x, b.Min.Y+i)
+			add(x, b.Max.Y-1-i)
+		}
+	}
+	// The corners were read with the top and bottom strips.
+	for y := b.Min.Y + wy; y < b.Max.Y-wy; y += stepY {
+		for i := 0; i < wx; i++ {
+			add(b.Min.X+i, y)
+			add(b.Max.X-1-i, y)
+		}
+	}
+	if n == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	return color.RGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((bl + n/2) / n), 255}
+}
+
+// dominantColor returns the most common color of img: the pixels of the
+// same fixed grid as averageLuminance are binned by the dominantBits high
+// bits of each channel, and the pixels of the fullest bin averaged. Ties go
+// to the bin first in RGB order, so an image always gets the same color.
+func dominantColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	stepX := max(1, b.Dx()/luminanceSamples)
+	stepY := max(1, b.Dy()/luminanceSamples)
+
+	type bin struct{ r, g, b, n uint64 }
+	var bins [1 << (3 * dominantBits)]bin
+	shift := 8 - dominantBits
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				// Transparent pixels show the border, not the image.
+				continue
+			}
+			i := int(c.R)>>shift<<(2*dominantBits) | int(c.G)>>shift<<dominantBits | int(c.B)>>shift
+			bins[i].r += uint64(c.R)
+			bins[i].g += uint64(c.G)
+			bins[i].b += uint64(c.B)
+			bins[i].n++
+		}
+	}
+	best := 0
+	for i := range bins {
+		if bins[i].n > bins[best].n {
+			best = i
+		}
+	}
+	top := bins[best]
+	if top.n == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	n := top.n
+	return color.RGBA{uint8((top.r + n/2) / n), uint8((top.g + n/2) / n), uint8((top.b + n/2) / n), 255}
+}
+
+// resolveBackground returns the border color for img and, in auto-contrast
+// mode, the average luminance the decision was based on (otherwise -1).
+// With -border-mode auto the color is the average of the image's edges,
+// with edge that of its outermost -edge-width pixels, and with dominant its
+// most common color. sourceWidth is the width of the source img may have
+// been reduced from, which -edge-width counts pixels of.
+func (c *Config) resolveBackground(img image.Image, sourceWidth int) (color.RGBA, float64) {
+	switch c.borderMode {
+	case borderModeAuto:
+		return edgeColor(img), -1
+	case borderModeEdge:
+		width := max(1, int(math.Round(float64(c.edgeWidth*img.Bounds().Dx())/float64(sourceWidth))))
+		return stripColor(img, width), -1
+	case borderModeDominant:
+		return dominantColor(img), -1
+	}
+	if c.background != backgroundAutoContrast {
+		return c.backgroundColor, -1
+	}
+	lum := averageLuminance(img)
+	if lum > c.contrastThreshold {
+		return c.darkColor, lum
+	}
+	return c.lightColor, lum
+}
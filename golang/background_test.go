@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestBlurBorderCorners(t *testing.T) {
+	// A green photo with a magenta band: its blurred copy covers the
+	// corners of the canvas instead of the white background.
+	img := fill(160, 100, color.RGBA{0, 160, 60, 255})
+	for y := 40; y < 60; y++ {
+		for x := 0; x < 160; x++ {
+			img.Set(x, y, color.RGBA{200, 0, 200, 255})
+		}
+	}
+	config := testConfig()
+	config.borderMode = borderModeBlur
+
+	var info imageInfo
+	canvas, _ := renderCanvas(img, "out.png", config, &info)
+	b := canvas.Bounds()
+	for _, p := range [][2]int{{0, 0}, {b.Max.X - 1, 0}, {0, b.Max.Y - 1}, {b.Max.X - 1, b.Max.Y - 1}} {
+		got := canvas.At(p[0], p[1])
+		if near(got, config.backgroundColor, 8) {
+			t.Errorf("corner %v is the border color %v in blur mode", p, got)
+		}
+		if _, g, _, _ := got.RGBA(); g>>8 < 80 {
+			t.Errorf("corner %v = %v, want the blurred green of the photo", p, got)
+		}
+	}
+	// The photo itself is drawn sharp on top.
+	dest := info.layout.destRect
+	if got := canvas.At((dest.Min.X+dest.Max.X)/2, dest.Min.Y+2); !near(got, color.RGBA{0, 160, 60, 255}, 4) {
+		t.Errorf("photo pixel = %v, want it unblurred", got)
+	}
+
+	config.borderMode = borderModeSolid
+	canvas, _ = renderCanvas(img, "out.png", config, &imageInfo{})
+	if got := canvas.At(0, 0); !near(got, config.backgroundColor, 0) {
+		t.Errorf("corner = %v in solid mode, want the border color", got)
+	}
+}
+
+func TestAutoBorderMatchesBlueEdge(t *testing.T) {
+	// A red subject well inside a uniform blue edge, wider than the ring
+	// that is sampled.
+	blue := color.RGBA{20, 60, 200, 255}
+	img := fill(200, 150, blue)
+	for y := 30; y < 120; y++ {
+		for x := 40; x < 160; x++ {
+			img.Set(x, y, color.RGBA{220, 20, 20, 255})
+		}
+	}
+	if got := edgeColor(img); !near(got, blue, 2) {
+		t.Errorf("edgeColor() = %v, want about %v", got, blue)
+	}
+
+	config := testConfig()
+	config.borderMode = borderModeAuto
+	var info imageInfo
+	canvas, _ := renderCanvas(img, "out.png", config, &info)
+	if !near(info.background, blue, 2) {
+		t.Errorf("border color %v, want about %v", info.background, blue)
+	}
+	b := canvas.Bounds()
+	for _, p := range [][2]int{{0, 0}, {b.Max.X / 2, 0}, {0, b.Max.Y / 2}, {b.Max.X - 1, b.Max.Y - 1}} {
+		if got := canvas.At(p[0], p[1]); !near(got, blue, 2) {
+			t.Errorf("border pixel %v = %v, want about %v", p, got, blue)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package border
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// blurReduction is how much smaller than the canvas the backdrop is
+// blurred. Blurring at full size would need kernels a hundred taps wide;
+// a heavy blur loses nothing by being computed small and scaled up.
+const blurReduction = 8
+
+// blurredBackdrop returns img scaled to cover a width x height canvas,
+// cropped around its center and Gaussian-blurred, for the Blur mode.
+func blurredBackdrop(img image.Image, width, height int) *image.RGBA {
+	sw, sh := max(1, width/blurReduction), max(1, height/blurReduction)
+	small := image.NewRGBA(image.Rect(0, 0, sw, sh))
+
+	// The part of img with the canvas's aspect ratio that covers it.
+	b := img.Bounds()
+	scale := math.Max(float64(sw)/float64(b.Dx()), float64(sh)/float64(b.Dy()))
+	cropW := min(b.Dx(), int(math.Round(float64(sw)/scale)))
+	cropH := min(b.Dy(), int(math.Round(float64(sh)/scale)))
+	crop := image.Rect(0, 0, max(1, cropW), max(1, cropH)).
+		Add(b.Min).Add(image.Pt((b.Dx()-cropW)/2, (b.Dy()-cropH)/2))
+	draw.BiLinear.Scale(small, small.Bounds(), img, crop, draw.Src, nil)
+
+	gaussianBlur(small, float64(max(sw, sh))/30)
+
+	backdrop := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(backdrop, backdrop.Bounds(), small, small.Bounds(), draw.Src, nil)
+	return backdrop
+}
+
+// gaussianBlur blurs img in place with a Gaussian of standard deviation
+// sigma, as a horizontal then a vertical pass. Pixels past the edges repeat
+// the edge pixel, so the borders of the result do not darken.
+func gaussianBlur(img *image.RGBA, sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	radius := int(math.Ceil(3 * sigma))
+	weights := make([]float64, 2*radius+1)
+	var total float64
+	for i := range weights {
+		d := float64(i - radius)
+		weights[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		total += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := make([]uint8, len(img.Pix))
+	pass := func(dst, src []uint8, n, count int, offset func(line, i int) int) {
+		var acc [4]float64
+		for line := 0; line < count; line++ {
+			for i := 0; i < n; i++ {
+				acc = [4]float64{}
+				for k, wk := range weights {
+					j := min(max(i+k-radius, 0), n-1)
+					o := offset(line, j)
+					acc[0] += wk * float64(src[o])
+					acc[1] += wk * float64(src[o+1])
+					acc[2] += wk * float64(src[o+2])
+					acc[3] += wk * float64(src[o+3])
+				}
+				o := offset(line, i)
+				for c := range acc {
+					dst[o+c] = uint8(math.Min(255, acc[c]+0.5))
+				}
+			}
+		}
+	}
+	pass(tmp, img.Pix, w, h, func(y, x int) int { return y*img.Stride + 4*x })
+	pass(img.Pix, tmp, h, w, func(x, y int) int { return y*img.Stride + 4*x })
+}
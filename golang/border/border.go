@@ -0,0 +1,328 @@
+// Package border fits an image onto a fixed-size canvas inside a border,
+// the core of the white_border_adder command, for use from other Go
+// programs:
+//
+//	cfg := border.Config{
+//		Width: 1080, Height: 1350,
+//		LandscapeVert: 0.07, LandscapeHoriz: 0.07,
+//		PortraitVert: 0.07, PortraitHoriz: 0.07,
+//		Background: color.White,
+//	}
+//	out, err := border.AddBorder(img, cfg)
+package border
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Config describes the canvas and its border.
+type Config struct {
+	// Width and Height are the size of the output canvas in pixels.
+	Width, Height int
+
+	// LandscapeVert and LandscapeHoriz are the border thickness for
+	// landscape images, as fractions of the canvas height (top and bottom)
+	// and width (left and right). PortraitVert and PortraitHoriz are the
+	// same for portrait and square images.
+	LandscapeVert, LandscapeHoriz float64
+	PortraitVert, PortraitHoriz   float64
+
+	// Top, Bottom, Left and Right, when set, replace the ratio of that one
+	// side for every image, e.g. for a deeper bottom border holding a
+	// caption. Top and Bottom are fractions of the canvas height, Left and
+	// Right of its width.
+	Top, Bottom, Left, Right *float64
+
+	// MinPx and MaxPx clamp every border thickness, in pixels. Zero means
+	// unbounded.
+	MinPx, MaxPx int
+
+	// Background is the border color. Nil means white.
+	Background color.Color
+
+	// Blur fills the border with a blurred copy of the image, enlarged to
+	// cover the whole canvas, instead of Background. Background still shows
+	// where the image is transparent.
+	Blur bool
+
+	// Gravity places the image inside the border: center (or empty),
+	// north, south, east, west, northwest, northeast, southwest or
+	// southeast.
+	Gravity string
+
+	// OffsetX and OffsetY move the image right and down (or left and up
+	// when negative) after Gravity, never into the border.
+	OffsetX, OffsetY int
+
+	// Kernel scales the image. Nil means draw.ApproxBiLinear.
+	Kernel draw.Interpolator
+
+	// CornerRadius rounds the corners of the placed image by this many
+	// pixels, letting the border show through. Zero keeps them square.
+	CornerRadius int
+}
+
+// Layout describes where an image lands on the canvas.
+type Layout struct {
+	// Landscape reports whether the image is wider than it is tall, which
+	// selects the landscape border ratios.
+	Landscape bool
+	// Scale is the factor the image is resized by.
+	Scale float64
+	// Dest is the area of the canvas the image covers.
+	Dest image.Rectangle
+}
+
+// gravities maps the Gravity values to where the image sits along each
+// axis: 0 at the start (left/top), 1 centered, 2 at the end.
+var gravities = map[string][2]int{
+	"northwest": {0, 0}, "north": {1, 0}, "northeast": {2, 0},
+	"west": {0, 1}, "center": {1, 1}, "east": {2, 1},
+	"southwest": {0, 2}, "south": {1, 2}, "southeast": {2, 2},
+}
+
+// ValidGravity reports whether name is a Gravity value.
+func ValidGravity(name string) bool {
+	_, ok := gravities[name]
+	return ok || name == ""
+}
+
+// Validate checks that c describes a canvas with room for an image.
+func (c Config) Validate() error {
+	switch {
+	case c.Width <= 0 || c.Height <= 0:
+		return fmt.Errorf("canvas size must be positive, got %dx%d", c.Width, c.Height)
+	case c.MaxPx > 0 && c.MinPx > c.MaxPx:
+		return fmt.Errorf("MinPx (%d) is larger than MaxPx (%d)", c.MinPx, c.MaxPx)
+	case !ValidGravity(c.Gravity):
+		return fmt.Errorf("invalid gravity %q", c.Gravity)
+	case c.CornerRadius < 0:
+		return fmt.Errorf("corner radius must not be negative, got %d", c.CornerRadius)
+	}
+	for _, side := range []*float64{c.Top, c.Bottom, c.Left, c.Right} {
+		if side != nil && *side < 0 {
+			return fmt.Errorf("border ratios must not be negative, got %g", *side)
+		}
+	}
+	for _, landscape := range []bool{true, false} {
+		top, bottom, left, right := c.Borders(landscape)
+		if top+bottom >= float64(c.Height) || left+right >= float64(c.Width) {
+			return errors.New("borders leave no room for the image")
+		}
+	}
+	return nil
+}
+
+// Borders returns the thickness in pixels of each border for the given
+// orientation, after the MinPx/MaxPx clamps.
+func (c Config) Borders(landscape bool) (top, bottom, left, right float64) {
+	verticalRatio, horizontalRatio := c.PortraitVert, c.PortraitHoriz
+	if landscape {
+		verticalRatio, horizontalRatio = c.LandscapeVert, c.LandscapeHoriz
+	}
+	side := func(ratio *float64, fallback float64, length int) float64 {
+		if ratio != nil {
+			fallback = *ratio
+		}
+		return c.clamp(float64(length) * fallback)
+	}
+	return side(c.Top, verticalRatio, c.Height), side(c.Bottom, verticalRatio, c.Height),
+		side(c.Left, horizontalRatio, c.Width), side(c.Right, horizontalRatio, c.Width)
+}
+
+func (c Config) clamp(px float64) float64 {
+	if c.MinPx > 0 {
+		px = math.Max(px, float64(c.MinPx))
+	}
+	if c.MaxPx > 0 {
+		px = math.Min(px, float64(c.MaxPx))
+	}
+	return px
+}
+
+// Layout fits a width x height image inside the borders, preserving its
+// aspect ratio, and places it according to Gravity and the offsets.
+func (c Config) Layout(width, height int) Layout {
+	landscape := width > height
+	top, bottom, left, right := c.Borders(landscape)
+
+	scale := math.Min(
+		(float64(c.Width)-left-right)/float64(width),
+		(float64(c.Height)-top-bottom)/float64(height),
+	)
+	scaledWidth := int(float64(width) * scale)
+	scaledHeight := int(float64(height) * scale)
+
+	g, ok := gravities[c.Gravity]
+	if !ok {
+		g = gravities["center"]
+	}
+	x := placeAxis(c.Width, scaledWidth, int(left), int(right), g[0], c.OffsetX)
+	y := placeAxis(c.Height, scaledHeight, int(top), int(bottom), g[1], c.OffsetY)
+
+	return Layout{
+		Landscape: landscape,
+		Scale:     scale,
+		Dest:      image.Rect(x, y, x+scaledWidth, y+scaledHeight),
+	}
+}
+
+// placeAxis returns the offset of an image of length size on a canvas of
+// length total with borders startBorder and endBorder, for gravity position
+// pos plus nudge, clamped so the image stays inside the borders. Centering
+// is within the borders, so uneven borders shift the image.
+func placeAxis(total, size, startBorder, endBorder, pos, nudge int) int {
+	lo, hi := startBorder, total-endBorder-size
+	if hi < lo {
+		hi = lo
+	}
+	var offset int
+	switch pos {
+	case 0:
+		offset = lo
+	case 2:
+		offset = hi
+	default:
+		offset = lo + (hi-lo)/2
+	}
+	offset += nudge
+	if offset < lo {
+		offset = lo
+	}
+	if offset > hi {
+		offset = hi
+	}
+	return offset
+}
+
+// AddBorder returns img scaled and placed on a cfg.Width x cfg.Height
+// canvas filled with the border color. The result is an *image.RGBA.
+func AddBorder(img image.Image, cfg Config) (image.Image, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if img == nil || img.Bounds().Empty() {
+		return nil, errors.New("empty image")
+	}
+	canvas, _ := Render(img, cfg)
+	return canvas, nil
+}
+
+// Render is AddBorder for a cfg already validated, returning the canvas
+// and the layout used.
+func Render(img image.Image, cfg Config) (*image.RGBA, Layout) {
+	canvas := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	return canvas, render(canvas, img, cfg)
+}
+
+// Render64 is Render onto a canvas with 16 bits per channel, for images
+// with more than 8 bits per channel, such as 16-bit PNGs.
+func Render64(img image.Image, cfg Config) (*image.RGBA64, Layout) {
+	canvas := image.NewRGBA64(image.Rect(0, 0, cfg.Width, cfg.Height))
+	return canvas, render(canvas, img, cfg)
+}
+
+// RenderGray is Render onto a grayscale canvas, for grayscale images. A
+// Background with color is drawn as its luminance.
+func RenderGray(img image.Image, cfg Config) (*image.Gray, Layout) {
+	canvas := image.NewGray(image.Rect(0, 0, cfg.Width, cfg.Height))
+	return canvas, render(canvas, img, cfg)
+}
+
+// RenderGray16 is RenderGray onto a canvas with 16 bits per pixel, for
+// 16-bit grayscale images.
+func RenderGray16(img image.Image, cfg Config) (*image.Gray16, Layout) {
+	canvas := image.NewGray16(image.Rect(0, 0, cfg.Width, cfg.Height))
+	return canvas, render(canvas, img, cfg)
+}
+
+// render draws img and its border on canvas, of size cfg.Width x
+// cfg.Height.
+func render(canvas draw.Image, img image.Image, cfg Config) Layout {
+	bounds := img.Bounds()
+	l := cfg.Layout(bounds.Dx(), bounds.Dy())
+
+	background := cfg.Background
+	if background == nil {
+		background = color.White
+	}
+	// fill is what shows around the image, including its rounded corners.
+	var fill image.Image = image.NewUniform(background)
+	if cfg.Blur {
+		backdrop := image.NewRGBA(canvas.Bounds())
+		draw.Draw(backdrop, backdrop.Bounds(), fill, image.Point{}, draw.Src)
+		draw.Draw(backdrop, backdrop.Bounds(), blurredBackdrop(img, cfg.Width, cfg.Height), image.Point{}, draw.Over)
+		fill = backdrop
+	}
+	draw.Draw(canvas, canvas.Bounds(), fill, image.Point{}, draw.Src)
+
+	if l.Dest.Size() == bounds.Size() {
+		// Nothing to resample: copy the pixels 1:1.
+		draw.Draw(canvas, l.Dest, img, bounds.Min, draw.Over)
+	} else {
+		kernel := cfg.Kernel
+		if kernel == nil {
+			kernel = draw.ApproxBiLinear
+		}
+		kernel.Scale(canvas, l.Dest, img, bounds, draw.Over, nil)
+	}
+	if cfg.CornerRadius > 0 {
+		mask := cornerMask{rect: l.Dest, radius: cfg.CornerRadius}
+		draw.DrawMask(canvas, l.Dest, fill, l.Dest.Min, mask, l.Dest.Min, draw.Over)
+	}
+	return l
+}
+
+// cornerMask is an alpha mask over rect that is opaque outside its corners
+// rounded by radius and transparent inside, antialiased along the arcs.
+// The radius is capped at half the shorter side of rect.
+type cornerMask struct {
+	rect   image.Rectangle
+	radius int
+}
+
+func (m cornerMask) ColorModel() color.Model { return color.AlphaModel }
+
+func (m cornerMask) Bounds() image.Rectangle { return m.rect }
+
+func (m cornerMask) At(x, y int) color.Color {
+	return color.Alpha{A: uint8(math.Round(255 * (1 - Coverage(m.rect, m.radius, x, y))))}
+}
+
+// Coverage returns how much, 0 to 1, of the pixel at (x, y) shows the image
+// placed at dest with its corners rounded by radius, rather than the border
+// behind them.
+func Coverage(dest image.Rectangle, radius, x, y int) float64 {
+	if !image.Pt(x, y).In(dest) {
+		return 0
+	}
+	r := min(radius, dest.Dx()/2, dest.Dy()/2)
+	// The center of the arc for the corner nearest (x, y), if any.
+	cx, cy := dest.Min.X+r, dest.Min.Y+r
+	if x >= dest.Max.X-r {
+		cx = dest.Max.X - r
+	} else if x >= cx {
+		return 1
+	}
+	if y >= dest.Max.Y-r {
+		cy = dest.Max.Y - r
+	} else if y >= cy {
+		return 1
+	}
+	// Distance from the pixel center to the arc center.
+	dx := float64(x) + 0.5 - float64(cx)
+	if x < cx {
+		dx = float64(cx) - float64(x) - 0.5
+	}
+	dy := float64(y) + 0.5 - float64(cy)
+	if y < cy {
+		dy = float64(cy) - float64(y) - 0.5
+	}
+	return math.Max(0, math.Min(1, float64(r)+0.5-math.Hypot(dx, dy)))
+}
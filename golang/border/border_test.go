@@ -0,0 +1,148 @@
+package border
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// solid returns a width x height image of c.
+func solid(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// square returns a width x height config with the same border ratio on
+// every side.
+func square(width, height int, ratio float64) Config {
+	return Config{
+		Width: width, Height: height,
+		LandscapeVert: ratio, LandscapeHoriz: ratio,
+		PortraitVert: ratio, PortraitHoriz: ratio,
+	}
+}
+
+func TestAddBorder(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	cfg := square(300, 240, 0.1)
+	out, err := AddBorder(solid(200, 100, red), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Bounds(); got != image.Rect(0, 0, 300, 240) {
+		t.Fatalf("bounds = %v, want the 300x240 canvas", got)
+	}
+	if _, ok := out.(*image.RGBA); !ok {
+		t.Errorf("AddBorder returned %T, want *image.RGBA", out)
+	}
+
+	// The 2:1 image fills the 240 pixels between the side borders and is
+	// centered vertically.
+	want := image.Rect(30, 60, 270, 180)
+	if l := cfg.Layout(200, 100); l.Dest != want || !l.Landscape {
+		t.Fatalf("Layout = %+v, want a landscape at %v", l, want)
+	}
+	for _, p := range []image.Point{{0, 0}, {299, 239}, {29, 120}, {150, 59}, {270, 120}, {150, 180}} {
+		if got := color.RGBAModel.Convert(out.At(p.X, p.Y)); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("border pixel %v = %v, want white", p, got)
+		}
+	}
+	for _, p := range []image.Point{want.Min, want.Max.Sub(image.Pt(1, 1)), {150, 120}} {
+		if got := color.RGBAModel.Convert(out.At(p.X, p.Y)); got != red {
+			t.Errorf("image pixel %v = %v, want red", p, got)
+		}
+	}
+}
+
+func TestAddBorderErrors(t *testing.T) {
+	img := solid(10, 10, color.Black)
+	tests := []struct {
+		name string
+		img  image.Image
+		cfg  Config
+	}{
+		{"no canvas", img, square(0, 100, 0.1)},
+		{"borders fill the canvas", img, square(100, 100, 0.5)},
+		{"bad gravity", img, func() Config { c := square(100, 100, 0.1); c.Gravity = "up"; return c }()},
+		{"nil image", nil, square(100, 100, 0.1)},
+		{"empty image", image.NewRGBA(image.Rect(0, 0, 0, 0)), square(100, 100, 0.1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AddBorder(tt.img, tt.cfg); err == nil {
+				t.Error("AddBorder() = nil error")
+			}
+		})
+	}
+}
+
+func TestLayoutAsymmetricBorders(t *testing.T) {
+	top, bottom := 0.05, 0.25
+	cfg := square(400, 400, 0.1)
+	cfg.Top, cfg.Bottom = &top, &bottom
+
+	// A square image: the height between the 20px top and 100px bottom
+	// borders (280px) limits it, and it sits right below the top border.
+	l := cfg.Layout(100, 100)
+	if want := image.Rect(60, 20, 340, 300); l.Dest != want {
+		t.Errorf("Dest = %v, want %v", l.Dest, want)
+	}
+
+	// A wide image is limited by the 40px side borders and centered in the
+	// 280px between top and bottom, above the canvas center.
+	l = cfg.Layout(320, 100)
+	if want := image.Rect(40, 110, 360, 210); l.Dest != want {
+		t.Errorf("Dest = %v, want %v", l.Dest, want)
+	}
+
+	// Left and right work the same way: a tall image is centered in the
+	// 280px right of a 120px left border.
+	left, right := 0.3, 0.0
+	cfg = square(400, 400, 0.1)
+	cfg.Left, cfg.Right = &left, &right
+	l = cfg.Layout(100, 200)
+	if want := image.Rect(180, 40, 340, 360); l.Dest != want {
+		t.Errorf("Dest = %v, want %v", l.Dest, want)
+	}
+}
+
+func TestCornerRadius(t *testing.T) {
+	red, bg := color.RGBA{255, 0, 0, 255}, color.RGBA{10, 20, 30, 255}
+	cfg := square(300, 300, 0.1)
+	cfg.Background, cfg.CornerRadius = bg, 40
+	canvas, l := Render(solid(100, 100, red), cfg)
+	d := l.Dest
+	if d != image.Rect(30, 30, 270, 270) {
+		t.Fatalf("Dest = %v", d)
+	}
+
+	// The corner pixels of the placed image show the border; so does the
+	// diagonal just inside each corner, up to the arc.
+	for _, p := range []image.Point{
+		d.Min, {d.Max.X - 1, d.Min.Y}, {d.Min.X, d.Max.Y - 1}, d.Max.Sub(image.Pt(1, 1)),
+		d.Min.Add(image.Pt(8, 8)), d.Max.Sub(image.Pt(9, 9)),
+	} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != bg {
+			t.Errorf("corner pixel %v = %v, want the border color", p, got)
+		}
+	}
+	// The center, the middle of each edge and the arc's inside are the
+	// image.
+	for _, p := range []image.Point{
+		{150, 150}, {150, d.Min.Y}, {d.Min.X, 150}, {150, d.Max.Y - 1}, {d.Max.X - 1, 150},
+		d.Min.Add(image.Pt(40, 40)), d.Min.Add(image.Pt(40, 0)),
+	} {
+		if got := canvas.RGBAAt(p.X, p.Y); got != red {
+			t.Errorf("pixel %v = %v, want the image", p, got)
+		}
+	}
+	// Along the arc, pixels blend the two.
+	if got := canvas.RGBAAt(d.Min.X+11, d.Min.Y+11); got == red || got == bg {
+		t.Errorf("pixel on the arc = %v, want a blend", got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// exitInterrupted is the exit status of a run stopped by SIGINT or SIGTERM,
+// as a shell reports for SIGINT.
+const exitInterrupted = 130
+
+// errInterrupted is returned by a run that was stopped before every image
+// was processed.
+var errInterrupted = errors.New("interrupted")
+
+// inFlightOutputs holds the temporary files local outputs are being written
+// to, so a forced stop can remove them instead of leaving them behind.
+var inFlightOutputs sync.Map
+
+// interruptContext returns a context canceled on the first SIGINT or
+// SIGTERM. The outputs being written are finished and the other images
+// skipped; a second signal removes those outputs and exits at once.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Println("\n🛑 Interrupted: finishing the outputs being written, press Ctrl-C again to stop now")
+		cancel()
+		<-signals
+		inFlightOutputs.Range(func(path, _ any) bool {
+			os.Remove(path.(string))
+			return true
+		})
+		fmt.Println("\n🛑 Stopped: outputs in progress removed")
+		os.Exit(exitInterrupted)
+	}()
+	return ctx
+}
+
+// printInterrupted reports how many of the total images of a run were
+// skipped because it was interrupted.
+func (ps *processingStats) printInterrupted(total int) {
+	done := ps.totalImages.Load() + ps.failedImages.Load()
+	fmt.Printf("🛑 Interrupted: %d of %d image(s) not processed\n", int64(total)-done, total)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelSkipsRemainingJobs(t *testing.T) {
+	dir := t.TempDir()
+	const images = 20
+	for i := 0; i < images; i++ {
+		writeImage(t, filepath.Join(dir, fmt.Sprintf("img%02d.jpg", i)), noise(200, 150))
+	}
+
+	config := testConfig()
+	config.maxWorkers = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var done []string
+	var err error
+	printed := captureStdout(t, func() {
+		err = runFolder(ctx, config, dir, time.Now(), func(r processingResult, total int) {
+			done = append(done, r.filename)
+			if len(done) == 3 {
+				cancel()
+			}
+		})
+	})
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("runFolder() = %v, want errInterrupted", err)
+	}
+	// The image in the worker's hands when the run was canceled may still
+	// be finished, but nothing after it is started.
+	if len(done) < 3 || len(done) > 5 {
+		t.Fatalf("%d images processed after canceling at the third, want 3 to 5", len(done))
+	}
+
+	outputs, err := os.ReadDir(filepath.Join(dir, "bordered_images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range outputs {
+		if strings.HasPrefix(o.Name(), ".wbi-output-") {
+			t.Errorf("temporary file %s left behind", o.Name())
+		}
+	}
+	if len(outputs) != len(done) {
+		t.Errorf("%d outputs for %d processed images", len(outputs), len(done))
+	}
+	if want := fmt.Sprintf("%d of %d image(s) not processed", images-len(done), images); !strings.Contains(printed, want) {
+		t.Errorf("summary does not say %q:\n%s", want, printed)
+	}
+}
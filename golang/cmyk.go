@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+)
+
+// adobeAPP14 is an Adobe APP14 segment with transform 0 (no color
+// transform). image/jpeg refuses 4-component JPEGs without one, since it
+// cannot tell CMYK from YCCK.
+var adobeAPP14 = jpegSegment(0xee, []byte("Adobe\x00\x64\x00\x00\x00\x00\x00"))
+
+// isMissingAdobeError reports whether err is image/jpeg refusing a
+// 4-component JPEG that has no Adobe APP14 segment.
+func isMissingAdobeError(err error) bool {
+	var unsupported jpeg.UnsupportedError
+	return errors.As(err, &unsupported) && strings.Contains(string(unsupported), "APP14")
+}
+
+// decodePlainCMYK decodes a 4-component JPEG without an Adobe APP14
+// segment, as written by libjpeg and most tools other than Photoshop. Its
+// samples are plain CMYK, where 0 means no ink, unlike the inverted
+// samples of Adobe files: the image is decoded as if it had an Adobe
+// segment, which makes image/jpeg undo an inversion that was never
+// applied, and the inversion is then reverted.
+func decodePlainCMYK(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	patched := append(append(append([]byte{}, data[:2]...), adobeAPP14...), data[2:]...)
+	img, _, err := decodeSafely(bytes.NewReader(patched))
+	if err != nil {
+		return nil, err
+	}
+	cmyk, ok := img.(*image.CMYK)
+	if !ok {
+		return img, nil
+	}
+	for i := range cmyk.Pix {
+		cmyk.Pix[i] = 255 - cmyk.Pix[i]
+	}
+	return cmyk, nil
+}
+
+// cmykToRGBA converts a decoded CMYK image to RGBA before it is scaled,
+// so the resampler and the JPEG encoder only ever see RGB and outputs are
+// plain YCbCr JPEGs.
+func cmykToRGBA(src *image.CMYK) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		s := src.Pix[src.PixOffset(b.Min.X, y):]
+		d := dst.Pix[dst.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			c, m, ye, k := uint32(s[4*x]), uint32(s[4*x+1]), uint32(s[4*x+2]), uint32(s[4*x+3])
+			w := 255 - k
+			d[4*x] = uint8((255 - c) * w / 255)
+			d[4*x+1] = uint8((255 - m) * w / 255)
+			d[4*x+2] = uint8((255 - ye) * w / 255)
+			d[4*x+3] = 255
+		}
+	}
+	return dst
+}
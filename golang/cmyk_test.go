@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// meanColor returns the average color of r in img.
+func meanColor(img image.Image, r image.Rectangle) color.RGBA {
+	var sr, sg, sb, n uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			sr, sg, sb, n = sr+uint64(c.R), sg+uint64(c.G), sb+uint64(c.B), n+1
+		}
+	}
+	return color.RGBA{uint8(sr / n), uint8(sg / n), uint8(sb / n), 255}
+}
+
+func TestCMYKJPEG(t *testing.T) {
+	// The fixture is an Adobe CMYK JPEG from the Go standard library's
+	// image tests, with its reference rendering as RGB.
+	data, err := os.ReadFile(filepath.Join("testdata", "video-001.cmyk.jpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "press.jpg")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := readImage(t, filepath.Join("testdata", "video-001.cmyk.png"))
+
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 300, 300
+	job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.jpg")}
+	info, err := processImage(context.Background(), job, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(job.outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.(*image.YCbCr); !ok {
+		t.Fatalf("output decodes as %T, want a plain YCbCr JPEG", out)
+	}
+
+	// Compare each quarter of the photo with the same quarter of the
+	// reference, which shifted colors would miss by far more.
+	dest, src := info.layout.destRect, want.Bounds()
+	for _, q := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		quarter := func(r image.Rectangle) image.Rectangle {
+			w, h := r.Dx()/2, r.Dy()/2
+			origin := r.Min.Add(image.Pt(q[0]*w, q[1]*h))
+			return image.Rectangle{origin, origin.Add(image.Pt(w, h))}.Inset(2)
+		}
+		got, ref := meanColor(out, quarter(dest)), meanColor(want, quarter(src))
+		if !near(got, ref, 8) {
+			t.Errorf("quarter %v of the photo averages %v, want about %v", q, got, ref)
+		}
+	}
+}
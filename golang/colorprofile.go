@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The -color-profile values.
+const (
+	colorProfileKeep = "keep"
+	colorProfileSRGB = "srgb"
+	colorProfileNone = "none"
+)
+
+const (
+	// iccChunkSize is the most profile data one JPEG APP2 segment holds:
+	// the 65533-byte payload less the "ICC_PROFILE\0" prefix and the two
+	// sequence bytes.
+	iccChunkSize = 65519
+	// maxICCProfileBytes caps the profile read from a PNG iCCP chunk.
+	maxICCProfileBytes = 16 << 20
+)
+
+// colorProfileFor returns the segments (JPEG) or chunk (PNG) tagging an
+// output with extension ext with the color profile chosen by
+// -color-profile, or nil when none is written: with none, for other output
+// formats, and with keep when the input at inputPath has no profile.
+// Outputs converted by -convert-srgb are always tagged as sRGB. Grayscale
+// outputs only keep grayscale profiles, and JPEGs among them are not
+// tagged as sRGB, whose profile is an RGB one.
+//
+// The pixels are written as decoded, so the border stays at full white
+// (255, 255, 255), the media white of whichever profile is written.
+func (c *Config) colorProfileFor(inputPath, ext string, converted, gray bool) ([]byte, error) {
+	isPNG := ext == ".png"
+	if !isPNG && ext != ".jpg" && ext != ".jpeg" {
+		return nil, nil
+	}
+	mode := c.colorProfile
+	if converted {
+		mode = colorProfileSRGB
+	}
+	var profile []byte
+	switch mode {
+	case colorProfileKeep:
+		var err error
+		if profile, err = readICCProfile(inputPath); err != nil || profile == nil {
+			return nil, err
+		}
+		// The pixels of CMYK inputs are converted to RGB, so their profile
+		// no longer describes them.
+		if len(profile) < 20 || string(profile[16:20]) != "RGB " && string(profile[16:20]) != "GRAY" {
+			return nil, nil
+		}
+		if gray && string(profile[16:20]) != "GRAY" {
+			return nil, nil
+		}
+	case colorProfileSRGB:
+		if isPNG {
+			// PNG has a chunk of its own for sRGB, with perceptual intent.
+			return pngChunk("sRGB", []byte{0}), nil
+		}
+		if gray {
+			return nil, nil
+		}
+		profile = srgbICCProfile()
+	default:
+		return nil, nil
+	}
+	if isPNG {
+		return iccPNGChunk(profile)
+	}
+	return iccJPEGSegments(profile), nil
+}
+
+// readICCProfile returns the ICC profile embedded in the JPEG or PNG at
+// path, or nil when it has none.
+func readICCProfile(path string) ([]byte, error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	head, err := r.Peek(8)
+	if err != nil {
+		return nil, nil
+	}
+	switch {
+	case bytes.Equal(head, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNGICCProfile(r)
+	case head[0] == 0xff && head[1] == 0xd8:
+		return readJPEGICCProfile(r), nil
+	}
+	return nil, nil
+}
+
+// readJPEGICCProfile reassembles the profile split across APP2 segments,
+// or returns nil if a chunk is missing.
+func readJPEGICCProfile(r *bufio.Reader) []byte {
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	var count byte
+	scanJPEGSegments(r, func(marker byte) bool { return marker == 0xe2 }, func(_ byte, payload []byte) bool {
+		if bytes.HasPrefix(payload, iccAPP2Prefix) && len(payload) > len(iccAPP2Prefix)+2 {
+			n := len(iccAPP2Prefix)
+			chunks = append(chunks, chunk{seq: payload[n], data: payload[n+2:]})
+			count = payload[n+1]
+		}
+		return true
+	})
+	if len(chunks) == 0 || len(chunks) != int(count) {
+		return nil
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	var profile []byte
+	for i, c := range chunks {
+		if int(c.seq) != i+1 {
+			return nil
+		}
+		profile = append(profile, c.data...)
+	}
+	return profile
+}
+
+// readPNGICCProfile returns the decompressed profile of the iCCP chunk
+// before the image data, if any.
+func readPNGICCProfile(r io.Reader) ([]byte, error) {
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil {
+		return nil, nil
+	}
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return nil, nil
+		}
+		length := binary.BigEndian.Uint32(hdr)
+		typ := string(hdr[4:])
+		if typ == "IDAT" || typ == "IEND" || length > 1<<24 {
+			return nil, nil
+		}
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, nil
+		}
+		if typ != "iCCP" {
+			continue
+		}
+		// Profile name, NUL, compression method (0 = zlib), profile.
+		name := bytes.IndexByte(data[:length], 0)
+		if name < 0 || int(length) < name+2 {
+			return nil, nil
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[name+2 : length]))
+		if err != nil {
+			return nil, fmt.Errorf("reading ICC profile: %v", err)
+		}
+		profile, err := io.ReadAll(io.LimitReader(zr, maxICCProfileBytes))
+		if err != nil {
+			return nil, fmt.Errorf("reading ICC profile: %v", err)
+		}
+		return profile, nil
+	}
+}
+
+// iccJPEGSegments splits profile into the APP2 segments that carry it in a
+// JPEG.
+func iccJPEGSegments(profile []byte) []byte {
+	count := (len(profile) + iccChunkSize - 1) / iccChunkSize
+	var segments []byte
+	for i := 0; i < count; i++ {
+		end := (i + 1) * iccChunkSize
+		if end > len(profile) {
+			end = len(profile)
+		}
+		data := profile[i*iccChunkSize : end]
+		payload := append(append([]byte{}, iccAPP2Prefix...), byte(i+1), byte(count))
+		segments = append(segments, jpegSegment(0xe2, append(payload, data...))...)
+	}
+	return segments
+}
+
+// iccPNGChunk builds the iCCP chunk carrying profile in a PNG.
+func iccPNGChunk(profile []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("ICC profile\x00\x00")
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return pngChunk("iCCP", buf.Bytes()), nil
+}
+
+// pngChunk builds a PNG chunk of type typ.
+func pngChunk(typ string, data []byte) []byte {
+	body := append([]byte(typ), data...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, body...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+}
+
+// srgbICCProfile returns a compact ICC v2 display profile for sRGB: the
+// IEC 61966-2-1 primaries adapted to D50, a D65 media white and the sRGB
+// tone curve sampled at 1024 points.
+var srgbICCProfile = sync.OnceValue(func() []byte {
+	s15f16 := func(v float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+	}
+	xyz := func(x, y, z float64) []byte {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		return append(append(append(tag, s15f16(x)...), s15f16(y)...), s15f16(z)...)
+	}
+	const description = "sRGB IEC61966-2.1"
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(append(desc, description...), 0)
+	// Empty Unicode and ScriptCode descriptions.
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+
+	curve := []byte("curv\x00\x00\x00\x00")
+	const points = 1024
+	curve = binary.BigEndian.AppendUint32(curve, points)
+	for i := 0; i < points; i++ {
+		v := float64(i) / (points - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		curve = binary.BigEndian.AppendUint16(curve, uint16(math.Round(v*65535)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", append([]byte("text\x00\x00\x00\x00No copyright, use freely"), 0)},
+		{"wtpt", xyz(0.9505, 1.0, 1.0891)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// The three tone curves share one copy of the data.
+	offsets := make(map[string]int)
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	base := 128 + 4 + 12*len(tags)
+	for _, t := range tags {
+		offset, ok := offsets[string(t.data)]
+		if !ok {
+			offset = base + len(data)
+			offsets[string(t.data)] = offset
+			data = append(data, t.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(base+len(data)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[36:], "acsp")
+	// The PCS illuminant, D50.
+	copy(header[68:], xyz(0.9642, 1.0, 0.8249)[8:])
+	return append(append(header, table...), data...)
+})
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileExcludedFlags cannot be set from a -config file: they choose
+// where settings come from or start an interactive session.
+var configFileExcludedFlags = map[string]bool{
+	"config":      true,
+	"preset":      true,
+	"profile":     true,
+	"save-preset": true,
+	"wizard":      true,
+}
+
+// flagAliases maps each alias flag to the flag it stands for.
+var flagAliases = map[string]string{
+	"profile":       "preset",
+	"color":         "background",
+	"output-format": "output-encoding",
+	"keep-metadata": "preserve-metadata",
+	"scaler":        "resample",
+}
+
+// loadConfigFile reads the settings of a -config file, YAML (.yaml, .yml)
+// or JSON (.json) by extension, as flag values keyed by flag name. Keys may
+// also be written with underscores, like the marker's landscape_vert. A
+// file setting both a flag and its alias is rejected, since either could
+// be meant.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %v", err)
+	}
+
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s must end in .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(doc))
+	for key, v := range doc {
+		switch v.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("parsing %s: %s must be a single value", path, key)
+		case nil:
+			continue
+		}
+		values[strings.ReplaceAll(key, "_", "-")] = fmt.Sprint(v)
+	}
+	for alias, name := range flagAliases {
+		_, hasAlias := values[alias]
+		if _, hasName := values[name]; hasAlias && hasName {
+			return nil, fmt.Errorf("parsing %s: %s and its alias %s are both set", path, name, alias)
+		}
+	}
+	return values, nil
+}
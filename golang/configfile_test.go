@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigFile(t *testing.T) {
+	files := map[string]string{
+		"settings.yaml": "width: 1600\nheight: 1200\nlandscape_vert: 0.12\njpeg-quality: 80\nbackground: \"#102030\"\nverbose: true\n",
+		"settings.json": `{"width": 1600, "height": 1200, "landscape_vert": 0.12, "jpeg-quality": 80, "background": "#102030", "verbose": true}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The file fills in what the command line leaves out; explicit
+			// flags win over it.
+			config, input := parseArgs(t, "-config", path, "-jpeg-quality", "70", "photos")
+			if input != "photos" {
+				t.Errorf("input folder %q, want photos", input)
+			}
+			for _, c := range []struct {
+				name      string
+				got, want any
+			}{
+				{"width", config.targetWidth, 1600},
+				{"height", config.targetHeight, 1200},
+				{"landscape-vert", config.landscapeVertBorder, 0.12},
+				{"portrait-vert", config.portraitVertBorder, defaultConfig.portraitVertBorder},
+				{"jpeg-quality", config.jpegQuality, 70},
+				{"background", config.backgroundColor, color.RGBA{0x10, 0x20, 0x30, 255}},
+				{"verbose", config.verbose, true},
+			} {
+				if c.got != c.want {
+					t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, content, wantErr string
+	}{
+		{"missing.yaml", "", "reading config file"},
+		{"broken.json", `{"width": `, "parsing"},
+		{"broken.yaml", "width: [1600\n", "parsing"},
+		{"nested.yaml", "size:\n  width: 1600\n", "single value"},
+		{"settings.toml", "width = 1600\n", ".yaml, .yml or .json"},
+		{"aliases.yaml", "color: black\nbackground: white\n", "background and its alias color are both set"},
+		{"aliases.json", `{"output_format": "png", "output-encoding": "jpeg"}`, "output-encoding and its alias output-format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := loadConfigFile(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadConfigFile() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigFileAliasGivenOnCommandLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.yaml")
+	if err := os.WriteFile(path, []byte("background: \"#102030\"\nscaler: nearest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// -color on the command line wins over the file's background, and the
+	// file's -scaler still sets -resample.
+	config, _ := parseArgs(t, "-config", path, "-color", "black", "photos")
+	if config.background != "black" {
+		t.Errorf("background %q, want black from -color", config.background)
+	}
+	if config.resample != resampleNearest {
+		t.Errorf("resample %q, want nearest from the file's scaler", config.resample)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// csvReportHeader names the columns of the -report file.
+var csvReportHeader = []string{"filename", "output_path", "duration_seconds", "status", "error"}
+
+// csvReport writes the -report file, one row per image, as results arrive.
+// Like the manifest, it is created before the run, so a run that fails or
+// is interrupted still leaves a report of everything finished so far, and
+// an ordered report holds its rows back to write them in input order.
+type csvReport struct {
+	f       *os.File
+	w       *csv.Writer
+	ordered bool
+	pending []processingResult
+}
+
+func newCSVReport(path string, ordered bool) (*csvReport, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(csvReportHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvReport{f: f, w: w, ordered: ordered}, nil
+}
+
+func (c *csvReport) add(results []processingResult) error {
+	if c.ordered {
+		c.pending = append(c.pending, results...)
+		return nil
+	}
+	return c.write(results)
+}
+
+func (c *csvReport) write(results []processingResult) error {
+	for _, r := range results {
+		status, errText := "ok", ""
+		switch {
+		case r.duplicateOf != "":
+			status = "duplicate"
+		case r.skipped:
+			status = "skipped"
+		case r.error != nil:
+			status, errText = "failed", r.error.Error()
+		}
+		row := []string{r.filename, r.outputPath, strconv.FormatFloat(r.duration.Seconds(), 'f', 3, 64), status, errText}
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvReport) close() error {
+	if c.ordered {
+		sortResults(c.pending)
+		if err := c.write(c.pending); err != nil {
+			c.f.Close()
+			return err
+		}
+	}
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
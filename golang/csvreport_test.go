@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCSVReportRowPerImage(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(input, "a.jpg"), fill(40, 30, color.RGBA{200, 0, 0, 255}))
+	writeImage(t, filepath.Join(input, "b.png"), fill(30, 40, color.RGBA{0, 200, 0, 255}))
+	if err := os.WriteFile(filepath.Join(input, "broken.jpg"), []byte("not a JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.reportPath = filepath.Join(dir, "report.csv")
+	captureStdout(t, func() {
+		if err := runFolder(context.Background(), config, input, time.Now(), nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	f, err := os.Open(config.reportPath)
+	if err != nil {
+		t.Fatalf("report not written when an image failed: %v", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rows[0], csvReportHeader) {
+		t.Fatalf("header %v, want %v", rows[0], csvReportHeader)
+	}
+	rows = rows[1:]
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want one per image: %v", len(rows), rows)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	for i, want := range []struct{ filename, status string }{
+		{"a.jpg", "ok"},
+		{"b.png", "ok"},
+		{"broken.jpg", "failed"},
+	} {
+		row := rows[i]
+		if row[0] != want.filename || row[3] != want.status {
+			t.Errorf("row %v, want %s %s", row, want.filename, want.status)
+			continue
+		}
+		if _, err := strconv.ParseFloat(row[2], 64); err != nil {
+			t.Errorf("%s: duration %q: %v", row[0], row[2], err)
+		}
+		if want.status == "failed" {
+			if row[4] == "" {
+				t.Errorf("%s: failed row has no error", row[0])
+			}
+			continue
+		}
+		if row[4] != "" {
+			t.Errorf("%s: ok row has error %q", row[0], row[4])
+		}
+		if _, err := os.Stat(row[1]); err != nil {
+			t.Errorf("%s: output_path: %v", row[0], err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+)
+
+// contentSet remembers the SHA-256 of every input file seen in a -dedup
+// run, with the file it was first seen in.
+type contentSet struct {
+	mu   sync.Mutex
+	seen map[[sha256.Size]byte]string
+}
+
+// dedup is shared by every worker; it is nil until main sets it for
+// -dedup, which leaves identical inputs to be processed each.
+var dedup *contentSet
+
+func newContentSet() *contentSet {
+	return &contentSet{seen: make(map[[sha256.Size]byte]string)}
+}
+
+// claim hashes the file at path and returns the input the same content
+// was first seen in, if another file, or "". processBatches claims the
+// inputs in the order it hands them out, so the first of a set of
+// identical files is processed whatever the number of workers. The pages
+// and -size outputs of one input all claim it, and none is a duplicate of
+// the others. A file that cannot be read is left to fail when it is
+// decoded.
+func (s *contentSet) claim(path string) string {
+	if s == nil {
+		return ""
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if first, ok := s.seen[sum]; ok && first != path {
+		return first
+	} else if !ok {
+		s.seen[sum] = path
+	}
+	return ""
+}
+
+// hashFile returns the SHA-256 of the file at path, read inside an
+// openFiles slot.
+func hashFile(path string) ([sha256.Size]byte, error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDedupSkipsIdenticalFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "a.jpg"), fill(60, 40, color.RGBA{200, 0, 0, 255}))
+	data, err := os.ReadFile(filepath.Join(dir, "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.jpg"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(dir, "c.jpg"), fill(60, 40, color.RGBA{0, 0, 200, 255}))
+
+	dedup = newContentSet()
+	defer func() { dedup = nil }()
+	config := testConfig()
+	config.dedup = true
+	duplicates := make(map[string]string)
+	captureStdout(t, func() {
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			if r.error != nil {
+				t.Errorf("%s: %v", r.filename, r.error)
+			}
+			duplicates[r.filename] = r.duplicateOf
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	if len(duplicates) != 3 {
+		t.Fatalf("got results for %v, want a.jpg, b.jpg and c.jpg", duplicates)
+	}
+	if duplicates["a.jpg"] != "" || duplicates["c.jpg"] != "" {
+		t.Errorf("a.jpg and c.jpg reported as duplicates: %v", duplicates)
+	}
+	if filepath.Base(duplicates["b.jpg"]) != "a.jpg" {
+		t.Errorf("b.jpg is a duplicate of %q, want a.jpg", duplicates["b.jpg"])
+	}
+	for name, want := range map[string]bool{"a.jpg": true, "b.jpg": false, "c.jpg": true} {
+		_, err := os.Stat(filepath.Join(dir, "bordered_images", "bordered_"+name))
+		if (err == nil) != want {
+			t.Errorf("output of %s written: %v, want %v", name, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// maxPlanLog is the number of planned outputs listed in the summary of a
+// -dry-run; later ones are only counted.
+const maxPlanLog = 100
+
+// plannedOutput is what the summary of a -dry-run prints about one image.
+type plannedOutput struct {
+	filename   string
+	outputPath string
+	layout     layout
+}
+
+// validateDryRun rejects options that write files of their own, which a
+// -dry-run promises not to do.
+func (c *Config) validateDryRun() error {
+	if !c.dryRun {
+		return nil
+	}
+	switch {
+	case c.jobsPath != "":
+		return fmt.Errorf("-dry-run works on an input folder, not with -jobs")
+	case c.tune || c.diffSettings:
+		return fmt.Errorf("-dry-run cannot be combined with -tune or -diff-settings")
+	case c.htmlReport || c.manifestPath != "" || c.reportPath != "" || c.sidecarsJSON || c.qaSample > 0:
+		return fmt.Errorf("-dry-run writes nothing, so -html-report, -manifest, -report, -sidecars-json and -qa-sample do not apply")
+	}
+	return nil
+}
+
+// planImage decodes the image of job and computes where it would land on
+// the canvas, without rendering or writing the output.
+func planImage(job imageJob, config *Config) (imageInfo, error) {
+	var info imageInfo
+
+	if _, err := decodeInput(job.inputPath, job.page, config, &info); err != nil {
+		return info, err
+	}
+	info.outputWidth, info.outputHeight = config.targetWidth, config.targetHeight
+	info.layout = computeLayout(info.sourceWidth, info.sourceHeight, config)
+	info.luminance, info.edgeBlend = -1, -1
+	info.planned = true
+	return info, nil
+}
+
+// printPlan lists the outputs a -dry-run would have written.
+func (ps *processingStats) printPlan() {
+	fmt.Printf("\n🗺️  Planned outputs (dry run, nothing written):\n")
+	for _, p := range ps.planned {
+		d := p.layout.destRect
+		fmt.Printf("   %s → %s (image scaled to %dx%d at %d,%d)\n",
+			p.filename, p.outputPath, d.Dx(), d.Dy(), d.Min.X, d.Min.Y)
+	}
+	if ps.omittedPlans > 0 {
+		fmt.Printf("   ... and %d more\n", ps.omittedPlans)
+	}
+}
+
+// planLine is the per-image log line of a -dry-run.
+func planLine(info imageInfo, inputPath, outputPath string) string {
+	d := info.layout.destRect
+	return fmt.Sprintf("📝 Would write %s from %s (%dx%d scaled to %dx%d)",
+		filepath.Base(outputPath), filepath.Base(inputPath), info.sourceWidth, info.sourceHeight, d.Dx(), d.Dy())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("img%d.jpg", i)
+		writeImage(t, filepath.Join(dir, name), fill(60+20*i, 40, color.RGBA{0, 120, uint8(60 * i), 255}))
+		names = append(names, name)
+	}
+	before := hashTree(t, dir)
+
+	config := testConfig()
+	config.dryRun = true
+	var results []processingResult
+	printed := captureStdout(t, func() {
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			results = append(results, r)
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	if len(results) != len(names) {
+		t.Fatalf("got %d results, want %d", len(results), len(names))
+	}
+	for _, r := range results {
+		if r.error != nil || !r.info.planned {
+			t.Errorf("%s: planned %v, error %v", r.filename, r.info.planned, r.error)
+		}
+	}
+	after := hashTree(t, dir)
+	if len(after) != len(before) {
+		t.Errorf("dry run left %d files, want the %d inputs", len(after), len(before))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bordered_images")); !os.IsNotExist(err) {
+		t.Errorf("dry run created the output folder (%v)", err)
+	}
+	// The summary lists each planned output instead.
+	for _, name := range names {
+		if !strings.Contains(printed, "bordered_"+name) {
+			t.Errorf("summary does not list the output of %s:\n%s", name, printed)
+		}
+	}
+}
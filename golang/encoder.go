@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// What -encoder-cmd reads on its stdin, selected with -encoder-input.
+const (
+	// encoderInputPNG is a lossless PNG of the canvas, which mozjpeg's
+	// cjpeg reads.
+	encoderInputPNG = "png"
+	// encoderInputPPM is a binary PPM, which every libjpeg cjpeg reads.
+	encoderInputPPM = "ppm"
+	// encoderInputRGBA is the bare pixels, 4 bytes per pixel row by row,
+	// for commands told the size with {width} and {height}.
+	encoderInputRGBA = "rgba"
+)
+
+// validateEncoderCmd checks -encoder-cmd, -encoder-input and
+// -encoder-strict.
+func (c *Config) validateEncoderCmd() error {
+	if c.encoderCmd == "" {
+		if c.encoderStrict {
+			return fmt.Errorf("-encoder-strict needs -encoder-cmd")
+		}
+		return nil
+	}
+	fields := strings.Fields(c.encoderCmd)
+	switch {
+	case len(fields) == 0:
+		return fmt.Errorf("-encoder-cmd is empty")
+	case c.encoderInput != encoderInputPNG && c.encoderInput != encoderInputPPM && c.encoderInput != encoderInputRGBA:
+		return fmt.Errorf("invalid -encoder-input value %q (expected png, ppm or rgba)", c.encoderInput)
+	case c.targetSSIM > 0 || c.autoShrink:
+		return fmt.Errorf("-encoder-cmd cannot be combined with -target-ssim or -auto-shrink, which search qualities with the built-in encoder")
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return fmt.Errorf("-encoder-cmd: %s was not found in PATH", fields[0])
+	}
+	return nil
+}
+
+// encoderArgs splits command on spaces and fills in the {quality},
+// {width} and {height} placeholders of each argument. The command is run
+// directly, not through a shell.
+func encoderArgs(command string, quality, width, height int) []string {
+	r := strings.NewReplacer(
+		"{quality}", strconv.Itoa(quality),
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height),
+	)
+	args := strings.Fields(command)
+	for i, arg := range args {
+		args[i] = r.Replace(arg)
+	}
+	return args
+}
+
+// runEncoderCmd encodes canvas as JPEG with -encoder-cmd: the canvas is
+// piped to the command's stdin as -encoder-input and the JPEG read back
+// from its stdout. Output that is not a JPEG counts as a failure, like a
+// non-zero exit status.
+func (c *Config) runEncoderCmd(canvas image.Image, quality int) ([]byte, error) {
+	b := canvas.Bounds()
+	args := encoderArgs(c.encoderCmd, quality, b.Dx(), b.Dy())
+
+	var stdin bytes.Buffer
+	if c.encoderInput == encoderInputPNG {
+		// Only piped, so favor speed over size.
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := enc.Encode(&stdin, canvas); err != nil {
+			return nil, err
+		}
+	} else {
+		rgba, ok := canvas.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(b)
+			draw.Draw(rgba, b, canvas, b.Min, draw.Src)
+		}
+		if c.encoderInput == encoderInputPPM {
+			if err := encodePPM(&stdin, rgba); err != nil {
+				return nil, err
+			}
+		} else {
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				stdin.Write(rgba.Pix[rgba.PixOffset(b.Min.X, y):][:4*b.Dx()])
+			}
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &stdin, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	data := stdout.Bytes()
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("%s did not write a JPEG to stdout", args[0])
+	}
+	return data, nil
+}
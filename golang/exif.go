@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"sort"
+)
+
+const (
+	exifThumbnailSize = 160
+
+	tagThumbnailOffset = 0x0201
+	tagThumbnailLength = 0x0202
+	tagSoftware        = 0x0131
+	tagExifIFD         = 0x8769
+	tagUserComment     = 0x9286
+
+	// TIFF field types.
+	typeASCII     = 2
+	typeLong      = 4
+	typeUndefined = 7
+)
+
+// userCommentASCII is the character code that starts an ASCII UserComment.
+var userCommentASCII = []byte("ASCII\x00\x00\x00")
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// fixExifThumbnail updates the thumbnail embedded in an EXIF APP1 payload
+// (starting with "Exif\0\0") so it no longer shows the un-bordered original.
+// In "regenerate" mode the thumbnail is replaced by a small rendering of
+// canvas; in "strip" mode it is removed. Payloads without a JPEG thumbnail are
+// returned unchanged.
+func fixExifThumbnail(payload []byte, canvas image.Image, mode string) ([]byte, error) {
+	if !bytes.HasPrefix(payload, exifHeader) {
+		return nil, fmt.Errorf("exif: missing Exif header")
+	}
+	tiff := payload[len(exifHeader):]
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("exif: TIFF header truncated")
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("exif: invalid TIFF header")
+	}
+
+	ifd0 := int(order.Uint32(tiff[4:]))
+	nextPos, err := ifdNextOffsetPos(tiff, order, ifd0)
+	if err != nil {
+		return nil, err
+	}
+	ifd1 := int(order.Uint32(tiff[nextPos:]))
+	if ifd1 == 0 {
+		return payload, nil
+	}
+	entries, err := ifdEntries(tiff, order, ifd1)
+	if err != nil {
+		return nil, err
+	}
+
+	offsetPos, lengthPos := -1, -1
+	for _, pos := range entries {
+		switch order.Uint16(tiff[pos:]) {
+		case tagThumbnailOffset:
+			offsetPos = pos + 8
+		case tagThumbnailLength:
+			lengthPos = pos + 8
+		}
+	}
+	if offsetPos < 0 || lengthPos < 0 {
+		return payload, nil
+	}
+
+	thumbStart := int(order.Uint32(tiff[offsetPos:]))
+	thumbEnd := thumbStart + int(order.Uint32(tiff[lengthPos:]))
+	if thumbStart < 8 || thumbEnd > len(tiff) || thumbEnd < thumbStart {
+		return nil, fmt.Errorf("exif: thumbnail [%d, %d) outside EXIF data", thumbStart, thumbEnd)
+	}
+
+	out := append([]byte(nil), tiff...)
+
+	// Drop the old thumbnail bytes: truncate when they sit at the end of the
+	// block (the usual layout), otherwise blank them in place.
+	if thumbEnd == len(out) {
+		out = out[:thumbStart]
+	} else {
+		clear(out[thumbStart:thumbEnd])
+	}
+
+	switch mode {
+	case "strip":
+		// Unlink IFD1 entirely so readers see no thumbnail at all.
+		order.PutUint32(out[nextPos:], 0)
+	case "regenerate":
+		thumb, err := encodeThumbnail(canvas, exifThumbnailSize)
+		if err != nil {
+			return nil, err
+		}
+		order.PutUint32(out[offsetPos:], uint32(len(out)))
+		order.PutUint32(out[lengthPos:], uint32(len(thumb)))
+		out = append(out, thumb...)
+	default:
+		return nil, fmt.Errorf("exif: unknown thumbnail mode %q", mode)
+	}
+
+	result := append(append([]byte(nil), exifHeader...), out...)
+	if len(result)+2 > 0xffff {
+		return nil, fmt.Errorf("exif: block too large for an APP1 segment (%d bytes)", len(result))
+	}
+	return result, nil
+}
+
+// ifdEntries returns the byte positions of the 12-byte entries of the IFD at
+// offset.
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset int) ([]int, error) {
+	if offset < 8 || offset+2 > len(tiff) {
+		return nil, fmt.Errorf("exif: IFD offset %d out of range", offset)
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	if offset+2+count*12+4 > len(tiff) {
+		return nil, fmt.Errorf("exif: IFD at %d truncated", offset)
+	}
+	entries := make([]int, count)
+	for i := range entries {
+		entries[i] = offset + 2 + i*12
+	}
+	return entries, nil
+}
+
+// ifdNextOffsetPos returns the position of the "next IFD" pointer that
+// follows the entries of the IFD at offset.
+func ifdNextOffsetPos(tiff []byte, order binary.ByteOrder, offset int) (int, error) {
+	entries, err := ifdEntries(tiff, order, offset)
+	if err != nil {
+		return 0, err
+	}
+	return offset + 2 + len(entries)*12, nil
+}
+
+// stampExif returns an EXIF APP1 payload whose Software tag is software and
+// whose UserComment is comment, as ASCII. payload, if not nil, is an
+// existing payload whose other tags are kept: IFD0 and the Exif IFD are
+// written anew at the end of the block, replacing any tags of the same
+// numbers, and the old ones are left unreferenced, so every offset into the
+// original data stays valid.
+func stampExif(payload []byte, software string, comment []byte) ([]byte, error) {
+	var tiff []byte
+	if payload == nil {
+		// An empty IFD0 with no next IFD.
+		tiff = []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	} else {
+		if !bytes.HasPrefix(payload, exifHeader) {
+			return nil, fmt.Errorf("exif: missing Exif header")
+		}
+		tiff = payload[len(exifHeader):]
+	}
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return nil, fmt.Errorf("exif: invalid TIFF header")
+	}
+
+	ifd0 := int(order.Uint32(tiff[4:]))
+	ifd0Entries, err := ifdEntries(tiff, order, ifd0)
+	if err != nil {
+		return nil, err
+	}
+	next := order.Uint32(tiff[ifd0+2+12*len(ifd0Entries):])
+	var exifEntries []int
+	for _, pos := range ifd0Entries {
+		if order.Uint16(tiff[pos:]) == tagExifIFD {
+			if exifEntries, err = ifdEntries(tiff, order, int(order.Uint32(tiff[pos+8:]))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := append([]byte(nil), tiff...)
+	// appendData stores data at the end of the block, at an even offset as
+	// TIFF requires, and returns that offset.
+	appendData := func(data []byte) uint32 {
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+		offset := uint32(len(out))
+		out = append(out, data...)
+		return offset
+	}
+	entry := func(tag, typ uint16, data []byte) []byte {
+		e := make([]byte, 12)
+		order.PutUint16(e, tag)
+		order.PutUint16(e[2:], typ)
+		count := len(data)
+		if typ == typeLong {
+			count = len(data) / 4
+		}
+		order.PutUint32(e[4:], uint32(count))
+		if len(data) <= 4 {
+			copy(e[8:], data)
+		} else {
+			order.PutUint32(e[8:], appendData(data))
+		}
+		return e
+	}
+	// appendIFD writes an IFD of entries, those of the original at positions
+	// old except the replaced tags, plus added, and returns its offset.
+	appendIFD := func(old []int, added [][]byte, nextIFD uint32) uint32 {
+		replaced := make(map[uint16]bool)
+		for _, e := range added {
+			replaced[order.Uint16(e)] = true
+		}
+		all := added
+		for _, pos := range old {
+			if !replaced[order.Uint16(tiff[pos:])] {
+				all = append(all, tiff[pos:pos+12])
+			}
+		}
+		sort.Slice(all, func(i, j int) bool { return order.Uint16(all[i]) < order.Uint16(all[j]) })
+		ifd := make([]byte, 2, 2+12*len(all)+4)
+		order.PutUint16(ifd, uint16(len(all)))
+		for _, e := range all {
+			ifd = append(ifd, e...)
+		}
+		ifd = append(ifd, 0, 0, 0, 0)
+		order.PutUint32(ifd[len(ifd)-4:], nextIFD)
+		return appendData(ifd)
+	}
+
+	userComment := entry(tagUserComment, typeUndefined, append(append([]byte(nil), userCommentASCII...), comment...))
+	exifIFD := make([]byte, 4)
+	order.PutUint32(exifIFD, appendIFD(exifEntries, [][]byte{userComment}, 0))
+	added := [][]byte{
+		entry(tagSoftware, typeASCII, append([]byte(software), 0)),
+		entry(tagExifIFD, typeLong, exifIFD),
+	}
+	order.PutUint32(out[4:], appendIFD(ifd0Entries, added, next))
+
+	result := append(append([]byte(nil), exifHeader...), out...)
+	if len(result)+2 > 0xffff {
+		return nil, fmt.Errorf("exif: block too large for an APP1 segment (%d bytes)", len(result))
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifWithThumbnail returns an EXIF APP1 payload with an empty IFD0 and an
+// IFD1 pointing to thumb.
+func exifWithThumbnail(thumb []byte) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	// IFD0: no entries, IFD1 right after it.
+	tiff = le.AppendUint16(tiff, 0)
+	tiff = le.AppendUint32(tiff, 14)
+	// IFD1: the thumbnail's offset and length, then the thumbnail.
+	tiff = le.AppendUint16(tiff, 2)
+	for _, e := range [][2]uint32{{tagThumbnailOffset, 14 + 2 + 24 + 4}, {tagThumbnailLength, uint32(len(thumb))}} {
+		tiff = le.AppendUint16(tiff, uint16(e[0]))
+		tiff = le.AppendUint16(tiff, typeLong)
+		tiff = le.AppendUint32(tiff, 1)
+		tiff = le.AppendUint32(tiff, e[1])
+	}
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, thumb...)
+	return append(append([]byte(nil), exifHeader...), tiff...)
+}
+
+// exifThumbnail returns the thumbnail IFD1 of an EXIF payload points to, or
+// nil when it has none.
+func exifThumbnail(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	tiff := payload[len(exifHeader):]
+	order := binary.LittleEndian
+	next, err := ifdNextOffsetPos(tiff, order, int(order.Uint32(tiff[4:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd1 := int(order.Uint32(tiff[next:]))
+	if ifd1 == 0 {
+		return nil
+	}
+	entries, err := ifdEntries(tiff, order, ifd1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset, length int
+	for _, pos := range entries {
+		switch order.Uint16(tiff[pos:]) {
+		case tagThumbnailOffset:
+			offset = int(order.Uint32(tiff[pos+8:]))
+		case tagThumbnailLength:
+			length = int(order.Uint32(tiff[pos+8:]))
+		}
+	}
+	return tiff[offset : offset+length]
+}
+
+func TestFixExifThumbnailRegenerate(t *testing.T) {
+	var old bytes.Buffer
+	if err := jpeg.Encode(&old, fill(16, 12, color.RGBA{255, 0, 0, 255}), nil); err != nil {
+		t.Fatal(err)
+	}
+	canvas := fill(400, 300, color.RGBA{0, 0, 255, 255})
+
+	payload, err := fixExifThumbnail(exifWithThumbnail(old.Bytes()), canvas, "regenerate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(exifThumbnail(t, payload)))
+	if err != nil {
+		t.Fatalf("decoding the regenerated thumbnail: %v", err)
+	}
+	if got, want := thumb.Bounds().Size(), image.Pt(exifThumbnailSize, 120); got != want {
+		t.Errorf("thumbnail size = %v, want %v", got, want)
+	}
+	if c := thumb.At(80, 60); !near(c, color.RGBA{0, 0, 255, 255}, 8) {
+		t.Errorf("thumbnail shows %v, want the blue canvas", c)
+	}
+}
+
+func TestFixExifThumbnailStrip(t *testing.T) {
+	payload, err := fixExifThumbnail(exifWithThumbnail([]byte{0xff, 0xd8, 0xff, 0xd9}), nil, "strip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb := exifThumbnail(t, payload); thumb != nil {
+		t.Errorf("stripped EXIF still links a %d-byte thumbnail", len(thumb))
+	}
+}
+
+func TestFixExifThumbnailTruncated(t *testing.T) {
+	for _, tiff := range []string{"II*\x00", "II*\x00\x08\x00", "MM\x00*\x00\x00\x00"} {
+		if _, err := fixExifThumbnail(append(append([]byte(nil), exifHeader...), tiff...), nil, "strip"); err == nil {
+			t.Errorf("%q: got no error for a truncated TIFF header", tiff)
+		}
+	}
+}
+
+func TestStampExifTruncated(t *testing.T) {
+	for _, tiff := range []string{"II*\x00", "MM\x00*\x00\x00"} {
+		payload := append(append([]byte(nil), exifHeader...), tiff...)
+		if _, err := stampExif(payload, "white_border_adder", []byte("{}")); err == nil {
+			t.Errorf("%q: got no error for a truncated TIFF header", tiff)
+		}
+		if o := exifOrientation(payload); o != 1 {
+			t.Errorf("%q: orientation %d, want 1", tiff, o)
+		}
+	}
+}
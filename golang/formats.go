@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// inputExtensions maps each input extension picked up by the directory scan
+// (lowercase, with the dot) to the name of the image format it holds, as
+// registered with image.RegisterFormat.
+var (
+	inputExtensionsMu sync.RWMutex
+	inputExtensions   = make(map[string]string)
+)
+
+func init() {
+	RegisterInputExtensions("jpeg", ".jpg", ".jpeg")
+	RegisterInputExtensions("png", ".png")
+	RegisterInputExtensions("pnm", ".ppm", ".pgm", ".pnm")
+	RegisterInputExtensions("webp", ".webp")
+	RegisterInputExtensions("tiff", ".tif", ".tiff")
+	RegisterInputExtensions("bmp", ".bmp")
+	RegisterInputExtensions("gif", ".gif")
+	// SVGs are rasterized by decodeSVG rather than image.Decode.
+	RegisterInputExtensions("svg", ".svg")
+}
+
+// RegisterInputExtensions makes the directory scan accept files with the
+// given extensions as images of the named format. Decoding itself goes
+// through image.Decode, so an application adds a format by importing its
+// decoder (which registers it with the image package) and calling this
+// with the decoder's format name:
+//
+//	import _ "golang.org/x/image/bmp"
+//
+//	RegisterInputExtensions("bmp", ".bmp")
+func RegisterInputExtensions(format string, extensions ...string) {
+	inputExtensionsMu.Lock()
+	defer inputExtensionsMu.Unlock()
+	for _, ext := range extensions {
+		inputExtensions[strings.ToLower(ext)] = format
+	}
+}
+
+// inputFormatFor returns the format registered for an input extension.
+func inputFormatFor(ext string) (string, bool) {
+	inputExtensionsMu.RLock()
+	defer inputExtensionsMu.RUnlock()
+	format, ok := inputExtensions[strings.ToLower(ext)]
+	return format, ok
+}
+
+// extensionAliases are extensions some cameras and browsers save images
+// under that the scan only picks up when -ext lists them, mapped to the
+// format they hold.
+var extensionAliases = map[string]string{".jfif": "jpeg", ".jpe": "jpeg"}
+
+// applyExtensions parses -ext, a comma-separated list of input extensions
+// with or without dots, into the set the scan is limited to, registering
+// the aliases it lists. Unsupported extensions are warned about, once
+// each, and left out; an empty -ext leaves every registered extension in.
+func (c *Config) applyExtensions() error {
+	if c.extList == "" {
+		return nil
+	}
+	c.extensions = make(map[string]bool)
+	warned := make(map[string]bool)
+	for _, item := range strings.Split(c.extList, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		ext := "." + strings.TrimPrefix(item, ".")
+		if _, ok := inputFormatFor(ext); !ok {
+			format, alias := extensionAliases[ext]
+			if !alias {
+				if !warned[ext] {
+					fmt.Printf("⚠️  -ext: %s is not a supported image extension, ignored\n", ext)
+					warned[ext] = true
+				}
+				continue
+			}
+			RegisterInputExtensions(format, ext)
+		}
+		c.extensions[ext] = true
+	}
+	if len(c.extensions) == 0 {
+		return fmt.Errorf("-ext %q lists no supported image extension", c.extList)
+	}
+	return nil
+}
+
+// scansExtension reports whether files with extension ext are picked up,
+// going by -ext when it is set.
+func (c *Config) scansExtension(ext string) bool {
+	ext = strings.ToLower(ext)
+	if c.extensions != nil {
+		return c.extensions[ext]
+	}
+	_, ok := inputFormatFor(ext)
+	return ok
+}
+
+// outputFormatFor returns the format name, as used for inputs, of the
+// output at path.
+func outputFormatFor(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".jpg":
+		return "jpeg"
+	case ".tif":
+		return "tiff"
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// formatExtensions are the extensions outputs of the formats we can encode
+// get when their input had the extension of another format.
+var formatExtensions = map[string]string{"jpeg": ".jpg", "png": ".png", "tiff": ".tif"}
+
+// sniffFormat returns the name of the registered format whose magic bytes
+// start the file at path, or "" when none does. Only the header is read,
+// and a malformed header still names its format.
+func sniffFormat(path string) (format string) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	// Like decodeSafely: a decoder may panic on malformed headers.
+	defer func() {
+		if recover() != nil {
+			format = ""
+		}
+	}()
+	_, format, _ = image.DecodeConfig(bufio.NewReader(f))
+	return format
+}
+
+// outputExtFor returns the extension of the output written for an input
+// of the given format and extension. JPEG, PNG and TIFF keep their own
+// extension, lowercased, or the usual one for an alias such as .jfif;
+// WebP and BMP are written as JPEG, and anything else as PNG.
+func outputExtFor(format, ext string) string {
+	switch format {
+	case "jpeg", "png", "tiff":
+		if _, alias := extensionAliases[strings.ToLower(ext)]; alias {
+			return formatExtensions[format]
+		}
+		return strings.ToLower(ext)
+	case "webp", "bmp":
+		return ".jpg"
+	}
+	return ".png"
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// syncDir flushes a directory's entries to stable storage so newly created
+// files survive a power loss. Windows cannot open directories for syncing,
+// so it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// maxAnimatedGIFBytes caps the indexed frames an -animated-gif output holds
+// in memory until it is encoded, so a very long GIF fails on its own
+// instead of exhausting the worker's memory.
+const maxAnimatedGIFBytes = 1 << 30
+
+// processAnimatedGIF borders every frame of the GIF at job.inputPath and
+// writes them as an animated GIF with the same delays and loop count.
+// Frames are composited the way a viewer shows them, honoring their
+// disposal, so each output frame is a complete picture and partial frames
+// do not leave ghosts behind.
+func processAnimatedGIF(job imageJob, config *Config) (imageInfo, error) {
+	var info imageInfo
+
+	g, inputBytes, err := decodeGIF(job.inputPath)
+	if err != nil {
+		return info, err
+	}
+	info.format, info.inputBytes = "gif", inputBytes
+	if need := int64(len(g.Image)) * int64(config.targetWidth) * int64(config.targetHeight); need > maxAnimatedGIFBytes {
+		return info, fmt.Errorf("animated GIF too long: %d frames at %dx%d need %d MB, over the %d MB limit",
+			len(g.Image), config.targetWidth, config.targetHeight, need>>20, maxAnimatedGIFBytes>>20)
+	}
+
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		screen = g.Image[0].Bounds()
+	}
+	composite := image.NewRGBA(screen)
+
+	// The background is resolved once, from the first frame, so an
+	// auto-contrast or auto border does not flicker between frames.
+	frameConfig := *config
+	out := &gif.GIF{LoopCount: g.LoopCount}
+	var first *image.RGBA
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous []byte
+		if disposal == gif.DisposalPrevious {
+			previous = append(previous, composite.Pix...)
+		}
+		draw.Draw(composite, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		frameInfo := &info
+		if i > 0 {
+			frameInfo = &imageInfo{}
+		}
+		_, canvas := renderCanvas(composite, job.outputPath, &frameConfig, frameInfo)
+		if i == 0 {
+			frameConfig.background, frameConfig.backgroundColor = "", info.background
+			if frameConfig.borderMode != borderModeSolid && frameConfig.borderMode != borderModeBlur {
+				frameConfig.borderMode = borderModeSolid
+			}
+			first = canvas
+		}
+		colors, _ := countColors(canvas, math.MaxInt)
+		out.Image = append(out.Image, palettize(canvas, colors, config.dither))
+		delay := 0
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+		out.Delay = append(out.Delay, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(composite, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(composite.Pix, previous)
+		}
+	}
+
+	l := info.layout
+	info.outputBytes, info.outputSHA256, err = writeOutput(job.outputPath, config, l.isLandscape, nil, func(w io.Writer) error {
+		return gif.EncodeAll(w, out)
+	})
+	if err != nil {
+		return info, err
+	}
+
+	if config.verifyOutputs {
+		if err := verifyOutput(job.outputPath, config, l.destRect, info.background); err != nil {
+			os.Remove(job.outputPath)
+			return info, fmt.Errorf("output verification failed: %v", err)
+		}
+	}
+	if job.wantThumbnail {
+		info.thumbnail, err = encodeThumbnail(first, reportThumbnailSize)
+		if err != nil {
+			return info, err
+		}
+	}
+	if job.wantPreview {
+		info.preview = renderPreview(first)
+	}
+	return info, nil
+}
+
+// decodeGIF decodes every frame of the GIF at path, inside an openFiles
+// slot, and returns it with the file size.
+func decodeGIF(path string) (g *gif.GIF, size int64, err error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening input file: %v", err)
+	}
+	defer input.Close()
+	if fi, err := input.Stat(); err == nil {
+		size = fi.Size()
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			g, err = nil, fmt.Errorf("decoder failed on malformed data: %v", p)
+		}
+	}()
+	g, err = gif.DecodeAll(input)
+	if err != nil {
+		return nil, size, fmt.Errorf("error decoding image: %v", err)
+	}
+	if len(g.Image) == 0 {
+		return nil, size, fmt.Errorf("error decoding image: GIF has no frames")
+	}
+	return g, size, nil
+}
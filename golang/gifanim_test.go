@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnimatedGIF(t *testing.T) {
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	palette := color.Palette{color.White}
+	for _, c := range colors {
+		palette = append(palette, c)
+	}
+	in := &gif.GIF{LoopCount: 3}
+	for i := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, 60, 40), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i + 1)
+		}
+		in.Image = append(in.Image, frame)
+		in.Delay = append(in.Delay, 10*(i+1))
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.gif")
+	f, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(f, in); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 150, 120
+	job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.gif")}
+	info, err := processImage(context.Background(), job, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(job.outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Image) != 3 {
+		t.Fatalf("output has %d frames, want 3", len(out.Image))
+	}
+	if out.LoopCount != in.LoopCount {
+		t.Errorf("loop count %d, want %d", out.LoopCount, in.LoopCount)
+	}
+	dest := info.layout.destRect
+	center := image.Pt((dest.Min.X+dest.Max.X)/2, (dest.Min.Y+dest.Max.Y)/2)
+	for i, frame := range out.Image {
+		if frame.Bounds() != image.Rect(0, 0, 150, 120) {
+			t.Errorf("frame %d is %v, want 150x120", i, frame.Bounds())
+		}
+		if out.Delay[i] != in.Delay[i] {
+			t.Errorf("frame %d delay %d, want %d", i, out.Delay[i], in.Delay[i])
+		}
+		if got := frame.At(center.X, center.Y); !near(got, colors[i], 0) {
+			t.Errorf("frame %d shows %v, want %v", i, got, colors[i])
+		}
+		if got := frame.At(0, 0); !near(got, config.backgroundColor, 0) {
+			t.Errorf("frame %d border is %v, want %v", i, got, config.backgroundColor)
+		}
+	}
+}
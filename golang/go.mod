@@ -1,14 +1,24 @@
-module whi
+module github.com/Autherain/white_border_adder/golang
 
 go 1.23.2
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/pkg/sftp v1.13.7
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20210519020934-456a8d69b780
 	github.com/urfave/cli/v2 v2.27.5
+	golang.org/x/crypto v0.31.0
 	golang.org/x/image v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
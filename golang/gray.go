@@ -0,0 +1,47 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+)
+
+// keepsGray reports whether img, a grayscale image, is drawn on a
+// grayscale canvas and written as a grayscale JPEG, PNG or TIFF, a third
+// the size of the same pixels in color. The border color and keyline must
+// be neutral grays, and -png-palette writes color palettes, so it opts out
+// too.
+func keepsGray(img image.Image, outputPath string, background color.RGBA, config *Config) bool {
+	if !config.preserveGray || !isGray(img) || !isNeutral(background) {
+		return false
+	}
+	if config.autoKeyline && !isNeutral(config.keylineColor) {
+		return false
+	}
+	// A logo keeps its colors.
+	if config.watermark != nil {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".jpg", ".jpeg", ".tif", ".tiff":
+		return true
+	case ".png":
+		return !config.pngPalette
+	}
+	return false
+}
+
+// isGray reports whether img is stored as grayscale.
+func isGray(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// isNeutral reports whether c is an opaque gray.
+func isNeutral(c color.RGBA) bool {
+	return c.A == 255 && c.R == c.G && c.G == c.B
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Autherain/white_border_adder/golang/border"
+)
+
+// jobSpec is one entry of a -jobs file.
+type jobSpec struct {
+	Input     string        `json:"input"`
+	Output    string        `json:"output"`
+	Overrides *jobOverrides `json:"overrides,omitempty"`
+}
+
+// jobOverrides are the per-image settings a job may change. The keys match
+// the settings recorded in the processing marker.
+type jobOverrides struct {
+	Width          *int     `json:"width"`
+	Height         *int     `json:"height"`
+	LandscapeVert  *float64 `json:"landscape_vert"`
+	LandscapeHoriz *float64 `json:"landscape_horiz"`
+	PortraitVert   *float64 `json:"portrait_vert"`
+	PortraitHoriz  *float64 `json:"portrait_horiz"`
+	BorderTop      *float64 `json:"border_top"`
+	BorderBottom   *float64 `json:"border_bottom"`
+	BorderLeft     *float64 `json:"border_left"`
+	BorderRight    *float64 `json:"border_right"`
+	BorderMinPx    *int     `json:"border_min_px"`
+	BorderMaxPx    *int     `json:"border_max_px"`
+	JPEGQuality    *int     `json:"jpeg_quality"`
+	Background     *string  `json:"background"`
+	BorderMode     *string  `json:"border_mode"`
+	Gravity        *string  `json:"gravity"`
+	OffsetX        *int     `json:"offset_x"`
+	OffsetY        *int     `json:"offset_y"`
+}
+
+// jobResult is one entry of the result document written after a -jobs run.
+type jobResult struct {
+	Index int `json:"index"`
+	manifestRecord
+}
+
+// jobOutputExtensions are the output formats a job may ask for.
+var jobOutputExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".jxl": true, ".webp": true, ".avif": true, ".gif": true, ".tif": true, ".tiff": true}
+
+// jobOutputList names the extensions of jobOutputExtensions, sorted, for
+// error messages.
+func jobOutputList() string {
+	exts := make([]string, 0, len(jobOutputExtensions))
+	for ext := range jobOutputExtensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return strings.Join(exts[:len(exts)-1], ", ") + " or " + exts[len(exts)-1]
+}
+
+// loadJobSpec reads and validates a -jobs file ("-" for stdin) against the
+// base config. Every problem in the spec is reported, not just the first.
+// Relative paths are resolved against the spec file's folder.
+// Job specs share the render path of folder runs, but are a separate entry
+// point: the command-line flags are not compiled into a spec.
+func loadJobSpec(path string, base *Config) ([]imageJob, error) {
+	var data []byte
+	var err error
+	dir := "."
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+		dir = filepath.Dir(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []jobSpec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&specs); err != nil {
+		return nil, fmt.Errorf("parsing job spec: %v", err)
+	}
+
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	var problems []string
+	outputs := make(map[string]int)
+	inputs := make(map[string]bool)
+	jobs := make([]imageJob, 0, len(specs))
+	for i, spec := range specs {
+		input, output := resolve(spec.Input), resolve(spec.Output)
+		fail := func(format string, args ...any) {
+			problems = append(problems, fmt.Sprintf("job %d (%s): %s", i, spec.Input, fmt.Sprintf(format, args...)))
+		}
+
+		_, known := inputFormatFor(filepath.Ext(input))
+		switch {
+		case input == "":
+			fail("missing input")
+		case !known:
+			fail("unsupported input format")
+		default:
+			if fi, err := os.Stat(input); err != nil {
+				fail("input not readable: %v", err)
+			} else if fi.IsDir() {
+				fail("input is a folder")
+			}
+		}
+
+		absOutput, _ := filepath.Abs(output)
+		switch {
+		case output == "":
+			fail("missing output")
+		case !jobOutputExtensions[strings.ToLower(filepath.Ext(output))]:
+			fail("unsupported output format %q (expected %s)", filepath.Ext(output), jobOutputList())
+		default:
+			if prev, ok := outputs[absOutput]; ok {
+				fail("output %s is also the output of job %d", output, prev)
+			}
+			outputs[absOutput] = i
+		}
+		absInput, _ := filepath.Abs(input)
+		inputs[absInput] = true
+
+		cfg := base
+		if spec.Overrides != nil {
+			c := *base
+			spec.Overrides.apply(&c)
+			if err := c.validateRender(); err != nil {
+				fail("invalid overrides: %v", err)
+			}
+			cfg = &c
+		}
+		if cfg.background == backgroundTransparent && strings.ToLower(filepath.Ext(output)) != ".png" {
+			fail("a transparent background needs a .png output")
+		}
+		if strings.ToLower(filepath.Ext(output)) == ".jxl" {
+			if !cjxlAvailable() {
+				fail("JPEG XL output needs the cjxl encoder, which was not found in PATH")
+			}
+			if cfg.verifyOutputs || cfg.measureQuality {
+				fail("-verify-outputs and -measure-quality cannot decode JPEG XL outputs")
+			}
+		}
+		if strings.ToLower(filepath.Ext(output)) == ".webp" && !cwebpAvailable() {
+			fail("WebP output needs the cwebp encoder, which was not found in PATH")
+		}
+		if strings.ToLower(filepath.Ext(output)) == ".avif" {
+			if !avifencAvailable() {
+				fail("AVIF output needs the avifenc encoder, which was not found in PATH")
+			}
+			if cfg.verifyOutputs || cfg.measureQuality {
+				fail("-verify-outputs and -measure-quality cannot decode AVIF outputs")
+			}
+		}
+
+		jobs = append(jobs, imageJob{index: i, inputPath: input, outputPath: output, config: cfg})
+	}
+
+	// An output must never overwrite any job's input.
+	var overwriting []int
+	for abs, i := range outputs {
+		if inputs[abs] {
+			overwriting = append(overwriting, i)
+		}
+	}
+	sort.Ints(overwriting)
+	for _, i := range overwriting {
+		problems = append(problems, fmt.Sprintf("job %d (%s): output would overwrite an input", i, specs[i].Input))
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	return jobs, nil
+}
+
+func (o *jobOverrides) apply(c *Config) {
+	setInt := func(dst *int, v *int) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setFloat := func(dst *float64, v *float64) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setInt(&c.targetWidth, o.Width)
+	setInt(&c.targetHeight, o.Height)
+	setFloat(&c.landscapeVertBorder, o.LandscapeVert)
+	setFloat(&c.landscapeHorizBorder, o.LandscapeHoriz)
+	setFloat(&c.portraitVertBorder, o.PortraitVert)
+	setFloat(&c.portraitHorizBorder, o.PortraitHoriz)
+	setFloat(&c.topBorder, o.BorderTop)
+	setFloat(&c.bottomBorder, o.BorderBottom)
+	setFloat(&c.leftBorder, o.BorderLeft)
+	setFloat(&c.rightBorder, o.BorderRight)
+	setInt(&c.borderMinPx, o.BorderMinPx)
+	setInt(&c.borderMaxPx, o.BorderMaxPx)
+	setInt(&c.jpegQuality, o.JPEGQuality)
+	setInt(&c.offsetX, o.OffsetX)
+	setInt(&c.offsetY, o.OffsetY)
+	if o.Background != nil {
+		c.background = *o.Background
+	}
+	if o.BorderMode != nil {
+		c.borderMode = *o.BorderMode
+	}
+	if o.Gravity != nil {
+		c.gravity = *o.Gravity
+	}
+}
+
+// validateJobsMode rejects options that only make sense for an input
+// folder when -jobs is given.
+func (c *Config) validateJobsMode(inputFolder string) error {
+	if c.jobsPath == "" {
+		return nil
+	}
+	switch {
+	case inputFolder != "":
+		return fmt.Errorf("-jobs cannot be combined with an input folder")
+	case c.htmlReport || c.diffSettings:
+		return fmt.Errorf("-html-report and -diff-settings work on an input folder, not with -jobs")
+	case c.schedule != scheduleFIFO:
+		return fmt.Errorf("-schedule works on an input folder; -jobs runs jobs in spec order")
+	case c.qaSample > 0:
+		return fmt.Errorf("-qa-sample works on an input folder, not with -jobs")
+	case c.minRating > 0:
+		return fmt.Errorf("-min-rating works on an input folder; -jobs lists the images to process")
+	case c.recursive:
+		return fmt.Errorf("-recursive works on an input folder; -jobs lists the images to process")
+	case c.pattern != "":
+		return fmt.Errorf("-pattern works on an input folder; -jobs lists the images to process")
+	case c.outputDir != "":
+		return fmt.Errorf("-jobs names the output of every job; -output cannot be combined with it")
+	}
+	return nil
+}
+
+// validateRender checks the settings a job may override, and resolves the
+// background color.
+func (c *Config) validateRender() error {
+	if c.targetWidth <= 0 || c.targetHeight <= 0 {
+		return fmt.Errorf("width and height must be positive, got %dx%d", c.targetWidth, c.targetHeight)
+	}
+	for _, r := range []float64{c.landscapeVertBorder, c.landscapeHorizBorder, c.portraitVertBorder, c.portraitHorizBorder} {
+		if r < 0 || r >= 0.5 {
+			return fmt.Errorf("border ratios must be in [0, 0.5), got %g", r)
+		}
+	}
+	if c.borderMinPx < 0 || c.borderMaxPx < 0 {
+		return fmt.Errorf("border pixel clamps must not be negative")
+	}
+	if c.borderMaxPx > 0 && c.borderMinPx > c.borderMaxPx {
+		return fmt.Errorf("border_min_px (%d) is larger than border_max_px (%d)", c.borderMinPx, c.borderMaxPx)
+	}
+	if c.jpegQuality < 1 || c.jpegQuality > 100 {
+		return fmt.Errorf("jpeg_quality must be between 1 and 100, got %d", c.jpegQuality)
+	}
+	if !border.ValidGravity(c.gravity) {
+		return fmt.Errorf("invalid gravity %q", c.gravity)
+	}
+	if !validBorderMode(c.borderMode) {
+		return fmt.Errorf("invalid border_mode %q (expected solid, blur, auto, edge or dominant)", c.borderMode)
+	}
+	if err := c.borderConfig().Validate(); err != nil {
+		return err
+	}
+	if c.background != backgroundAutoContrast {
+		bg, err := parseBackground(c.background)
+		if err != nil {
+			return err
+		}
+		c.backgroundColor = bg
+	}
+	return nil
+}
+
+// runJobSpec processes the images of a -jobs spec through the worker pool
+// and writes a result document mapping each job to its outcome.
+func runJobSpec(ctx context.Context, config *Config, mainStart time.Time) {
+	jobs, err := loadJobSpec(config.jobsPath, config)
+	if err != nil {
+		fmt.Printf("Error: invalid job spec:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	// Create and check every output folder before any decode work.
+	checked := make(map[string]bool)
+	for _, job := range jobs {
+		dir := filepath.Dir(job.outputPath)
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Printf("Error creating output folder: %v\n", err)
+			os.Exit(1)
+		}
+		if err := probeWritable(dir); err != nil {
+			fmt.Printf("Error: output folder %s is not writable: %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+
+	var manifest *manifestWriter
+	if config.manifestPath != "" {
+		manifest, err = newManifestWriter(config.manifestPath, config.reproducible)
+		if err != nil {
+			fmt.Printf("Error creating manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var csv *csvReport
+	if config.reportPath != "" {
+		csv, err = newCSVReport(config.reportPath, config.reproducible)
+		if err != nil {
+			fmt.Printf("Error creating report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var previewProtocol string
+	if config.showPreview {
+		previewProtocol = detectPreviewProtocol()
+	}
+
+	results := make([]jobResult, len(jobs))
+	var batches [][]imageJob
+	var batch []imageJob
+	owned := 0
+	for i, job := range jobs {
+		results[i] = jobResult{Index: i, manifestRecord: manifestRecord{
+			Input: job.inputPath, Output: job.outputPath, Status: "skipped",
+		}}
+		if !config.shard.owns(job.inputPath) {
+			continue
+		}
+		job.wantPreview = previewProtocol != "" && owned < config.previewCount
+		owned++
+		batch = append(batch, job)
+		if len(batch) == config.batchSize {
+			batches = append(batches, batch)
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	stats, scaler := processBatches(ctx, batches, config, runSinks{
+		manifest:        manifest,
+		csv:             csv,
+		previewProtocol: previewProtocol,
+		onResult: func(r processingResult) {
+			results[r.index].manifestRecord = newManifestRecord(r)
+		},
+	}, nil)
+
+	if manifest != nil {
+		if err := manifest.close(); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+		}
+	}
+	if csv != nil {
+		if err := csv.close(); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+		}
+	}
+
+	stats.printSummary(time.Since(mainStart))
+	if ctx.Err() != nil {
+		stats.printInterrupted(owned)
+	}
+	if config.shard.count > 1 {
+		fmt.Printf("🧩 Shard %s: owned %d of %d jobs\n", config.shard.String(), owned, len(jobs))
+	}
+	if scaler != nil {
+		scaler.printTimeline()
+	}
+
+	resultPath := config.jobsResultPath
+	if resultPath == "" {
+		resultPath = "jobs.result.json"
+		if config.jobsPath != "-" {
+			resultPath = strings.TrimSuffix(config.jobsPath, filepath.Ext(config.jobsPath)) + ".result.json"
+		}
+	}
+	if err := writeJobResults(resultPath, results); err != nil {
+		fmt.Printf("Error writing job results: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n📋 Job results written to %s\n", resultPath)
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+}
+
+// writeJobResults writes the result document of a -jobs run.
+func writeJobResults(path string, results []jobResult) error {
+	doc := struct {
+		OK      int         `json:"ok"`
+		Failed  int         `json:"failed"`
+		Skipped int         `json:"skipped"`
+		Jobs    []jobResult `json:"jobs"`
+	}{Jobs: results}
+	for _, r := range results {
+		switch r.Status {
+		case "ok":
+			doc.OK++
+		case "failed":
+			doc.Failed++
+		default:
+			doc.Skipped++
+		}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadJobSpecUnsupportedOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "in.jpg"), fill(40, 30, color.RGBA{200, 0, 0, 255}))
+	spec := filepath.Join(dir, "jobs.json")
+	data := `[{"input": "in.jpg", "output": "out.bmp"}, {"input": "in.jpg", "output": "out.tiff"}]`
+	if err := os.WriteFile(spec, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loadJobSpec(spec, testConfig())
+	if err == nil {
+		t.Fatal("loadJobSpec() accepted a .bmp output")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `unsupported output format ".bmp"`) || strings.Contains(msg, "job 1") {
+		t.Fatalf("loadJobSpec() = %v, want only the .bmp job rejected", err)
+	}
+	for ext := range jobOutputExtensions {
+		if !strings.Contains(msg, ext) {
+			t.Errorf("error %q does not list %s", msg, ext)
+		}
+	}
+}
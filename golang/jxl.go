@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Output encodings selectable with -output-encoding (or -output-format).
+const (
+	encodingAuto = "auto"
+	encodingKeep = "keep"
+	encodingJPEG = "jpeg"
+	encodingPNG  = "png"
+	encodingJXL  = "jxl"
+	encodingWebP = "webp"
+	encodingAVIF = "avif"
+	encodingGIF  = "gif"
+	encodingTIFF = "tiff"
+)
+
+// cjxlAvailable reports whether the libjxl reference encoder is installed.
+func cjxlAvailable() bool {
+	_, err := exec.LookPath("cjxl")
+	return err == nil
+}
+
+// validateOutputEncoding checks -output-encoding and its options, so a
+// missing encoder fails once up front rather than on every image.
+func (c *Config) validateOutputEncoding() error {
+	switch c.outputEncoding {
+	case encodingKeep:
+		c.outputEncoding = encodingAuto
+		return nil
+	case encodingAuto:
+		return nil
+	case encodingJPEG, encodingPNG, encodingGIF, encodingTIFF:
+		if c.backend == backendVips {
+			return fmt.Errorf("-backend vips does not support -output-encoding %s", c.outputEncoding)
+		}
+		return nil
+	case encodingJXL:
+	case encodingWebP:
+		return c.validateWebP()
+	case encodingAVIF:
+		return c.validateAVIF()
+	default:
+		return fmt.Errorf("invalid -output-encoding value %q (expected auto, keep, jpeg, png, gif, tiff, jxl, webp or avif)", c.outputEncoding)
+	}
+
+	switch {
+	case !cjxlAvailable():
+		return fmt.Errorf("-output-encoding jxl needs the cjxl encoder from libjxl, which was not found in PATH")
+	case c.jxlDistance < 0 || c.jxlDistance > 25:
+		return fmt.Errorf("-jxl-distance must be between 0 and 25, got %g", c.jxlDistance)
+	case c.jxlEffort < 1 || c.jxlEffort > 9:
+		return fmt.Errorf("-jxl-effort must be between 1 and 9, got %d", c.jxlEffort)
+	case c.verifyOutputs || c.measureQuality:
+		return fmt.Errorf("-verify-outputs and -measure-quality cannot decode JPEG XL outputs")
+	case c.backend == backendVips:
+		return fmt.Errorf("-backend vips does not support -output-encoding jxl")
+	}
+	return nil
+}
+
+// encodeJXL encodes canvas as JPEG XL with the cjxl command-line encoder
+// and writes the result to w. distance is the Butteraugli distance (0 is
+// lossless, 1 visually lossless) and effort the encoder speed/size tradeoff
+// (1-9).
+func encodeJXL(w io.Writer, canvas *image.RGBA, distance float64, effort int) error {
+	tmpDir, err := os.MkdirTemp("", "wbi-jxl-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	input := filepath.Join(tmpDir, "canvas.ppm")
+	if err := writePPM(input, canvas); err != nil {
+		return err
+	}
+
+	output := filepath.Join(tmpDir, "canvas.jxl")
+	var stderr bytes.Buffer
+	cmd := exec.Command("cjxl", input, output, "-d", fmt.Sprint(distance), "-e", fmt.Sprint(effort))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cjxl failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writePPM writes the RGB channels of img to path as a binary PPM, the
+// cheapest input format for cjxl to read.
+func writePPM(path string, img *image.RGBA) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = encodePPM(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// encodePPM writes the RGB channels of img to w as a binary PPM.
+func encodePPM(out io.Writer, img *image.RGBA) error {
+	w := bufio.NewWriter(out)
+	b := img.Bounds()
+	fmt.Fprintf(w, "P6\n%d %d\n255\n", b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			w.Write(row[4*x : 4*x+3])
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// keylineSampleDepth is how many pixels in from each edge of the placed
+// image are sampled by -auto-keyline.
+const keylineSampleDepth = 3
+
+// edgeBlendFraction returns the fraction of pixels in the outer
+// keylineSampleDepth pixels of rect on canvas whose luminance is within
+// 1-threshold of the background's. Counting a fraction rather than looking
+// at the extremes means a few bright specks cannot swing the decision.
+func edgeBlendFraction(canvas image.Image, rect image.Rectangle, background color.RGBA, threshold float64) float64 {
+	bgLuma := rgbLuma(background.R, background.G, background.B)
+	tolerance := 1 - threshold
+
+	inner := rect.Inset(keylineSampleDepth)
+	var blending, total int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if (image.Point{x, y}).In(inner) {
+				// Jump over the interior of the row.
+				x = inner.Max.X - 1
+				continue
+			}
+			c := color.RGBAModel.Convert(canvas.At(x, y)).(color.RGBA)
+			l := rgbLuma(c.R, c.G, c.B)
+			if l-bgLuma <= tolerance && bgLuma-l <= tolerance {
+				blending++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(blending) / float64(total)
+}
+
+// rgbLuma returns the luminance of an 8-bit RGB color in [0, 1].
+func rgbLuma(r, g, b uint8) float64 {
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 255
+}
+
+// drawKeyline draws a frame of the given width just inside rect.
+func drawKeyline(canvas draw.Image, rect image.Rectangle, width int, c color.RGBA) {
+	src := image.NewUniform(c)
+	for _, side := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+width),
+		image.Rect(rect.Min.X, rect.Max.Y-width, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+width, rect.Max.Y),
+		image.Rect(rect.Max.X-width, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	} {
+		draw.Draw(canvas, side.Intersect(rect), src, image.Point{}, draw.Src)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+
+	"github.com/Autherain/white_border_adder/golang/border"
+)
+
+// layout describes where a source image lands on the target canvas.
+type layout struct {
+	isLandscape bool
+	scale       float64
+	destRect    image.Rectangle
+}
+
+// borderConfig returns the border package settings for c. The background
+// and kernel are per image and left for the caller to fill in.
+func (c *Config) borderConfig() border.Config {
+	side := func(ratio float64) *float64 {
+		if ratio < 0 {
+			return nil
+		}
+		return &ratio
+	}
+	return border.Config{
+		Width:          c.targetWidth,
+		Height:         c.targetHeight,
+		LandscapeVert:  c.landscapeVertBorder,
+		LandscapeHoriz: c.landscapeHorizBorder,
+		PortraitVert:   c.portraitVertBorder,
+		PortraitHoriz:  c.portraitHorizBorder,
+		Top:            side(c.topBorder),
+		Bottom:         side(c.bottomBorder),
+		Left:           side(c.leftBorder),
+		Right:          side(c.rightBorder),
+		MinPx:          c.borderMinPx,
+		MaxPx:          c.borderMaxPx,
+		Gravity:        c.gravity,
+		OffsetX:        c.offsetX,
+		OffsetY:        c.offsetY,
+		CornerRadius:   c.cornerRadius,
+		Blur:           c.borderMode == borderModeBlur,
+	}
+}
+
+// perSide reports whether any of -border-top, -border-bottom, -border-left
+// and -border-right is set, so the borders of an axis may differ.
+func (c *Config) perSide() bool {
+	return c.topBorder >= 0 || c.bottomBorder >= 0 || c.leftBorder >= 0 || c.rightBorder >= 0
+}
+
+// computeLayout fits an origWidth x origHeight image inside the borders of
+// the target canvas, preserving its aspect ratio, and places it according to
+// -gravity and the -offset-x/-offset-y nudge. The image never enters the
+// border on any side: the offsets are clamped to the area inside it.
+func computeLayout(origWidth, origHeight int, config *Config) layout {
+	l := config.borderConfig().Layout(origWidth, origHeight)
+	return layout{isLandscape: l.Landscape, scale: l.Scale, destRect: l.Dest}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Log formats selectable with -log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// validateLogFormat checks -log-format.
+func (c *Config) validateLogFormat() error {
+	switch c.logFormat {
+	case logFormatText, logFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid -log-format value %q (expected text or json)", c.logFormat)
+}
+
+// imageRecord is the -log-format json line written for every image.
+type imageRecord struct {
+	Event      string  `json:"event"`
+	Filename   string  `json:"filename"`
+	Output     string  `json:"output,omitempty"`
+	Status     string  `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+	BatchID    int     `json:"batch_id"`
+}
+
+// summaryRecord is the -log-format json line that ends a run, in place of
+// the summary.
+type summaryRecord struct {
+	Event       string          `json:"event"`
+	ElapsedMS   float64         `json:"elapsed_ms"`
+	Processed   int64           `json:"processed"`
+	Failed      int64           `json:"failed"`
+	Skipped     int64           `json:"skipped"`
+	Duplicates  int64           `json:"duplicates"`
+	AverageMS   float64         `json:"average_ms"`
+	P50MS       float64         `json:"p50_ms"`
+	P90MS       float64         `json:"p90_ms"`
+	P99MS       float64         `json:"p99_ms"`
+	InputBytes  int64           `json:"input_bytes"`
+	OutputBytes int64           `json:"output_bytes"`
+	Batches     []batchRecord   `json:"batches"`
+	Fastest     *durationRecord `json:"fastest,omitempty"`
+	Slowest     *durationRecord `json:"slowest,omitempty"`
+	// FastestImages and SlowestImages list the topImages fastest and
+	// slowest, the first of each repeating Fastest and Slowest.
+	FastestImages []durationRecord `json:"fastest_images,omitempty"`
+	SlowestImages []durationRecord `json:"slowest_images,omitempty"`
+}
+
+type batchRecord struct {
+	BatchID    int     `json:"batch_id"`
+	Successful int     `json:"successful"`
+	Total      int     `json:"total"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type durationRecord struct {
+	Filename   string  `json:"filename"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// logImage writes the record of an image. Workers call it concurrently.
+func (ps *processingStats) logImage(r processingResult) {
+	record := imageRecord{
+		Event:      "image",
+		Filename:   r.filename,
+		Status:     "ok",
+		DurationMS: milliseconds(r.duration),
+		BatchID:    r.batch,
+	}
+	switch {
+	case r.duplicateOf != "":
+		record.Status = "duplicate"
+	case r.skipped:
+		record.Status = "skipped"
+	case r.error != nil:
+		record.Status, record.Error = "failed", r.error.Error()
+	default:
+		record.Output = r.outputPath
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.json.Encode(record)
+}
+
+// printSummaryJSON writes the summary record. ps.mu must be held.
+func (ps *processingStats) printSummaryJSON(elapsed time.Duration) {
+	record := summaryRecord{
+		Event:       "summary",
+		ElapsedMS:   milliseconds(elapsed),
+		Processed:   ps.totalImages.Load(),
+		Failed:      ps.failedImages.Load(),
+		Skipped:     ps.skipped.Load(),
+		Duplicates:  ps.duplicates.Load(),
+		InputBytes:  ps.inputBytes.Load(),
+		OutputBytes: ps.outputBytes.Load(),
+		Batches:     make([]batchRecord, 0, len(ps.batches)),
+	}
+	if record.Processed > 0 {
+		record.AverageMS = milliseconds(time.Duration(ps.totalDuration.Load()) / time.Duration(record.Processed))
+		record.P50MS = milliseconds(ps.percentile(0.50))
+		record.P90MS = milliseconds(ps.percentile(0.90))
+		record.P99MS = milliseconds(ps.percentile(0.99))
+		for _, r := range ps.fastest.sorted() {
+			record.FastestImages = append(record.FastestImages, durationRecord{r.filename, milliseconds(r.duration)})
+		}
+		for _, r := range ps.slowest.sorted() {
+			record.SlowestImages = append(record.SlowestImages, durationRecord{r.filename, milliseconds(r.duration)})
+		}
+		record.Fastest, record.Slowest = &record.FastestImages[0], &record.SlowestImages[0]
+	}
+	for _, b := range ps.sortedBatches() {
+		record.Batches = append(record.Batches, batchRecord{b.batchID, b.successful, b.total, milliseconds(b.duration)})
+	}
+	ps.json.Encode(record)
+}
+
+// newJSONLog returns the encoder -log-format json writes its records with,
+// one object per line on stdout.
+func newJSONLog() *json.Encoder {
+	return json.NewEncoder(os.Stdout)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLog(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "a.jpg"), fill(40, 30, color.RGBA{200, 0, 0, 255}))
+	writeImage(t, filepath.Join(dir, "b.png"), fill(30, 40, color.RGBA{0, 200, 0, 255}))
+	if err := os.WriteFile(filepath.Join(dir, "broken.jpg"), []byte("not a JPEG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig()
+	config.logFormat = logFormatJSON
+	config.batchSize = 2
+	printed := captureStdout(t, func() {
+		if err := runFolder(context.Background(), config, dir, time.Now(), nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	var images []imageRecord
+	var summaries []summaryRecord
+	for _, line := range strings.Split(strings.TrimSpace(printed), "\n") {
+		var event struct{ Event string }
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("line is not JSON: %q", line)
+		}
+		switch event.Event {
+		case "image":
+			var r imageRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatal(err)
+			}
+			images = append(images, r)
+		case "summary":
+			var r summaryRecord
+			if err := json.Unmarshal([]byte(line), &r); err != nil {
+				t.Fatal(err)
+			}
+			summaries = append(summaries, r)
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].Filename < images[j].Filename })
+	want := []struct {
+		filename, status string
+		batch            int
+	}{
+		{"a.jpg", "ok", 0},
+		{"b.png", "ok", 0},
+		{"broken.jpg", "failed", 1},
+	}
+	if len(images) != len(want) {
+		t.Fatalf("got %d image records, want %d:\n%s", len(images), len(want), printed)
+	}
+	for i, w := range want {
+		r := images[i]
+		if r.Filename != w.filename || r.Status != w.status || r.BatchID != w.batch {
+			t.Errorf("record %+v, want %s %s in batch %d", r, w.filename, w.status, w.batch)
+		}
+		if (r.Error != "") != (w.status == "failed") || (r.Output != "") != (w.status == "ok") {
+			t.Errorf("record %+v has the wrong error or output for %s", r, w.status)
+		}
+	}
+
+	if len(summaries) != 1 {
+		t.Fatalf("got %d summary records, want 1", len(summaries))
+	}
+	s := summaries[0]
+	if s.Processed != 2 || s.Failed != 1 || len(s.Batches) != 2 {
+		t.Errorf("summary %+v, want 2 processed and 1 failed in 2 batches", s)
+	}
+}
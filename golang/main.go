@@ -1,29 +1,125 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"image/png"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Autherain/white_border_adder/golang/border"
 	"golang.org/x/image/draw"
 )
 
 type imageJob struct {
+	index         int
+	inputPath     string
+	outputPath    string
+	wantThumbnail bool
+	wantPreview   bool
+	// page is the page of a multi-page TIFF this job borders, counting
+	// from 1, or 0 for the whole input.
+	page int
+	// batch is the batch the job belongs to, which results are grouped by
+	// for reporting.
+	batch int
+	// config overrides the run's Config for this image (from a -jobs spec,
+	// or for one of several -size values).
+	config *Config
+	// size is the canvas size, as WxH, of a job that is one of several
+	// -size values, and decoded the decode it shares with the others.
+	size      string
+	sizeIndex int
+	decoded   *sharedDecode
+	// duplicateOf is the input a -dedup run saw the content of this job's
+	// input in first, if another.
+	duplicateOf string
+}
+
+// name is how the job's input is named in messages.
+func (j imageJob) name() string {
+	var parts []string
+	if j.page > 0 {
+		parts = append(parts, fmt.Sprintf("page %d", j.page))
+	}
+	if j.size != "" {
+		parts = append(parts, j.size)
+	}
+	if len(parts) > 0 {
+		return fmt.Sprintf("%s (%s)", filepath.Base(j.inputPath), strings.Join(parts, ", "))
+	}
+	return filepath.Base(j.inputPath)
+}
+
+type processingResult struct {
+	index int
+	// page and sizeIndex tell apart the results of one input's pages and
+	// -size values, which share its index.
+	page       int
+	sizeIndex  int
+	batch      int
+	filename   string
 	inputPath  string
 	outputPath string
+	info       imageInfo
+	duration   time.Duration
+	finishedAt time.Time
+	error      error
+	// skipped is set, with no info, for an image left alone by
+	// -skip-existing because its output already existed, or by -dedup
+	// because duplicateOf, the input it is a copy of, was seen first.
+	skipped     bool
+	duplicateOf string
 }
 
-type processingResult struct {
-	filename string
-	duration time.Duration
-	error    error
+// imageInfo describes a processed image.
+type imageInfo struct {
+	sourceWidth    int
+	sourceHeight   int
+	outputWidth    int
+	outputHeight   int
+	inputBytes     int64
+	outputBytes    int64
+	outputSHA256   string
+	verifyDuration time.Duration
+	jpegQuality    int
+	ssim           float64
+	measured       bool
+	qualityPSNR    float64
+	qualitySSIM    float64
+	thumbnail      []byte
+	preview        image.Image
+	background     color.RGBA
+	luminance      float64
+	// convertedFrom names the color space -convert-srgb converted from.
+	convertedFrom  string
+	paletteColors  int
+	paletteSkipped bool
+	layout         layout
+	edgeBlend      float64
+	keyline        bool
+	kernel         string
+	format         string
+	// outputPath is set when -auto-shrink renamed the output.
+	outputPath string
+	oversized  bool
+	autoShrink string
+	// planned is set by -dry-run, which writes nothing.
+	planned bool
 }
 
 type batchResult struct {
@@ -33,28 +129,123 @@ type batchResult struct {
 	results   []processingResult
 }
 
-type processingStats struct {
-	sync.Mutex
-	totalImages   int
-	failedImages  int
-	totalDuration time.Duration
-	batchResults  []batchResult
-	fastest       processingResult
-	slowest       processingResult
-}
-
 type Config struct {
 	targetWidth          int
+	sizes                [][2]int // several -size values, each bordered
 	targetHeight         int
 	landscapeVertBorder  float64
 	landscapeHorizBorder float64
 	portraitVertBorder   float64
 	portraitHorizBorder  float64
+	topBorder            float64
+	bottomBorder         float64
+	leftBorder           float64
+	rightBorder          float64
 	batchSize            int
 	maxWorkers           int
+	autoWorkers          bool
+	schedule             string
+	shard                shardValue
 	jpegQuality          int
+	jpegSubsampling      string
+	targetSSIM           float64
 	outputPrefix         string
 	createSeparateFolder bool
+	htmlReport           bool
+	manifestPath         string
+	reportPath           string
+	verifyOutputs        bool
+	measureQuality       bool
+	fsync                bool
+	embedMarker          bool
+	embedSettings        bool
+	diffSettings         bool
+	diffList             string
+	exifThumbnail        string
+	preserveMetadata     bool
+	stripMetadata        bool
+	colorProfile         string
+	convertSRGB          bool
+	dryRun               bool
+	verbose              bool
+	borderMinPx          int
+	borderMaxPx          int
+	showPreview          bool
+	previewCount         int
+	background           string
+	backgroundColor      color.RGBA
+	borderMode           string
+	edgeWidth            int
+	contrastThreshold    float64
+	lightColor           color.RGBA
+	darkColor            color.RGBA
+	backend              string
+	pngPalette           bool
+	paletteOutput        bool
+	logFormat            string
+	dither               bool
+	sidecarsJSON         bool
+	gravity              string
+	offsetX              int
+	offsetY              int
+	cornerRadius         int
+	autoKeyline          bool
+	keylineThreshold     float64
+	keylineFraction      float64
+	keylineWidth         int
+	keylineColor         color.RGBA
+	watermarkPath        string
+	watermarkPosition    string
+	watermarkOpacity     float64
+	// watermark is the decoded -watermark image.
+	watermark            image.Image
+	vignette             float64
+	outputEncoding       string
+	jxlDistance          float64
+	jxlEffort            int
+	webpQuality          int
+	avifQuality          int
+	avifSpeed            int
+	bmpPNG               bool
+	pngCompression       string
+	tiffCompression      string
+	force8Bit            bool
+	preserveGray         bool
+	animatedGIF          bool
+	jobsPath             string
+	jobsResultPath       string
+	qaSample             int
+	qaSeed               int64
+	reproducible         bool
+	resample             string
+	resampleUp           string
+	resampleDown         string
+	maxOpenFiles         int
+	maxMemory            byteSize
+	outputDir            string
+	sftp                 *sftpTarget
+	sftpKey              string
+	knownHosts           string
+	transferConcurrency  int
+	tune                 bool
+	tuneAddr             string
+	minRating            int
+	includeUnrated       bool
+	sizeWarning          float64
+	autoShrink           bool
+	autoShrinkMinQuality int
+	encoderCmd           string
+	encoderInput         string
+	encoderStrict        bool
+	recursive            bool
+	watch                bool
+	pattern              string
+	extList              string
+	extensions           map[string]bool // nil: every registered extension
+	skipExisting         bool
+	dedup                bool
+	force                bool
+	stdin                bool
 }
 
 // Default configuration values
@@ -65,14 +256,54 @@ var defaultConfig = Config{
 	landscapeHorizBorder: 0.03,
 	portraitVertBorder:   0.005,
 	portraitHorizBorder:  0.18,
+	topBorder:            -1,
+	bottomBorder:         -1,
+	leftBorder:           -1,
+	rightBorder:          -1,
 	batchSize:            1,
-	maxWorkers:           50,
+	maxWorkers:           runtime.NumCPU(),
+	schedule:             scheduleFIFO,
 	jpegQuality:          100,
+	jpegSubsampling:      subsampling420,
 	outputPrefix:         "bordered_",
 	createSeparateFolder: true,
+	exifThumbnail:        "regenerate",
+	previewCount:         1,
+	resample:             resampleApproxBiLinear,
+	transferConcurrency:  4,
+	knownHosts:           defaultKnownHosts(),
+	tuneAddr:             "127.0.0.1:8642",
+	sizeWarning:          1.5,
+	autoShrinkMinQuality: 85,
+	background:           "white",
+	backgroundColor:      color.RGBA{255, 255, 255, 255},
+	borderMode:           borderModeSolid,
+	edgeWidth:            8,
+	contrastThreshold:    0.5,
+	lightColor:           color.RGBA{255, 255, 255, 255},
+	darkColor:            color.RGBA{26, 26, 26, 255},
+	backend:              backendGo,
+	gravity:              "center",
+	keylineThreshold:     0.9,
+	keylineFraction:      0.5,
+	keylineWidth:         1,
+	keylineColor:         color.RGBA{200, 200, 200, 255},
+	watermarkPosition:    "br",
+	watermarkOpacity:     1,
+	outputEncoding:       encodingAuto,
+	jxlDistance:          1.0,
+	jxlEffort:            7,
+	webpQuality:          90,
+	encoderInput:         encoderInputPNG,
+	avifQuality:          75,
+	avifSpeed:            6,
+	pngCompression:       "default",
+	tiffCompression:      "deflate",
+	preserveGray:         true,
+	logFormat:            logFormatText,
 }
 
-func parseFlags() (*Config, string) {
+func parseFlags() (*Config, string, *flag.FlagSet) {
 	// Create a new FlagSet to track if flags were actually set
 	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
@@ -87,32 +318,197 @@ func parseFlags() (*Config, string) {
 		landscapeHoriz = flagSet.Float64("landscape-horiz", defaultConfig.landscapeHorizBorder, "Horizontal border ratio for landscape images")
 		portraitVert   = flagSet.Float64("portrait-vert", defaultConfig.portraitVertBorder, "Vertical border ratio for portrait images")
 		portraitHoriz  = flagSet.Float64("portrait-horiz", defaultConfig.portraitHorizBorder, "Horizontal border ratio for portrait images")
-		batchSize      = flagSet.Int("batch-size", defaultConfig.batchSize, "Number of images to process in each batch")
-		workers        = flagSet.Int("workers", defaultConfig.maxWorkers, "Maximum number of concurrent workers")
+		borderTop      = flagSet.Float64("border-top", defaultConfig.topBorder, "Top border ratio for all images, instead of the vertical ratio (negative = not set)")
+		borderBottom   = flagSet.Float64("border-bottom", defaultConfig.bottomBorder, "Bottom border ratio for all images, instead of the vertical ratio (negative = not set)")
+		borderLeft     = flagSet.Float64("border-left", defaultConfig.leftBorder, "Left border ratio for all images, instead of the horizontal ratio (negative = not set)")
+		borderRight    = flagSet.Float64("border-right", defaultConfig.rightBorder, "Right border ratio for all images, instead of the horizontal ratio (negative = not set)")
+		batchSize      = flagSet.Int("batch-size", defaultConfig.batchSize, "Number of images grouped into each batch of the summary's batch statistics")
+		workers        = &workersValue{n: defaultConfig.maxWorkers}
+		targetSSIM     = flagSet.Float64("target-ssim", 0, "Pick the smallest JPEG quality (up to -jpeg-quality) reaching this SSIM, e.g. 0.97 (0 = off)")
+		manifest       = flagSet.String("manifest", "", "Write a JSON Lines manifest with one record per image to this file")
+		report         = flagSet.String("report", "", "Write a CSV report with one row per image (filename, output path, duration, status, error) to this file")
+		schedule       = flagSet.String("schedule", defaultConfig.schedule, "Job order: fifo (listing order), ljf (largest files first) or sjf (smallest first)")
 		jpegQuality    = flagSet.Int("jpeg-quality", defaultConfig.jpegQuality, "JPEG output quality (1-100)")
+		jpegSubsample  = flagSet.String("jpeg-subsampling", defaultConfig.jpegSubsampling, "JPEG chroma subsampling: 420, 422 or 444 (no chroma subsampling, for text and hard edges)")
 		outputPrefix   = flagSet.String("prefix", defaultConfig.outputPrefix, "Prefix for output filenames")
 		separateFolder = flagSet.Bool("separate-folder", defaultConfig.createSeparateFolder, "Create separate folder for output")
+		borderMinPx    = flagSet.Int("border-min-px", 0, "Minimum border thickness in pixels per side (0 = no minimum)")
+		borderMaxPx    = flagSet.Int("border-max-px", 0, "Maximum border thickness in pixels per side (0 = no maximum)")
+		htmlReport     = flagSet.Bool("html-report", false, "Write a report.html gallery of the run into the output folder")
+		verifyOutputs  = flagSet.Bool("verify-outputs", false, "Re-open and decode every output after writing it to check it is intact")
+		measureQuality = flagSet.Bool("measure-quality", false, "Measure PSNR/SSIM of each output against a reference downscale of its source")
+		fsync          = flagSet.Bool("fsync", false, "Flush every output and its folder to disk before reporting success")
+		embedMarker    = flagSet.Bool("marker", defaultConfig.embedMarker, "Embed a processing marker recording the settings into each output, for -diff-settings to compare against later")
+		embedSettings  = flagSet.Bool("embed-settings", false, "Add a readable JPEG comment with the source name, canvas size, border ratios and scale applied to each JPEG output")
+		diffSettings   = flagSet.Bool("diff-settings", false, "Report which existing outputs would change with the current settings, without processing")
+		diffList       = flagSet.String("diff-list", "", "With -diff-settings, write the inputs that would change to this file")
+		exifThumbnail  = flagSet.String("exif-thumbnail", defaultConfig.exifThumbnail, "How to handle the thumbnail in copied EXIF data: regenerate or strip")
+		preserveMeta   = flagSet.Bool("preserve-metadata", false, "Copy the EXIF data and ICC color profile of JPEG inputs into their JPEG outputs")
+		verbose        = flagSet.Bool("verbose", false, "Log every image as it is processed instead of showing a progress bar")
+		dryRun         = flagSet.Bool("dry-run", false, "Decode the inputs and list the planned outputs and scaled sizes, without writing anything")
+		colorProfile   = flagSet.String("color-profile", "", "Color profile of JPEG and PNG outputs: keep (copy the input's ICC profile), srgb (tag as sRGB) or none (default: keep with -preserve-metadata, none otherwise)")
+		convertSRGB    = flagSet.Bool("convert-srgb", false, "Convert images with an Adobe RGB or Display P3 profile to sRGB and tag their JPEG and PNG outputs as sRGB")
+		stripMetadata  = flagSet.Bool("strip-metadata", false, "Guarantee outputs carry no EXIF, XMP, IPTC, thumbnails or processing marker (implies -marker=false)")
+		showPreview    = flagSet.Bool("show-preview", false, "Show the first processed images inline in the terminal (iTerm2, kitty or sixel)")
+		previewCount   = flagSet.Int("preview-count", defaultConfig.previewCount, "Number of images to preview with -show-preview")
+		background     = flagSet.String("background", defaultConfig.background, "Border color: a CSS color name such as white or black, #rrggbb, auto-contrast to pick light or dark per image, or transparent (PNG output only)")
+		borderMode     = flagSet.String("border-mode", defaultConfig.borderMode, "Border fill: solid (the -background color), blur (a blurred, enlarged copy of the image), auto (the average color of the image's edges), edge (the average of its outermost -edge-width pixels) or dominant (its most common color)")
+		edgeWidth      = flagSet.Int("edge-width", defaultConfig.edgeWidth, "Width in pixels of the outer strip of the image -border-mode edge averages")
+		contrastThresh = flagSet.Float64("contrast-threshold", defaultConfig.contrastThreshold, "With -background=auto-contrast, average luminance (0-1) above which the dark color is used")
+		lightColor     = flagSet.String("light-color", formatColor(defaultConfig.lightColor), "With -background=auto-contrast, border color for dark images")
+		darkColor      = flagSet.String("dark-color", formatColor(defaultConfig.darkColor), "With -background=auto-contrast, border color for bright images")
+		backend        = flagSet.String("backend", defaultConfig.backend, "Processing backend: go, or vips to use the libvips command-line tool for JPEG and PNG inputs")
+		pngPalette     = flagSet.Bool("png-palette", false, "Write PNG outputs as 8-bit indexed PNGs with at most 256 colors (photographic images are left as is)")
+		paletteOutput  = flagSet.Bool("palette-output", false, "Write the PNG outputs of indexed-color sources, such as pixel art and charts, as 8-bit indexed PNGs")
+		dither         = flagSet.Bool("dither", false, "With -png-palette or -palette-output, apply Floyd-Steinberg dithering when colors have to be merged")
+		sidecarsJSON   = flagSet.Bool("sidecars-json", false, "Write a <output>.json sidecar with the geometry and settings of each output")
+		gravity        = flagSet.String("gravity", defaultConfig.gravity, "Where to place the image inside the borders: center, north, south, east, west, northwest, northeast, southwest or southeast")
+		offsetX        = flagSet.Int("offset-x", 0, "Move the image right (or left if negative) by this many pixels after -gravity, without entering the border")
+		offsetY        = flagSet.Int("offset-y", 0, "Move the image down (or up if negative) by this many pixels after -gravity, without entering the border")
+		cornerRadius   = flagSet.Int("corner-radius", 0, "Round the corners of the image by this many pixels, showing the border color behind them")
+		autoKeyline    = flagSet.Bool("auto-keyline", false, "Draw a thin keyline around images whose edges would blend into the border")
+		keylineThresh  = flagSet.Float64("keyline-threshold", defaultConfig.keylineThreshold, "With -auto-keyline, edge pixels within 1-threshold luminance of the border color count as blending in")
+		keylineFrac    = flagSet.Float64("keyline-fraction", defaultConfig.keylineFraction, "With -auto-keyline, fraction of blending edge pixels (0-1) that triggers the keyline")
+		keylineWidth   = flagSet.Int("keyline-width", defaultConfig.keylineWidth, "With -auto-keyline, keyline width in pixels (1-2)")
+		keylineColor   = flagSet.String("keyline-color", formatColor(defaultConfig.keylineColor), "With -auto-keyline, keyline color")
+		watermark      = flagSet.String("watermark", "", "Overlay this image, such as a PNG logo, on every output")
+		watermarkPos   = flagSet.String("watermark-position", defaultConfig.watermarkPosition, "With -watermark, where it goes on the canvas: tl, tr, bl, br or center")
+		watermarkAlpha = flagSet.Float64("watermark-opacity", defaultConfig.watermarkOpacity, "With -watermark, its opacity (0-1)")
+		vignette       = flagSet.Float64("vignette", 0, "Darken the image toward its edges by this strength (0-1), leaving the border untouched")
+		outputEncoding = flagSet.String("output-encoding", defaultConfig.outputEncoding, "Output encoding: auto or keep (JPEG for JPEG inputs, TIFF for TIFF inputs, PNG otherwise), jpeg, png, gif (animated for GIF inputs), tiff, jxl (needs cjxl), webp (needs cwebp) or avif (needs avifenc)")
+		jxlDistance    = flagSet.Float64("jxl-distance", defaultConfig.jxlDistance, "JPEG XL Butteraugli distance: 0 = lossless, 1 = visually lossless, higher = smaller")
+		jxlEffort      = flagSet.Int("jxl-effort", defaultConfig.jxlEffort, "JPEG XL encoder effort (1-9): higher is slower and smaller")
+		webpQuality    = flagSet.Int("webp-quality", defaultConfig.webpQuality, "WebP output quality (0-100)")
+		avifQuality    = flagSet.Int("avif-quality", defaultConfig.avifQuality, "AVIF output quality (0-100)")
+		avifSpeed      = flagSet.Int("avif-speed", defaultConfig.avifSpeed, "AVIF encoder speed (0-10): lower is slower and smaller")
+		bmpPNG         = flagSet.Bool("bmp-png", false, "Write BMP inputs as PNG instead of JPEG")
+		pngCompression = flagSet.String("png-compression", defaultConfig.pngCompression, "PNG compression: default, speed (faster, larger), best (slower, smaller) or none")
+		tiffCompress   = flagSet.String("tiff-compression", defaultConfig.tiffCompression, "TIFF output compression: deflate or none (both lossless)")
+		preserveGray   = flagSet.Bool("preserve-gray", defaultConfig.preserveGray, "Write grayscale inputs as grayscale JPEGs, PNGs and TIFFs when the border is a gray too (-preserve-gray=false writes them in color)")
+		force8Bit      = flagSet.Bool("force-8bit", false, "Write PNG and TIFF outputs of 16-bit inputs with 8 bits per channel, for smaller files")
+		animatedGIF    = flagSet.Bool("animated-gif", false, "Border every frame of GIF inputs and write them as animated GIFs, as -output-encoding gif does (default: first frame only, as PNG)")
+		logFormat      = flagSet.String("log-format", defaultConfig.logFormat, "Log format: text, or json for one JSON object per image and one for the summary on stdout")
+		stdin          = flagSet.Bool("stdin", false, "Process the image paths read from standard input, one per line, instead of an input folder")
+		jobsPath       = flagSet.String("jobs", "", "Process the images listed in this JSON job spec (- for stdin) instead of an input folder")
+		jobsResult     = flagSet.String("jobs-result", "", "Where to write the -jobs result document (default: <spec>.result.json)")
+		qaSample       = flagSet.Int("qa-sample", 0, "Copy a random sample of this many outputs into a qa_sample folder for spot checks")
+		qaSeed         = flagSet.Int64("qa-seed", 0, "Seed for -qa-sample, to repeat a sample (default: random)")
+		reproducible   = flagSet.Bool("reproducible", false, "Make every written file byte-for-byte reproducible: no durations, manifest in input order, fixed -qa-seed")
+		resample       = flagSet.String("resample", defaultConfig.resample, "Resampling kernel: nearest, approx-bilinear, bilinear or catmull-rom")
+		resampleUp     = flagSet.String("resample-up", "", "Kernel for images scaled up (default: -resample), e.g. nearest for pixel art")
+		resampleDown   = flagSet.String("resample-down", "", "Kernel for images scaled down (default: -resample), e.g. catmull-rom for photos")
+		maxOpenFiles   = flagSet.Int("max-open-files", 0, "Maximum number of images being read or written at once (default: derived from the open file limit)")
+		output         = flagSet.String("output", "", "Output folder, or an sftp://[user@]host[:port]/path destination (default: inside the input folder)")
+		sftpKey        = flagSet.String("sftp-key", "", "Private key file for an SFTP -output (default: the SSH agent)")
+		knownHosts     = flagSet.String("known-hosts", defaultConfig.knownHosts, "known_hosts file used to check the host key of an SFTP -output")
+		transferConc   = flagSet.Int("transfer-concurrency", defaultConfig.transferConcurrency, "Number of SFTP connections uploading outputs at once")
+		tune           = flagSet.Bool("tune", false, "Open a local web page to tune the settings on a sample of the input, save them as a preset, and run the folder")
+		tuneAddr       = flagSet.String("tune-addr", defaultConfig.tuneAddr, "Local address the -tune page is served on")
+		minRating      = flagSet.Int("min-rating", 0, "Only process images with an XMP star rating of at least this (1-5; 0 = all images)")
+		includeUnrated = flagSet.Bool("include-unrated", false, "With -min-rating, also process images that have no rating")
+		sizeWarning    = flagSet.Float64("size-warning", defaultConfig.sizeWarning, "Warn when an output is more than this many times larger than its input (0 = never)")
+		autoShrink     = flagSet.Bool("auto-shrink", false, "Re-encode outputs over -size-warning at lower JPEG quality, or as JPEG instead of opaque PNG")
+		shrinkMinQ     = flagSet.Int("auto-shrink-min-quality", defaultConfig.autoShrinkMinQuality, "Lowest JPEG quality -auto-shrink goes down to")
+		encoderCmd     = flagSet.String("encoder-cmd", "", "Encode JPEG outputs with this command instead of the built-in encoder, e.g. \"cjpeg -quality {quality} -optimize\"; it reads the canvas on stdin and writes the JPEG to stdout ({quality}, {width} and {height} are filled in)")
+		encoderInput   = flagSet.String("encoder-input", defaultConfig.encoderInput, "What -encoder-cmd reads on stdin: png, ppm or rgba (raw 8-bit RGBA pixels)")
+		encoderStrict  = flagSet.Bool("encoder-strict", false, "Fail an image when -encoder-cmd fails, instead of encoding it with the built-in encoder")
+		recursive      = flagSet.Bool("recursive", false, "Also process images in subfolders of the input folder, mirroring them in the output folder")
+		watch          = flagSet.Bool("watch", false, "After processing the input folder, keep watching it and process images added to it until interrupted")
+		pattern        = flagSet.String("pattern", "", "Only process images whose file name matches this glob, e.g. IMG_*.jpg")
+		extList        = flagSet.String("ext", "", "Only process files with these comma-separated extensions, e.g. jpg,jpeg,jfif,png (default: every supported one)")
+		configFile     = flagSet.String("config", "", "Load settings from this YAML (.yaml/.yml) or JSON (.json) file, keyed by flag name (flags given on the command line still win)")
+		presetName     = flagSet.String("preset", "", "Load the settings saved under this name with -save-preset (flags given on the command line still win); for named canvas sizes such as ig-square, use -size")
+		savePreset     = flagSet.String("save-preset", "", "Save the effective settings under this name in the user config file")
+		force          = flagSet.Bool("force", false, "Overwrite existing outputs without warning, and with -save-preset an existing preset")
+		skipExisting   = flagSet.Bool("skip-existing", false, "Leave images whose output already exists alone instead of overwriting it")
+		dedupInputs    = flagSet.Bool("dedup", false, "Skip images whose file content is identical to an image already seen in the run")
 		inputFolder    = flagSet.String("input", "", "Input folder containing images (required)")
+		wizard         = flagSet.Bool("wizard", false, "Choose the input, look and output interactively, then show the equivalent command and run it")
 	)
 
-	// If only one argument is provided (the input folder), use it directly with default config
-	if len(os.Args) == 2 && !strings.HasPrefix(os.Args[1], "-") {
-		return &defaultConfig, os.Args[1]
-	}
-
-	// Parse flags normally if more arguments are provided
+	shard := &shardValue{}
+	sizes := &sizeList{}
+	flagSet.Var(sizes, "size", "Canvas size, as WxH or a social media preset: ig-square, ig-portrait, ig-story, 4x5 or 16x9 (-width and -height still win); repeat it to border every image once per size. These presets are named here because -preset loads saved settings")
+	maxMemory := new(byteSize)
+	flagSet.Var(maxMemory, "max-memory", "Maximum estimated memory of the images processed at once (e.g. 2GB), whatever the number of workers (default: unbounded)")
+	flagSet.Var(shard, "shard", "Only process this part of the input, as index/count (e.g. 0/4), when splitting a run across machines")
+	flagSet.StringVar(presetName, "profile", "", "Alias for -preset")
+	flagSet.StringVar(background, "color", defaultConfig.background, "Alias for -background")
+	flagSet.StringVar(outputEncoding, "output-format", defaultConfig.outputEncoding, "Alias for -output-encoding")
+	flagSet.BoolVar(preserveMeta, "keep-metadata", false, "Alias for -preserve-metadata")
+	flagSet.StringVar(resample, "scaler", defaultConfig.resample, "Alias for -resample")
+	flagSet.Var(workers, "workers", "Maximum number of concurrent workers, or \"auto\" to scale with throughput and memory")
+
+	// A lone folder argument goes through the same parsing as -input, so
+	// both forms resolve defaults and validate alike.
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
 		fmt.Println("Error parsing flags:", err)
 		flagSet.Usage()
 		os.Exit(1)
 	}
 
+	if *wizard {
+		args, err := runWizard(flagSet)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Args = append(os.Args[:1], args...)
+		return parseFlags()
+	}
+
+	// A -size on the command line is applied before any preset, whose saved
+	// width and height must not override it; one from a -config file is
+	// applied after it.
+	if len(*sizes) > 0 {
+		if err := applySize(flagSet, *sizes, &config); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			flagSet.Usage()
+			os.Exit(1)
+		}
+	}
+	if *presetName != "" {
+		values, err := loadPreset(*presetName)
+		if err == nil {
+			err = applyPreset(flagSet, values)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *configFile != "" {
+		values, err := loadConfigFile(*configFile)
+		if err == nil {
+			err = applySettings(flagSet, values, "config file", configFileExcludedFlags)
+		}
+		if err == nil && len(*sizes) > 0 {
+			err = applySize(flagSet, *sizes, &config)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Check if input folder is provided
 	if *inputFolder == "" && flagSet.NArg() > 0 {
 		*inputFolder = flagSet.Arg(0)
 	}
 
-	if *inputFolder == "" {
+	if *savePreset != "" {
+		path, err := savePresetTo(flagSet, *savePreset, *force)
+		if err != nil {
+			fmt.Printf("Error saving preset: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("💾 Saved preset %q to %s\n", *savePreset, path)
+		if *inputFolder == "" {
+			os.Exit(0)
+		}
+	}
+
+	if *inputFolder == "" && *jobsPath == "" && !*stdin {
 		fmt.Println("Error: Input folder is required")
 		flagSet.Usage()
 		os.Exit(1)
@@ -125,6 +521,14 @@ func parseFlags() (*Config, string) {
 			config.targetWidth = *width
 		case "height":
 			config.targetHeight = *height
+		case "border-top":
+			config.topBorder = *borderTop
+		case "border-bottom":
+			config.bottomBorder = *borderBottom
+		case "border-left":
+			config.leftBorder = *borderLeft
+		case "border-right":
+			config.rightBorder = *borderRight
 		case "landscape-vert":
 			config.landscapeVertBorder = *landscapeVert
 		case "landscape-horiz":
@@ -136,285 +540,2053 @@ func parseFlags() (*Config, string) {
 		case "batch-size":
 			config.batchSize = *batchSize
 		case "workers":
-			config.maxWorkers = *workers
+			config.maxWorkers = workers.n
+			config.autoWorkers = workers.auto
+			if workers.auto {
+				config.maxWorkers = 4 * runtime.NumCPU()
+			}
+		case "target-ssim":
+			config.targetSSIM = *targetSSIM
+		case "manifest":
+			config.manifestPath = *manifest
+		case "report":
+			config.reportPath = *report
+		case "shard":
+			config.shard = *shard
+		case "schedule":
+			config.schedule = *schedule
 		case "jpeg-quality":
 			config.jpegQuality = *jpegQuality
+		case "jpeg-subsampling":
+			config.jpegSubsampling = *jpegSubsample
 		case "prefix":
 			config.outputPrefix = *outputPrefix
 		case "separate-folder":
 			config.createSeparateFolder = *separateFolder
+		case "border-min-px":
+			config.borderMinPx = *borderMinPx
+		case "border-max-px":
+			config.borderMaxPx = *borderMaxPx
+		case "html-report":
+			config.htmlReport = *htmlReport
+		case "verify-outputs":
+			config.verifyOutputs = *verifyOutputs
+		case "measure-quality":
+			config.measureQuality = *measureQuality
+		case "fsync":
+			config.fsync = *fsync
+		case "marker":
+			config.embedMarker = *embedMarker
+		case "embed-settings":
+			config.embedSettings = *embedSettings
+		case "diff-settings":
+			config.diffSettings = *diffSettings
+		case "diff-list":
+			config.diffList = *diffList
+		case "exif-thumbnail":
+			config.exifThumbnail = *exifThumbnail
+		case "preserve-metadata", "keep-metadata":
+			config.preserveMetadata = *preserveMeta
+		case "verbose":
+			config.verbose = *verbose
+		case "dry-run":
+			config.dryRun = *dryRun
+		case "color-profile":
+			config.colorProfile = *colorProfile
+		case "convert-srgb":
+			config.convertSRGB = *convertSRGB
+		case "strip-metadata":
+			config.stripMetadata = *stripMetadata
+		case "show-preview":
+			config.showPreview = *showPreview
+		case "preview-count":
+			config.previewCount = *previewCount
+		case "background", "color":
+			config.background = *background
+		case "border-mode":
+			config.borderMode = *borderMode
+		case "edge-width":
+			config.edgeWidth = *edgeWidth
+		case "contrast-threshold":
+			config.contrastThreshold = *contrastThresh
+		case "backend":
+			config.backend = *backend
+		case "png-palette":
+			config.pngPalette = *pngPalette
+		case "palette-output":
+			config.paletteOutput = *paletteOutput
+		case "dither":
+			config.dither = *dither
+		case "sidecars-json":
+			config.sidecarsJSON = *sidecarsJSON
+		case "gravity":
+			config.gravity = *gravity
+		case "offset-x":
+			config.offsetX = *offsetX
+		case "offset-y":
+			config.offsetY = *offsetY
+		case "corner-radius":
+			config.cornerRadius = *cornerRadius
+		case "output-encoding", "output-format":
+			config.outputEncoding = *outputEncoding
+		case "jxl-distance":
+			config.jxlDistance = *jxlDistance
+		case "jxl-effort":
+			config.jxlEffort = *jxlEffort
+		case "webp-quality":
+			config.webpQuality = *webpQuality
+		case "avif-quality":
+			config.avifQuality = *avifQuality
+		case "avif-speed":
+			config.avifSpeed = *avifSpeed
+		case "bmp-png":
+			config.bmpPNG = *bmpPNG
+		case "png-compression":
+			config.pngCompression = *pngCompression
+		case "tiff-compression":
+			config.tiffCompression = *tiffCompress
+		case "force-8bit":
+			config.force8Bit = *force8Bit
+		case "preserve-gray":
+			config.preserveGray = *preserveGray
+		case "animated-gif":
+			config.animatedGIF = *animatedGIF
+		case "jobs":
+			config.jobsPath = *jobsPath
+		case "jobs-result":
+			config.jobsResultPath = *jobsResult
+		case "qa-sample":
+			config.qaSample = *qaSample
+		case "qa-seed":
+			config.qaSeed = *qaSeed
+		case "reproducible":
+			config.reproducible = *reproducible
+		case "resample", "scaler":
+			config.resample = *resample
+		case "resample-up":
+			config.resampleUp = *resampleUp
+		case "resample-down":
+			config.resampleDown = *resampleDown
+		case "max-open-files":
+			config.maxOpenFiles = *maxOpenFiles
+		case "max-memory":
+			config.maxMemory = *maxMemory
+		case "output":
+			config.outputDir = *output
+		case "sftp-key":
+			config.sftpKey = *sftpKey
+		case "known-hosts":
+			config.knownHosts = *knownHosts
+		case "transfer-concurrency":
+			config.transferConcurrency = *transferConc
+		case "tune":
+			config.tune = *tune
+		case "tune-addr":
+			config.tuneAddr = *tuneAddr
+		case "min-rating":
+			config.minRating = *minRating
+		case "include-unrated":
+			config.includeUnrated = *includeUnrated
+		case "recursive":
+			config.recursive = *recursive
+		case "watch":
+			config.watch = *watch
+		case "pattern":
+			config.pattern = *pattern
+		case "ext":
+			config.extList = *extList
+		case "log-format":
+			config.logFormat = *logFormat
+		case "stdin":
+			config.stdin = *stdin
+		case "skip-existing":
+			config.skipExisting = *skipExisting
+		case "dedup":
+			config.dedup = *dedupInputs
+		case "force":
+			config.force = *force
+		case "size-warning":
+			config.sizeWarning = *sizeWarning
+		case "auto-shrink":
+			config.autoShrink = *autoShrink
+		case "auto-shrink-min-quality":
+			config.autoShrinkMinQuality = *shrinkMinQ
+		case "encoder-cmd":
+			config.encoderCmd = *encoderCmd
+		case "encoder-input":
+			config.encoderInput = *encoderInput
+		case "encoder-strict":
+			config.encoderStrict = *encoderStrict
+		case "auto-keyline":
+			config.autoKeyline = *autoKeyline
+		case "keyline-threshold":
+			config.keylineThreshold = *keylineThresh
+		case "keyline-fraction":
+			config.keylineFraction = *keylineFrac
+		case "keyline-width":
+			config.keylineWidth = *keylineWidth
+		case "watermark":
+			config.watermarkPath = *watermark
+		case "watermark-position":
+			config.watermarkPosition = *watermarkPos
+		case "watermark-opacity":
+			config.watermarkOpacity = *watermarkAlpha
+		case "vignette":
+			config.vignette = *vignette
 		}
 	})
 
-	return &config, *inputFolder
-}
+	if strings.HasPrefix(config.outputDir, "sftp://") {
+		target, err := parseSFTPTarget(config.outputDir)
+		if err != nil {
+			fmt.Printf("Error: invalid -output: %v\n", err)
+			flagSet.Usage()
+			os.Exit(1)
+		}
+		config.sftp = target
+	}
+	if err := config.validateTune(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := config.validateWatch(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	// A watched folder has no total for a progress bar to count towards.
+	if config.watch {
+		config.verbose = true
+	}
+	if err := config.validateSizes(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := config.validateSFTP(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-func printConfig(config *Config, usingDefaults bool) {
-	fmt.Println("\n=== Configuration ===")
-	if usingDefaults {
-		fmt.Println("Using default configuration (no flags provided)")
+	if config.maxOpenFiles < 0 {
+		fmt.Printf("Error: -max-open-files must not be negative, got %d\n", config.maxOpenFiles)
+		flagSet.Usage()
+		os.Exit(1)
 	}
-	fmt.Printf("Target dimensions: %dx%d\n", config.targetWidth, config.targetHeight)
-	fmt.Printf("Landscape borders: Vertical=%.1f%%, Horizontal=%.1f%%\n",
-		config.landscapeVertBorder*100, config.landscapeHorizBorder*100)
-	fmt.Printf("Portrait borders: Vertical=%.1f%%, Horizontal=%.1f%%\n",
-		config.portraitVertBorder*100, config.portraitHorizBorder*100)
-	fmt.Printf("Batch size: %d\n", config.batchSize)
-	fmt.Printf("Max workers: %d\n", config.maxWorkers)
-	fmt.Printf("JPEG quality: %d\n", config.jpegQuality)
-	fmt.Printf("Output prefix: %s\n", config.outputPrefix)
-	fmt.Printf("Separate output folder: %v\n", config.createSeparateFolder)
-	fmt.Println("==================\n")
-}
 
-func (ps *processingStats) addResult(br batchResult) {
-	ps.Lock()
-	defer ps.Unlock()
+	if config.skipExisting && config.force {
+		fmt.Println("Error: -skip-existing keeps existing outputs and -force overwrites them; use one or the other")
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	ps.batchResults = append(ps.batchResults, br)
+	if _, err := filepath.Match(config.pattern, ""); err != nil {
+		fmt.Printf("Error: invalid -pattern %q: %v\n", config.pattern, err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	for _, result := range br.results {
-		if result.error != nil {
-			ps.failedImages++
-			continue
-		}
+	if err := config.applyExtensions(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		ps.totalImages++
-		ps.totalDuration += result.duration
+	if config.minRating < 0 || config.minRating > 5 {
+		fmt.Printf("Error: -min-rating must be between 0 and 5, got %d\n", config.minRating)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		if ps.fastest.duration == 0 || result.duration < ps.fastest.duration {
-			ps.fastest = result
-		}
+	if config.sizeWarning < 0 || (config.sizeWarning > 0 && config.sizeWarning < 1) {
+		fmt.Printf("Error: -size-warning must be 0 or at least 1, got %g\n", config.sizeWarning)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.autoShrink && config.sizeWarning == 0 {
+		fmt.Println("Error: -auto-shrink needs a -size-warning factor")
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.autoShrinkMinQuality < 1 || config.autoShrinkMinQuality > 100 {
+		fmt.Printf("Error: -auto-shrink-min-quality must be between 1 and 100, got %d\n", config.autoShrinkMinQuality)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		if result.duration > ps.slowest.duration {
-			ps.slowest = result
+	if config.qaSample < 0 {
+		fmt.Printf("Error: -qa-sample must not be negative, got %d\n", config.qaSample)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.qaSample > 0 && config.qaSeed == 0 {
+		config.qaSeed = time.Now().UnixNano()
+		if config.reproducible {
+			config.qaSeed = 1
 		}
 	}
-}
-
-func (ps *processingStats) printSummary() {
-	ps.Lock()
-	defer ps.Unlock()
 
-	fmt.Printf("\n📊 === Processing Summary ===\n")
-	fmt.Printf("✅ Total images processed: %d\n", ps.totalImages)
-	fmt.Printf("❌ Failed images: %d\n", ps.failedImages)
+	if _, ok := pngCompressionLevels[config.pngCompression]; !ok {
+		fmt.Printf("Error: invalid -png-compression value %q (expected default, speed, best or none)\n", config.pngCompression)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	if ps.totalImages > 0 {
-		avgDuration := ps.totalDuration / time.Duration(ps.totalImages)
-		fmt.Printf("⏱️  Average processing time: %.2f seconds\n", avgDuration.Seconds())
-		fmt.Printf("🚀 Fastest image: %s (%.2f seconds)\n", ps.fastest.filename, ps.fastest.duration.Seconds())
-		fmt.Printf("🐢 Slowest image: %s (%.2f seconds)\n", ps.slowest.filename, ps.slowest.duration.Seconds())
+	switch config.schedule {
+	case scheduleFIFO, scheduleLJF, scheduleSJF:
+	default:
+		fmt.Printf("Error: invalid -schedule value %q (expected fifo, ljf or sjf)\n", config.schedule)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	fmt.Printf("\n📈 Batch Statistics:\n")
-	for _, batch := range ps.batchResults {
-		batchDuration := batch.endTime.Sub(batch.startTime)
-		successCount := 0
-		for _, result := range batch.results {
-			if result.error == nil {
-				successCount++
-			}
-		}
-		fmt.Printf("📦 Batch %d: %d/%d successful, took %.2f seconds\n",
-			batch.batchID, successCount, len(batch.results), batchDuration.Seconds())
+	if config.borderMaxPx > 0 && config.borderMinPx > config.borderMaxPx {
+		fmt.Printf("Error: -border-min-px (%d) is larger than -border-max-px (%d)\n", config.borderMinPx, config.borderMaxPx)
+		flagSet.Usage()
+		os.Exit(1)
 	}
-}
 
-func main() {
-	// Determine if we're using default configuration
-	usingDefaults := len(os.Args) == 2 && !strings.HasPrefix(os.Args[1], "-")
+	if !border.ValidGravity(config.gravity) {
+		fmt.Printf("Error: invalid -gravity value %q (expected center, north, south, east, west, northwest, northeast, southwest or southeast)\n", config.gravity)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	config, inputFolder := parseFlags()
-	printConfig(config, usingDefaults)
+	if !validBorderMode(config.borderMode) {
+		fmt.Printf("Error: invalid -border-mode value %q (expected solid, blur, auto, edge or dominant)\n", config.borderMode)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.edgeWidth < 1 {
+		fmt.Printf("Error: -edge-width must be at least 1, got %d\n", config.edgeWidth)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	mainStart := time.Now()
+	if config.cornerRadius < 0 {
+		fmt.Printf("Error: -corner-radius must not be negative, got %d\n", config.cornerRadius)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	var outputFolder string
-	if config.createSeparateFolder {
-		outputFolder = filepath.Join(inputFolder, "bordered_images")
-	} else {
-		outputFolder = inputFolder
+	if config.exifThumbnail != "regenerate" && config.exifThumbnail != "strip" {
+		fmt.Printf("Error: invalid -exif-thumbnail value %q (expected regenerate or strip)\n", config.exifThumbnail)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	if config.createSeparateFolder {
-		if err := os.MkdirAll(outputFolder, 0755); err != nil {
-			fmt.Printf("Error creating output folder: %v\n", err)
-			return
+	switch config.colorProfile {
+	case "":
+		config.colorProfile = colorProfileNone
+		if config.preserveMetadata {
+			config.colorProfile = colorProfileKeep
 		}
+	case colorProfileKeep, colorProfileSRGB, colorProfileNone:
+	default:
+		fmt.Printf("Error: invalid -color-profile value %q (expected keep, srgb or none)\n", config.colorProfile)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	files, err := os.ReadDir(inputFolder)
-	if err != nil {
-		fmt.Printf("Error reading directory: %v\n", err)
-		return
+	if config.stripMetadata {
+		if config.preserveMetadata {
+			fmt.Println("Error: -strip-metadata cannot be combined with -preserve-metadata")
+			flagSet.Usage()
+			os.Exit(1)
+		}
+		if config.colorProfile != colorProfileNone {
+			fmt.Printf("Error: -strip-metadata cannot be combined with -color-profile %s\n", config.colorProfile)
+			flagSet.Usage()
+			os.Exit(1)
+		}
+		if config.embedSettings {
+			fmt.Println("Error: -strip-metadata cannot be combined with -embed-settings")
+			flagSet.Usage()
+			os.Exit(1)
+		}
+		config.embedMarker = false
 	}
 
-	jobs := make(chan []imageJob, config.maxWorkers)
-	results := make(chan batchResult, len(files)/config.batchSize+1)
-	var wg sync.WaitGroup
-
-	stats := &processingStats{}
-
-	for i := 0; i < config.maxWorkers; i++ {
-		wg.Add(1)
-		go worker(i, jobs, results, &wg, config)
+	var err error
+	if config.background != backgroundAutoContrast {
+		if config.backgroundColor, err = parseBackground(config.background); err != nil {
+			fmt.Printf("Error: -background: %v\n", err)
+			flagSet.Usage()
+			os.Exit(1)
+		}
+	}
+	if config.lightColor, err = parseColor(*lightColor); err != nil {
+		fmt.Printf("Error: -light-color: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.darkColor, err = parseColor(*darkColor); err != nil {
+		fmt.Printf("Error: -dark-color: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.keylineColor, err = parseColor(*keylineColor); err != nil {
+		fmt.Printf("Error: -keyline-color: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := config.loadWatermark(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	var batch []imageJob
-	batchCount := 0
-	totalImages := 0
+	if config.keylineWidth < 1 || config.keylineWidth > 2 {
+		fmt.Printf("Error: -keyline-width must be 1 or 2, got %d\n", config.keylineWidth)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if config.keylineThreshold < 0 || config.keylineThreshold > 1 || config.keylineFraction < 0 || config.keylineFraction > 1 {
+		fmt.Println("Error: -keyline-threshold and -keyline-fraction must be between 0 and 1")
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		filename := file.Name()
-		ext := strings.ToLower(filepath.Ext(filename))
-		if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
-			continue
-		}
+	if config.vignette < 0 || config.vignette > 1 {
+		fmt.Printf("Error: -vignette must be between 0 and 1, got %g\n", config.vignette)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		inputPath := filepath.Join(inputFolder, filename)
-		outputPath := filepath.Join(outputFolder, fmt.Sprintf("%s%s", config.outputPrefix, filename))
+	if config.contrastThreshold < 0 || config.contrastThreshold > 1 {
+		fmt.Printf("Error: -contrast-threshold must be between 0 and 1, got %g\n", config.contrastThreshold)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		batch = append(batch, imageJob{inputPath, outputPath})
-		totalImages++
+	if err := config.validateJobsMode(*inputFolder); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		if len(batch) == config.batchSize || totalImages == len(files) {
-			if len(batch) > 0 {
-				jobs <- batch
-				batchCount++
-				batch = make([]imageJob, 0, config.batchSize)
-			}
-		}
+	if err := config.validateStdinMode(*inputFolder); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	close(jobs)
+	if err := config.validateDryRun(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+	if err := config.validateResample(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	for result := range results {
-		stats.addResult(result)
+	if err := config.validateJPEGSubsampling(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
 	}
 
-	mainDuration := time.Since(mainStart)
-	fmt.Printf("\nTotal execution time: %.2f seconds\n", mainDuration.Seconds())
-	stats.printSummary()
-}
+	if err := config.validateLogFormat(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-func worker(id int, jobs <-chan []imageJob, results chan<- batchResult, wg *sync.WaitGroup, config *Config) {
-	defer wg.Done()
+	if err := config.validateTIFFCompression(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-	for batch := range jobs {
-		batchStart := time.Now()
-		br := batchResult{
-			batchID:   id,
-			startTime: batchStart,
-		}
+	if err := config.validateOutputEncoding(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := config.validateTransparent(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	if err := config.validateEncoderCmd(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-		for _, job := range batch {
-			start := time.Now()
-			err := processImage(job.inputPath, job.outputPath, config)
-			duration := time.Since(start)
-
-			result := processingResult{
-				filename: filepath.Base(job.inputPath),
-				duration: duration,
-				error:    err,
+	switch config.backend {
+	case backendGo:
+	case backendVips:
+		switch {
+		case config.targetSSIM > 0:
+			fmt.Println("Error: -backend vips does not support -target-ssim")
+		case config.measureQuality:
+			fmt.Println("Error: -backend vips does not support -measure-quality")
+		case config.background == backgroundAutoContrast:
+			fmt.Println("Error: -backend vips does not support -background auto-contrast")
+		case config.background == backgroundTransparent:
+			fmt.Println("Error: -backend vips does not support -background transparent")
+		case config.pngPalette || config.paletteOutput:
+			fmt.Println("Error: -backend vips does not support -png-palette or -palette-output")
+		case config.autoKeyline:
+			fmt.Println("Error: -backend vips does not support -auto-keyline")
+		case config.watermark != nil:
+			fmt.Println("Error: -backend vips does not support -watermark")
+		case config.vignette > 0:
+			fmt.Println("Error: -backend vips does not support -vignette")
+		case config.autoShrink:
+			fmt.Println("Error: -backend vips does not support -auto-shrink")
+		case config.preserveMetadata:
+			fmt.Println("Error: -backend vips does not support -preserve-metadata")
+		case config.embedSettings:
+			fmt.Println("Error: -backend vips does not support -embed-settings")
+		case config.encoderCmd != "":
+			fmt.Println("Error: -backend vips does not support -encoder-cmd")
+		case config.cornerRadius > 0:
+			fmt.Println("Error: -backend vips does not support -corner-radius")
+		case config.borderMode != borderModeSolid:
+			fmt.Println("Error: -backend vips does not support -border-mode")
+		case config.colorProfile != "" && config.colorProfile != colorProfileNone:
+			fmt.Println("Error: -backend vips does not support -color-profile")
+		case config.convertSRGB:
+			fmt.Println("Error: -backend vips does not support -convert-srgb")
+		case config.resample != defaultConfig.resample || config.splitResample():
+			fmt.Println("Error: -backend vips does not support -resample, -resample-up or -resample-down")
+		default:
+			if !vipsAvailable() {
+				fmt.Println("⚠️  vips not found in PATH, falling back to the Go backend")
+				config.backend = backendGo
 			}
+			return &config, *inputFolder, flagSet
+		}
+		flagSet.Usage()
+		os.Exit(1)
+	default:
+		fmt.Printf("Error: invalid -backend value %q (expected go or vips)\n", config.backend)
+		flagSet.Usage()
+		os.Exit(1)
+	}
 
-			br.results = append(br.results, result)
+	return &config, *inputFolder, flagSet
+}
 
-			if err != nil {
-				fmt.Printf("❌ Error processing %s: %v\n", filepath.Base(job.inputPath), err)
-			} else {
-				fmt.Printf("✅ Successfully processed %s in %.2f seconds\n",
-					filepath.Base(job.inputPath), duration.Seconds())
-			}
+// validate checks the basic settings every run depends on, so out-of-range
+// values fail up front rather than producing nonsensical layouts: a border
+// ratio of 0.5 or more leaves no room for the image.
+func (c *Config) validate() error {
+	switch {
+	case c.jpegQuality < 1 || c.jpegQuality > 100:
+		return fmt.Errorf("-jpeg-quality must be between 1 and 100, got %d", c.jpegQuality)
+	case c.targetWidth <= 0 || c.targetHeight <= 0:
+		return fmt.Errorf("-width and -height must be positive, got %dx%d", c.targetWidth, c.targetHeight)
+	case c.batchSize < 1:
+		return fmt.Errorf("-batch-size must be at least 1, got %d", c.batchSize)
+	case c.maxWorkers < 1:
+		return fmt.Errorf("-workers must be at least 1, got %d", c.maxWorkers)
+	}
+	for _, r := range []struct {
+		flag  string
+		ratio float64
+	}{
+		{"-landscape-vert", c.landscapeVertBorder},
+		{"-landscape-horiz", c.landscapeHorizBorder},
+		{"-portrait-vert", c.portraitVertBorder},
+		{"-portrait-horiz", c.portraitHorizBorder},
+	} {
+		if r.ratio < 0 || r.ratio >= 0.5 {
+			return fmt.Errorf("%s must be in [0, 0.5), got %g", r.flag, r.ratio)
 		}
-
-		br.endTime = time.Now()
-		results <- br
 	}
+	// The ratios alone are not enough: -border-min-px and the -border-top
+	// family can still leave no room, for any of several -size canvases.
+	configs := c.sizeConfigs()
+	if configs == nil {
+		configs = []*Config{c}
+	}
+	for _, sc := range configs {
+		if err := sc.borderConfig().Validate(); err != nil {
+			return fmt.Errorf("invalid borders for a %dx%d canvas: %v", sc.targetWidth, sc.targetHeight, err)
+		}
+	}
+	return nil
 }
 
-func processImage(inputPath, outputPath string, config *Config) error {
-	input, err := os.Open(inputPath)
-	if err != nil {
-		return fmt.Errorf("error opening input file: %v", err)
+func printConfig(config *Config, usingDefaults bool) {
+	fmt.Println("\n=== Configuration ===")
+	if usingDefaults {
+		fmt.Println("Using default configuration (no flags provided)")
 	}
-	defer input.Close()
-
-	var img image.Image
-	switch strings.ToLower(filepath.Ext(inputPath)) {
-	case ".jpg", ".jpeg":
-		img, err = jpeg.Decode(input)
-	case ".png":
-		img, err = png.Decode(input)
-	default:
-		return fmt.Errorf("unsupported image format")
+	if len(config.sizes) > 1 {
+		dims := make([]string, len(config.sizes))
+		for i, size := range config.sizes {
+			dims[i] = fmt.Sprintf("%dx%d", size[0], size[1])
+		}
+		fmt.Printf("Target dimensions: %s, each image once per size\n", strings.Join(dims, ", "))
+	} else {
+		fmt.Printf("Target dimensions: %dx%d\n", config.targetWidth, config.targetHeight)
 	}
-	if err != nil {
-		return fmt.Errorf("error decoding image: %v", err)
+	fmt.Printf("Landscape borders: Vertical=%.1f%%, Horizontal=%.1f%%\n",
+		config.landscapeVertBorder*100, config.landscapeHorizBorder*100)
+	fmt.Printf("Portrait borders: Vertical=%.1f%%, Horizontal=%.1f%%\n",
+		config.portraitVertBorder*100, config.portraitHorizBorder*100)
+	if config.borderMinPx > 0 || config.borderMaxPx > 0 {
+		fmt.Printf("Border clamp: min=%dpx, max=%dpx\n", config.borderMinPx, config.borderMaxPx)
 	}
-
-	bounds := img.Bounds()
-	origWidth := bounds.Dx()
-	origHeight := bounds.Dy()
-	isLandscape := origWidth > origHeight
-
-	verticalBorderRatio := config.landscapeVertBorder
-	horizontalBorderRatio := config.landscapeHorizBorder
-	if !isLandscape {
-		verticalBorderRatio = config.portraitVertBorder
-		horizontalBorderRatio = config.portraitHorizBorder
+	bc := config.borderConfig()
+	for _, o := range []struct {
+		name      string
+		landscape bool
+	}{{"landscape", true}, {"portrait", false}} {
+		top, bottom, left, right := bc.Borders(o.landscape)
+		if config.perSide() {
+			fmt.Printf("Effective %s borders: Top=%.0fpx, Bottom=%.0fpx, Left=%.0fpx, Right=%.0fpx\n", o.name, top, bottom, left, right)
+		} else {
+			fmt.Printf("Effective %s borders: Vertical=%.0fpx, Horizontal=%.0fpx\n", o.name, top, left)
+		}
 	}
-
-	availableWidth := float64(config.targetWidth) * (1 - 2*horizontalBorderRatio)
-	availableHeight := float64(config.targetHeight) * (1 - 2*verticalBorderRatio)
-
-	scale := min(
-		availableWidth/float64(origWidth),
-		availableHeight/float64(origHeight),
-	)
-
-	scaledWidth := int(float64(origWidth) * scale)
-	scaledHeight := int(float64(origHeight) * scale)
-
-	// Create the white background image
-	newImg := image.NewRGBA(image.Rect(0, 0, config.targetWidth, config.targetHeight))
-	draw.Draw(newImg, newImg.Bounds(), image.White, image.Point{}, draw.Src)
-
-	// Calculate the position to place the scaled image
-	offsetX := (config.targetWidth - scaledWidth) / 2
-	offsetY := (config.targetHeight - scaledHeight) / 2
-
-	// Create a rectangle for the destination area
-	destRect := image.Rect(offsetX, offsetY, offsetX+scaledWidth, offsetY+scaledHeight)
-
-	// Scale and draw the image in one step using draw.ApproxBiLinear
-	draw.ApproxBiLinear.Scale(newImg, destRect, img, img.Bounds(), draw.Over, nil)
-
-	output, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("error creating output file: %v", err)
+	if config.gravity != defaultConfig.gravity || config.offsetX != 0 || config.offsetY != 0 {
+		fmt.Printf("Placement: %s, offset %+d,%+d px\n", config.gravity, config.offsetX, config.offsetY)
 	}
-	defer output.Close()
-
-	if strings.ToLower(filepath.Ext(outputPath)) == ".png" {
-		err = png.Encode(output, newImg)
+	if config.cornerRadius > 0 {
+		fmt.Printf("Corner radius: %d px\n", config.cornerRadius)
+	}
+	fmt.Printf("Batch size: %d\n", config.batchSize)
+	if config.autoWorkers {
+		fmt.Printf("Max workers: auto (1-%d)\n", config.maxWorkers)
 	} else {
-		err = jpeg.Encode(output, newImg, &jpeg.Options{Quality: config.jpegQuality})
+		fmt.Printf("Max workers: %d\n", config.maxWorkers)
 	}
-	if err != nil {
-		return fmt.Errorf("error encoding output image: %v", err)
+	fmt.Printf("Schedule: %s\n", config.schedule)
+	if config.shard.count > 1 {
+		fmt.Printf("Shard: %s\n", config.shard.String())
 	}
-
-	return nil
+	fmt.Printf("JPEG quality: %d\n", config.jpegQuality)
+	if config.jpegSubsampling != defaultConfig.jpegSubsampling {
+		fmt.Printf("JPEG chroma subsampling: %s\n", config.jpegSubsampling)
+	}
+	if config.targetSSIM > 0 {
+		fmt.Printf("Target SSIM: %.3f (quality %d-%d)\n", config.targetSSIM, minSearchQuality, config.jpegQuality)
+	}
+	fmt.Printf("Output prefix: %s\n", config.outputPrefix)
+	fmt.Printf("Separate output folder: %v\n", config.createSeparateFolder)
+	if config.htmlReport {
+		fmt.Println("HTML report: enabled")
+	}
+	if config.verifyOutputs {
+		fmt.Println("Output verification: enabled")
+	}
+	if config.measureQuality {
+		fmt.Println("Quality measurement: enabled")
+	}
+	if config.fsync {
+		fmt.Println("Durable writes (fsync): enabled")
+	}
+	switch config.borderMode {
+	case borderModeBlur:
+		fmt.Println("Border mode: blurred copy of the image")
+	case borderModeAuto:
+		fmt.Printf("Border mode: average color of the outer %.0f%% of each image\n", edgeRingFraction*100)
+	case borderModeEdge:
+		fmt.Printf("Border mode: average color of the outer %dpx of each image\n", config.edgeWidth)
+	case borderModeDominant:
+		fmt.Println("Border mode: most common color of each image")
+	}
+	if config.background == backgroundAutoContrast {
+		fmt.Printf("Border color: auto-contrast (%s below luminance %.2f, %s above)\n",
+			formatColor(config.lightColor), config.contrastThreshold, formatColor(config.darkColor))
+	} else if config.background == backgroundTransparent {
+		fmt.Println("Border color: transparent")
+	} else if config.background != defaultConfig.background {
+		fmt.Printf("Border color: %s\n", formatColor(config.backgroundColor))
+	}
+	if config.autoKeyline {
+		fmt.Printf("Auto keyline: %dpx %s when %.0f%% of edge pixels are within %.2f luminance of the border\n",
+			config.keylineWidth, formatColor(config.keylineColor), config.keylineFraction*100, 1-config.keylineThreshold)
+	}
+	if config.watermark != nil {
+		fmt.Printf("Watermark: %s at %s, opacity %.2f\n", config.watermarkPath, config.watermarkPosition, config.watermarkOpacity)
+	}
+	if config.vignette > 0 {
+		fmt.Printf("Vignette: strength %.2f\n", config.vignette)
+	}
+	if config.outputEncoding == encodingJPEG || config.outputEncoding == encodingPNG || config.outputEncoding == encodingGIF || config.outputEncoding == encodingTIFF {
+		fmt.Printf("Output encoding: %s for every image\n", strings.ToUpper(config.outputEncoding))
+	}
+	if config.outputEncoding == encodingJXL {
+		fmt.Printf("Output encoding: JPEG XL (distance %g, effort %d)\n", config.jxlDistance, config.jxlEffort)
+	}
+	if config.outputEncoding == encodingWebP {
+		fmt.Printf("Output encoding: WebP (quality %d)\n", config.webpQuality)
+	}
+	if config.encoderCmd != "" {
+		fallback := "built-in encoder on failure"
+		if config.encoderStrict {
+			fallback = "strict"
+		}
+		fmt.Printf("JPEG encoder: %s (%s input, %s)\n", config.encoderCmd, config.encoderInput, fallback)
+	}
+	if config.outputEncoding == encodingAVIF {
+		fmt.Printf("Output encoding: AVIF (quality %d, speed %d)\n", config.avifQuality, config.avifSpeed)
+	}
+	if config.preserveMetadata {
+		fmt.Printf("Preserve metadata: EXIF of JPEG inputs (thumbnail: %s)\n", config.exifThumbnail)
+	}
+	if config.embedSettings {
+		fmt.Println("Embed settings: JPEG comment with the source, canvas, borders and scale")
+	}
+	if config.dryRun {
+		fmt.Println("Dry run: inputs are decoded and planned, nothing is written")
+	}
+	if config.colorProfile != colorProfileNone {
+		fmt.Printf("Color profile: %s\n", config.colorProfile)
+	}
+	if config.convertSRGB {
+		fmt.Println("Convert to sRGB: Adobe RGB and Display P3 images")
+	}
+	if config.stripMetadata {
+		fmt.Println("Strip metadata: no EXIF, XMP, IPTC, thumbnails or processing marker in outputs")
+	}
+	if config.pngCompression != defaultConfig.pngCompression {
+		fmt.Printf("PNG compression: %s\n", config.pngCompression)
+	}
+	if config.tiffCompression != defaultConfig.tiffCompression {
+		fmt.Printf("TIFF compression: %s\n", config.tiffCompression)
+	}
+	if config.force8Bit {
+		fmt.Println("16-bit inputs: written as 8-bit PNGs and TIFFs")
+	}
+	if !config.preserveGray {
+		fmt.Println("Grayscale inputs: written in color")
+	}
+	if config.pngPalette {
+		fmt.Printf("Indexed PNG output: enabled (dithering: %v)\n", config.dither)
+	} else if config.paletteOutput {
+		fmt.Printf("Indexed PNG output: for indexed-color sources (dithering: %v)\n", config.dither)
+	}
+	if config.sidecarsJSON {
+		fmt.Println("JSON sidecars: enabled")
+	}
+	if config.backend != backendGo {
+		fmt.Printf("Backend: %s\n", config.backend)
+	}
+	if config.showPreview {
+		fmt.Printf("Terminal preview: first %d image(s)\n", config.previewCount)
+	}
+	switch {
+	case config.sftp != nil:
+		fmt.Printf("Output: %s (%d connection(s))\n", config.sftp, config.transferConcurrency)
+	case config.outputDir != "":
+		fmt.Printf("Output: %s\n", config.outputDir)
+	}
+	if config.maxOpenFiles > 0 {
+		fmt.Printf("Open files: at most %d image(s) read or written at once\n", config.maxOpenFiles)
+	}
+	if config.maxMemory > 0 {
+		fmt.Printf("Memory: at most %s of estimated image memory in use at once\n", formatBytes(int64(config.maxMemory)))
+	}
+	if config.splitResample() {
+		fmt.Printf("Resampling: up %s, down %s\n", config.kernelFor(2), config.kernelFor(0.5))
+	} else if config.resample != defaultConfig.resample {
+		fmt.Printf("Resampling: %s\n", config.resample)
+	}
+	if config.reproducible {
+		fmt.Println("Reproducible output: enabled")
+	}
+	if config.autoShrink {
+		fmt.Printf("Auto-shrink: outputs over %g× their input, down to quality %d\n", config.sizeWarning, config.autoShrinkMinQuality)
+	}
+	if config.recursive {
+		fmt.Println("Recursive: including subfolders")
+	}
+	if config.watch {
+		fmt.Println("Watch: processing images added to the folder until interrupted")
+	}
+	if config.pattern != "" {
+		fmt.Printf("Pattern: %s\n", config.pattern)
+	}
+	if config.extensions != nil {
+		exts := make([]string, 0, len(config.extensions))
+		for ext := range config.extensions {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		fmt.Printf("Extensions: %s\n", strings.Join(exts, " "))
+	}
+	if config.dedup {
+		fmt.Println("Duplicates: images with the same content as an earlier one skipped")
+	}
+	if config.skipExisting {
+		fmt.Println("Existing outputs: skipped")
+	} else if config.force {
+		fmt.Println("Existing outputs: overwritten")
+	}
+	if config.animatedGIF {
+		fmt.Println("GIFs: every frame, written as animated GIF")
+	}
+	if config.minRating > 0 {
+		unrated := "skipped"
+		if config.includeUnrated {
+			unrated = "included"
+		}
+		fmt.Printf("Minimum rating: %d★ (unrated images %s)\n", config.minRating, unrated)
+	}
+	if config.qaSample > 0 {
+		fmt.Printf("QA sample: %d image(s), seed %d\n", config.qaSample, config.qaSeed)
+	}
+	if config.tune {
+		fmt.Printf("Tuning UI: http://%s/\n", config.tuneAddr)
+	}
+	fmt.Print("==================\n\n")
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "undo":
+			runUndo(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		}
+	}
+
+	// Determine if we're using default configuration
+	usingDefaults := len(os.Args) == 2 && !strings.HasPrefix(os.Args[1], "-")
+
+	config, inputFolder, flagSet := parseFlags()
+	if err := config.validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		flagSet.Usage()
+		os.Exit(1)
+	}
+	// The configuration would garble a log of JSON lines.
+	if config.logFormat == logFormatText {
+		printConfig(config, usingDefaults)
+	}
+
+	mainStart := time.Now()
+
+	maxOpenFiles := config.maxOpenFiles
+	if maxOpenFiles == 0 {
+		maxOpenFiles = defaultMaxOpenFiles()
+	}
+	openFiles = newFileSlots(maxOpenFiles)
+	if config.maxMemory > 0 {
+		memory = newMemoryBudget(int64(config.maxMemory))
+	}
+	if config.dedup {
+		dedup = newContentSet()
+	}
+
+	if config.jobsPath != "" {
+		runJobSpec(interruptContext(), config, mainStart)
+		return
+	}
+
+	outputFolder := outputFolderFor(config, inputFolder)
+
+	if config.diffSettings {
+		jobs, err := plannedJobs(config, inputFolder, outputFolder)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := diffSettings(jobs, config, config.diffList); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.tune {
+		if err := runTune(config, inputFolder, flagSet); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := runFolder(interruptContext(), config, inputFolder, mainStart, nil); err != nil {
+		if errors.Is(err, errInterrupted) {
+			os.Exit(exitInterrupted)
+		}
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// outputFolderFor returns the folder outputs are written to.
+func outputFolderFor(config *Config, inputFolder string) string {
+	switch {
+	case config.sftp != nil:
+		return config.sftp.dir
+	case config.outputDir != "":
+		return config.outputDir
+	case config.createSeparateFolder:
+		return filepath.Join(inputFolder, "bordered_images")
+	}
+	return inputFolder
+}
+
+// runFolder processes every image of inputFolder and prints the summary.
+// onResult, if set, is called with every result and the number of images
+// in the run. When ctx is canceled the images not yet started are skipped
+// and errInterrupted is returned after the summary.
+func runFolder(ctx context.Context, config *Config, inputFolder string, mainStart time.Time, onResult func(r processingResult, total int)) error {
+	outputFolder := outputFolderFor(config, inputFolder)
+
+	switch {
+	case config.dryRun:
+		// Nothing is written, so the output folder need not exist yet.
+	case config.sftp != nil:
+		pool, err := dialSFTPPool(config.sftp, config.transferConcurrency, config.sftpKey, config.knownHosts)
+		if err != nil {
+			return err
+		}
+		defer pool.close()
+		if err := pool.probe(); err != nil {
+			return fmt.Errorf("output folder %s is not writable: %v", config.sftp, err)
+		}
+		remoteOutput = pool
+		defer func() { remoteOutput = nil }()
+	case config.stdin && config.outputDir == "":
+		// The listed inputs may be in any number of folders; the output
+		// folder next to each is made along with the jobs below.
+	default:
+		if config.createSeparateFolder || config.outputDir != "" {
+			if err := os.MkdirAll(outputFolder, 0755); err != nil {
+				return fmt.Errorf("creating output folder: %v", err)
+			}
+		}
+
+		if err := probeWritable(outputFolder); err != nil {
+			return fmt.Errorf("output folder %s is not writable: %v", outputFolder, err)
+		}
+	}
+
+	files, source, err := runInputs(config, inputFolder, outputFolder)
+	if err != nil {
+		return err
+	}
+
+	var report *htmlReport
+	if config.htmlReport {
+		report = &htmlReport{}
+	}
+
+	// Without fifo, results finish in schedule order; the manifest and
+	// report are still written in input order.
+	ordered := config.reproducible || config.schedule != scheduleFIFO
+	var manifest *manifestWriter
+	if config.manifestPath != "" {
+		manifest, err = newManifestWriter(config.manifestPath, ordered)
+		if err != nil {
+			return fmt.Errorf("creating manifest: %v", err)
+		}
+	}
+
+	var csv *csvReport
+	if config.reportPath != "" {
+		csv, err = newCSVReport(config.reportPath, ordered)
+		if err != nil {
+			return fmt.Errorf("creating report: %v", err)
+		}
+	}
+
+	// Previews are skipped silently when stdout cannot display them.
+	var previewProtocol string
+	if config.showPreview {
+		previewProtocol = detectPreviewProtocol()
+	}
+
+	var batches [][]imageJob
+	var batch []imageJob
+	totalImages := 0
+	planner := newJobPlanner(config, inputFolder, outputFolder, config.sftp == nil && !config.dryRun)
+	// outputs are the outputs of the jobs so far, which -watch must not
+	// take for new inputs when they are written next to them.
+	outputs := make(map[string]bool)
+	// mu guards the planner and the counts and maps above, which the -watch
+	// goroutine keeps updating while the images are processed.
+	var mu sync.Mutex
+
+	// jobsFor returns the jobs for the input file at rel, none when it is
+	// not an image or is filtered out, and counts them.
+	jobsFor := func(index int, rel string) ([]imageJob, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		jobs, err := planner.plan(index, rel)
+		for i := range jobs {
+			jobs[i].wantThumbnail = report != nil && len(files) <= htmlInlineThumbnailLimit
+			jobs[i].wantPreview = previewProtocol != "" && totalImages < config.previewCount
+			outputs[jobs[i].outputPath] = true
+			totalImages++
+		}
+		return jobs, err
+	}
+
+	for _, i := range scheduleOrder(files, config.schedule) {
+		jobs, err := jobsFor(i, files[i].rel)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			batch = append(batch, job)
+			if len(batch) == config.batchSize {
+				batches = append(batches, batch)
+				batch = make([]imageJob, 0, config.batchSize)
+			}
+		}
+	}
+	// The listing also holds folders and other files, so the last partial
+	// batch is only complete once the loop is done.
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	if config.pattern != "" && planner.seenImages == 0 && !config.watch {
+		return fmt.Errorf("no images in %s match -pattern %q", source, config.pattern)
+	}
+
+	// With -watch, files added to the folder after this listing are fed to
+	// the same workers, each as a batch of its own, until interrupted.
+	var watched chan imageJob
+	// watching is closed once the -watch goroutine is done with the state
+	// jobsFor updates, so the summary can read it.
+	watching := make(chan struct{})
+	if config.watch {
+		added, err := watchFolder(ctx, inputFolder)
+		if err != nil {
+			return fmt.Errorf("watching %s: %v", inputFolder, err)
+		}
+		watched = make(chan imageJob)
+		go func() {
+			defer close(watching)
+			defer close(watched)
+			index := len(files)
+			for name := range added {
+				if ok, _ := filepath.Match(config.pattern, name); config.pattern != "" && !ok {
+					continue
+				}
+				mu.Lock()
+				ours := outputs[filepath.Join(inputFolder, name)]
+				mu.Unlock()
+				if ours {
+					continue
+				}
+				jobs, err := jobsFor(index, name)
+				if err != nil {
+					fmt.Printf("⚠️  %s: %v\n", name, err)
+				}
+				index++
+				for _, job := range jobs {
+					select {
+					case watched <- job:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+		fmt.Printf("👀 Watching %s for new images, press Ctrl-C to stop\n", inputFolder)
+	} else {
+		close(watching)
+	}
+
+	sinks := runSinks{
+		report:          report,
+		manifest:        manifest,
+		csv:             csv,
+		previewProtocol: previewProtocol,
+	}
+	var sampler *qaSampler
+	if config.qaSample > 0 {
+		sampler = newQASampler(config.qaSample, config.qaSeed)
+	}
+	if sampler != nil || onResult != nil {
+		sinks.onResult = func(r processingResult) {
+			if sampler != nil {
+				sampler.add(r)
+			}
+			if onResult != nil {
+				mu.Lock()
+				total := totalImages
+				mu.Unlock()
+				onResult(r, total)
+			}
+		}
+	}
+	stats, scaler := processBatches(ctx, batches, config, sinks, watched)
+	<-watching
+
+	if manifest != nil {
+		if err := manifest.close(); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+		}
+	}
+	if csv != nil {
+		if err := csv.close(); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+		}
+	}
+
+	stats.printSummary(time.Since(mainStart))
+	if ctx.Err() != nil {
+		stats.printInterrupted(totalImages)
+	}
+	if config.shard.count > 1 {
+		fmt.Printf("🧩 Shard %s: owned %d of %d images\n", config.shard.String(), totalImages, planner.seenImages)
+	}
+	if config.minRating > 0 {
+		fmt.Printf("⭐ Filtered: %d image(s) rated below %d★\n", planner.belowRating, config.minRating)
+	}
+	if scaler != nil {
+		scaler.printTimeline()
+	}
+	if config.fsync && !config.dryRun {
+		fmt.Printf("💾 Durable writes: every output was fsynced along with its folder\n")
+	}
+
+	if sampler != nil {
+		copied, err := sampler.copyTo(outputFolder)
+		if err != nil {
+			fmt.Printf("Error copying QA sample: %v\n", err)
+		}
+		fmt.Printf("\n🔍 QA sample (seed %d): %d image(s) in %s\n", config.qaSeed, len(copied), filepath.Join(outputFolder, qaSampleFolder))
+		for _, path := range copied {
+			fmt.Printf("   %s\n", filepath.Base(path))
+		}
+	}
+
+	if report != nil {
+		if path, err := report.write(outputFolder); err != nil {
+			fmt.Printf("Error writing HTML report: %v\n", err)
+		} else {
+			fmt.Printf("\n📝 HTML report written to %s\n", path)
+		}
+	}
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
+
+// runSinks receive the results of processBatches as they arrive.
+type runSinks struct {
+	report          *htmlReport
+	manifest        *manifestWriter
+	csv             *csvReport
+	previewProtocol string
+	// onResult, if set, is called with every result.
+	onResult func(processingResult)
+}
+
+// processBatches runs the images of batches through the worker pool, feeding
+// every result into the run statistics and sinks. Workers take one image at
+// a time, so a batch of large images does not hold up one worker while
+// others idle; the batches only group the results, which reach the
+// statistics and sinks once every image of their batch is done. It returns
+// the statistics and, with -workers auto, the stopped autoscaler for its
+// timeline. Once ctx is canceled no further images are handed out. Jobs
+// received from watched after batches, until it is closed, are processed as
+// batches of one.
+func processBatches(ctx context.Context, batches [][]imageJob, config *Config, sinks runSinks, watched <-chan imageJob) (*processingStats, *autoscaler) {
+	jobs := make(chan imageJob, config.maxWorkers)
+	results := make(chan processingResult, config.maxWorkers)
+	var wg sync.WaitGroup
+
+	if !config.skipExisting && !config.force && !config.dryRun && config.sftp == nil {
+		warnOverwrites(batches)
+	}
+
+	total := 0
+	for _, batch := range batches {
+		total += len(batch)
+	}
+	stats := newProcessingStats(config.maxWorkers + 1)
+	stats.verbose = config.verbose
+	switch {
+	case config.logFormat == logFormatJSON:
+		stats.json = newJSONLog()
+	case !config.verbose:
+		stats.progress = newProgressLine(total)
+	}
+
+	var scaler *autoscaler
+	if config.autoWorkers {
+		scaler = newAutoscaler(config.maxWorkers)
+		go scaler.run()
+	}
+
+	// Workers beyond the number of images would never get one.
+	workers := config.maxWorkers
+	if workers > total && watched == nil {
+		workers = total
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go worker(ctx, jobs, results, &wg, config, scaler, stats)
+	}
+
+	go func() {
+		defer close(jobs)
+		for id, batch := range batches {
+			for _, job := range batch {
+				job.batch = id
+				job.duplicateOf = dedup.claim(job.inputPath)
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		for id := len(batches); watched != nil; id++ {
+			select {
+			case job, ok := <-watched:
+				if !ok {
+					return
+				}
+				job.batch = id
+				job.duplicateOf = dedup.claim(job.inputPath)
+				select {
+				case jobs <- job:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// pending holds the batches with images still being processed.
+	pending := make(map[int]*batchResult)
+	for r := range results {
+		br := pending[r.batch]
+		if br == nil {
+			br = &batchResult{batchID: r.batch, startTime: r.finishedAt.Add(-r.duration)}
+			pending[r.batch] = br
+		}
+		if start := r.finishedAt.Add(-r.duration); start.Before(br.startTime) {
+			br.startTime = start
+		}
+		if r.finishedAt.After(br.endTime) {
+			br.endTime = r.finishedAt
+		}
+		br.results = append(br.results, r)
+		if r.batch >= len(batches) || len(br.results) == len(batches[r.batch]) {
+			delete(pending, r.batch)
+			addBatchResult(*br, config, stats, sinks)
+		}
+	}
+	// An interrupted run leaves batches partly processed.
+	for id := range batches {
+		if br := pending[id]; br != nil {
+			addBatchResult(*br, config, stats, sinks)
+		}
+	}
+
+	stats.finishProgress()
+	if scaler != nil {
+		scaler.stop()
+	}
+	return stats, scaler
+}
+
+// addBatchResult feeds the results of a batch into the run statistics and
+// sinks.
+func addBatchResult(result batchResult, config *Config, stats *processingStats, sinks runSinks) {
+	stats.addResult(result)
+	if config.reproducible {
+		// Timings only go to the console; nothing written keeps them.
+		for i := range result.results {
+			result.results[i].duration = 0
+		}
+	}
+	if sinks.report != nil {
+		sinks.report.add(result.results)
+	}
+	if sinks.manifest != nil {
+		if err := sinks.manifest.add(result.results); err != nil {
+			fmt.Printf("Error writing manifest: %v\n", err)
+		}
+	}
+	if sinks.csv != nil {
+		if err := sinks.csv.add(result.results); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+		}
+	}
+	for _, r := range result.results {
+		if sinks.onResult != nil {
+			sinks.onResult(r)
+		}
+		if r.info.preview == nil {
+			continue
+		}
+		if err := showPreview(r.info.preview, r.filename, sinks.previewProtocol); err != nil {
+			fmt.Printf("Error showing preview: %v\n", err)
+		}
+	}
+}
+
+// outputExists reports whether a previous run left an output at path.
+func outputExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// warnOverwrites warns, before any image is processed, about the outputs
+// of batches that already exist and are about to be replaced.
+func warnOverwrites(batches [][]imageJob) {
+	existing := 0
+	for _, batch := range batches {
+		for _, job := range batch {
+			if outputExists(job.outputPath) {
+				existing++
+			}
+		}
+	}
+	if existing > 0 {
+		fmt.Printf("⚠️  %d output(s) already exist and will be overwritten (-skip-existing keeps them, -force silences this warning)\n", existing)
+	}
+}
+
+// probeWritable checks that files can be created in dir by creating and
+// removing a small probe file, so a read-only destination fails once up front
+// instead of once per image after all the decode work.
+func probeWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".wbi-probe-*")
+	if err != nil {
+		return err
+	}
+	name := probe.Name()
+	_, err = probe.Write([]byte("probe"))
+	if cerr := probe.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// outputNameFor returns the output filename (without prefix) for an input
+// file, or false if the file is not a supported image or -ext leaves its
+// extension out.
+func outputNameFor(filename string, config *Config) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	format, ok := inputFormatFor(ext)
+	if !ok || !config.scansExtension(ext) {
+		return "", false
+	}
+	return outputNameAs(filename, format, ext, config), true
+}
+
+// outputNameForContent is outputNameFor for the input at path, going by
+// the format its content is in when that differs from what its extension
+// says, as for a PNG saved as .jpg by a messaging app. The mismatch is
+// logged.
+func outputNameForContent(path string, config *Config) (string, bool) {
+	filename := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(filename))
+	format, ok := inputFormatFor(ext)
+	if !ok || !config.scansExtension(ext) {
+		return "", false
+	}
+	// SVGs are not decoded by the image package.
+	if format != "svg" {
+		if sniffed := sniffFormat(path); sniffed != "" && sniffed != format {
+			fmt.Printf("⚠️  %s holds a %s image, not %s as its extension says; treated as %s\n",
+				filename, strings.ToUpper(sniffed), strings.ToUpper(format), strings.ToUpper(sniffed))
+			format, ext = sniffed, formatExtensions[sniffed]
+		}
+	}
+	return outputNameAs(filename, format, ext, config), true
+}
+
+// outputNameAs returns the output filename for filename holding an image of
+// format, whose own extension (lowercase) is ext.
+func outputNameAs(filename, format, ext string, config *Config) string {
+	outputExt := outputExtFor(format, ext)
+	if format == "bmp" && config.bmpPNG {
+		outputExt = ".png"
+	}
+	switch config.outputEncoding {
+	case encodingJPEG:
+		if outputExt != ".jpeg" {
+			outputExt = ".jpg"
+		}
+	case encodingPNG:
+		outputExt = ".png"
+	case encodingJXL:
+		outputExt = ".jxl"
+	case encodingWebP:
+		outputExt = ".webp"
+	case encodingAVIF:
+		outputExt = ".avif"
+	case encodingGIF:
+		outputExt = ".gif"
+	case encodingTIFF:
+		if outputExt != ".tiff" {
+			outputExt = ".tif"
+		}
+	}
+	if format == "gif" && config.animatedGIF {
+		outputExt = ".gif"
+	}
+	if outputExt == strings.ToLower(filepath.Ext(filename)) {
+		return filename
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + outputExt
+}
+
+// worker processes jobs one image at a time until the channel is closed,
+// sending the result of each to results.
+func worker(ctx context.Context, jobs <-chan imageJob, results chan<- processingResult, wg *sync.WaitGroup, config *Config, scaler *autoscaler, stats *processingStats) {
+	defer wg.Done()
+
+	for {
+		scaler.acquire()
+		job, ok := <-jobs
+		if !ok {
+			scaler.release(0, 0)
+			return
+		}
+		// Between images is where an interrupted run stops; the jobs
+		// already queued are drained unprocessed.
+		if ctx.Err() != nil {
+			scaler.release(0, 0)
+			continue
+		}
+
+		start := time.Now()
+		result, ok := processJob(ctx, job, config, stats)
+		if !ok {
+			scaler.release(0, 0)
+			continue
+		}
+		scaler.release(1, time.Since(start))
+		results <- result
+	}
+}
+
+// processJob processes the image of job and logs the outcome. It reports
+// false when the run was interrupted before the image was done.
+func processJob(ctx context.Context, job imageJob, config *Config, stats *processingStats) (processingResult, bool) {
+	defer job.decoded.release()
+	cfg := config
+	if job.config != nil {
+		cfg = job.config
+	}
+	if cfg.skipExisting && !cfg.dryRun && outputExists(job.outputPath) {
+		result := processingResult{
+			index:      job.index,
+			page:       job.page,
+			sizeIndex:  job.sizeIndex,
+			batch:      job.batch,
+			filename:   job.name(),
+			inputPath:  job.inputPath,
+			outputPath: job.outputPath,
+			finishedAt: time.Now(),
+			skipped:    true,
+		}
+		stats.imageDone(result)
+		if stats.json != nil {
+			stats.logImage(result)
+		} else if cfg.verbose {
+			fmt.Printf("⏭️  Skipped %s: %s already exists\n", result.filename, filepath.Base(job.outputPath))
+		}
+		return result, true
+	}
+	if job.duplicateOf != "" {
+		result := processingResult{
+			index:       job.index,
+			page:        job.page,
+			sizeIndex:   job.sizeIndex,
+			batch:       job.batch,
+			filename:    job.name(),
+			inputPath:   job.inputPath,
+			outputPath:  job.outputPath,
+			finishedAt:  time.Now(),
+			skipped:     true,
+			duplicateOf: job.duplicateOf,
+		}
+		stats.imageDone(result)
+		if stats.json != nil {
+			stats.logImage(result)
+		} else if cfg.verbose {
+			fmt.Printf("🔁 Skipped %s: same content as %s\n", result.filename, filepath.Base(job.duplicateOf))
+		}
+		return result, true
+	}
+	start := time.Now()
+	info, err := processImage(ctx, job, cfg)
+	if errors.Is(err, context.Canceled) {
+		return processingResult{}, false
+	}
+	duration := time.Since(start)
+	if err == nil && !info.oversized {
+		info.oversized = cfg.oversized(info.outputBytes, info.inputBytes)
+	}
+	outputPath := job.outputPath
+	if info.outputPath != "" {
+		outputPath = info.outputPath
+	}
+
+	result := processingResult{
+		index:      job.index,
+		page:       job.page,
+		sizeIndex:  job.sizeIndex,
+		batch:      job.batch,
+		filename:   job.name(),
+		inputPath:  job.inputPath,
+		outputPath: outputPath,
+		info:       info,
+		duration:   duration,
+		finishedAt: time.Now(),
+		error:      err,
+	}
+
+	// The sidecar follows the image: written once it succeeded, and
+	// otherwise removed so it cannot describe an older output.
+	if err == nil && cfg.sidecarsJSON {
+		sidecarResult := result
+		if cfg.reproducible {
+			sidecarResult.duration = 0
+		}
+		if serr := writeSidecar(sidecarResult, cfg); serr != nil {
+			err = fmt.Errorf("error writing sidecar: %v", serr)
+			result.error = err
+		}
+	}
+	if (err != nil || !cfg.sidecarsJSON) && !cfg.dryRun {
+		if serr := removeStaleSidecar(job.outputPath); serr != nil {
+			fmt.Printf("⚠️  Could not remove stale sidecar of %s: %v\n", filepath.Base(job.outputPath), serr)
+		}
+	}
+
+	stats.imageDone(result)
+	switch {
+	case stats.json != nil:
+		stats.logImage(result)
+	case err != nil:
+		stats.logf("❌ Error processing %s: %v\n", job.name(), err)
+	case cfg.verbose:
+		printImageLog(job, outputPath, info, cfg, duration)
+	}
+	return result, true
+}
+
+// printImageLog prints the -verbose log lines of an image processed
+// without error.
+func printImageLog(job imageJob, outputPath string, info imageInfo, cfg *Config, duration time.Duration) {
+	if info.planned {
+		fmt.Println(planLine(info, job.inputPath, outputPath))
+	} else if info.jpegQuality > 0 {
+		fmt.Printf("✅ Successfully processed %s in %.2f seconds (quality %d, SSIM %.4f)\n",
+			job.name(), duration.Seconds(), info.jpegQuality, info.ssim)
+	} else if cfg.verifyOutputs {
+		fmt.Printf("✅ Successfully processed %s in %.2f seconds (verified in %.2f seconds)\n",
+			job.name(), duration.Seconds(), info.verifyDuration.Seconds())
+	} else {
+		fmt.Printf("✅ Successfully processed %s in %.2f seconds\n",
+			job.name(), duration.Seconds())
+	}
+	if cfg.stripMetadata {
+		fmt.Printf("   🧹 %s: metadata stripped\n", job.name())
+	}
+	if info.convertedFrom != "" {
+		fmt.Printf("   🌈 %s: converted from %s to sRGB\n", job.name(), info.convertedFrom)
+	}
+	if info.autoShrink != "" {
+		fmt.Printf("   🗜️  %s: over %g× its input, auto-shrunk (%s) to %.1f×\n",
+			job.name(), cfg.sizeWarning, info.autoShrink, float64(info.outputBytes)/float64(info.inputBytes))
+	} else if info.oversized {
+		fmt.Printf("   ⚠️  %s: output is %.1f× the size of its input (%s vs %s)\n",
+			job.name(), float64(info.outputBytes)/float64(info.inputBytes),
+			formatBytes(info.outputBytes), formatBytes(info.inputBytes))
+	}
+	if info.paletteSkipped {
+		fmt.Printf("   ⚠️  %s looks photographic (more than %d colors), kept as a truecolor PNG\n",
+			job.name(), photoColorThreshold)
+	}
+	if cfg.autoKeyline {
+		decision := "no keyline"
+		if info.keyline {
+			decision = "keyline drawn"
+		}
+		fmt.Printf("   🖊️  %s: %.0f%% of edge pixels blend into the border, %s\n",
+			job.name(), info.edgeBlend*100, decision)
+	}
+	if cfg.splitResample() {
+		fmt.Printf("   🔍 %s: scaled %.2f× with %s\n",
+			job.name(), info.layout.scale, info.kernel)
+	}
+	if info.luminance >= 0 {
+		fmt.Printf("   🎨 %s: average luminance %.2f, %s border\n",
+			job.name(), info.luminance, formatColor(info.background))
+	} else if cfg.borderMode == borderModeAuto || cfg.borderMode == borderModeEdge {
+		fmt.Printf("   🎨 %s: edge color %s border\n",
+			job.name(), formatColor(info.background))
+	} else if cfg.borderMode == borderModeDominant {
+		fmt.Printf("   🎨 %s: dominant color %s border\n",
+			job.name(), formatColor(info.background))
+	}
+}
+
+func processImage(ctx context.Context, job imageJob, config *Config) (imageInfo, error) {
+	if config.dryRun {
+		return planImage(job, config)
+	}
+	if memory != nil {
+		need := estimateMemory(job.inputPath, config)
+		memory.acquire(need)
+		defer memory.release(need)
+	}
+	// GIF inputs written as GIF keep all their frames.
+	if format, _ := inputFormatFor(filepath.Ext(job.inputPath)); format == "gif" && strings.EqualFold(filepath.Ext(job.outputPath), ".gif") {
+		return processAnimatedGIF(job, config)
+	}
+	if config.backend == backendVips && vipsHandles(filepath.Ext(job.inputPath)) {
+		return processImageVips(job, config)
+	}
+
+	var info imageInfo
+
+	var img image.Image
+	var err error
+	if job.decoded != nil {
+		img, err = job.decoded.decode(job, config, &info)
+	} else {
+		img, err = decodeInput(job.inputPath, job.page, config, &info)
+	}
+	if err != nil {
+		return info, err
+	}
+	format := info.format
+	// Rendering and encoding are skipped for an interrupted run.
+	if err := ctx.Err(); err != nil {
+		return info, err
+	}
+
+	// Converting before the canvas is drawn keeps the border color exact.
+	if config.convertSRGB {
+		profile, err := readICCProfile(job.inputPath)
+		if err != nil {
+			return info, err
+		}
+		if g := detectWideGamut(profile); g != nil {
+			img = toSRGB(img, g)
+			info.convertedFrom = g.name
+		}
+	}
+
+	canvas, newImg := renderCanvas(img, job.outputPath, config, &info)
+	l := info.layout
+
+	outputPath := job.outputPath
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	isPNG := ext == ".png"
+	_, paletted := img.(*image.Paletted)
+	indexedPNG := isPNG && (config.pngPalette || config.paletteOutput && paletted)
+	encode := func(w io.Writer) error {
+		switch {
+		case ext == ".jxl":
+			return encodeJXL(w, newImg, config.jxlDistance, config.jxlEffort)
+		case ext == ".webp":
+			return encodeWebP(w, newImg, config.webpQuality)
+		case ext == ".avif":
+			return encodeAVIF(w, newImg, config.avifQuality, config.avifSpeed)
+		case ext == ".tif" || ext == ".tiff":
+			return config.encodeTIFF(w, canvas)
+		case ext == ".gif":
+			colors, _ := countColors(newImg, math.MaxInt)
+			return gif.Encode(w, palettize(newImg, colors, config.dither), nil)
+		case indexedPNG:
+			if indexed, colors := quantizeCanvas(newImg, config.dither); indexed != nil {
+				info.paletteColors = colors
+				return config.encodePNG(w, indexed)
+			}
+			info.paletteSkipped = true
+			return config.encodePNG(w, newImg)
+		case isPNG:
+			return config.encodePNG(w, canvas)
+		case config.targetSSIM > 0:
+			var data []byte
+			var err error
+			data, info.jpegQuality, info.ssim, err = encodeJPEGForSSIM(canvas, config.targetSSIM, config.jpegQuality, config.encodeJPEG)
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			return err
+		case config.encoderCmd != "":
+			data, err := config.runEncoderCmd(canvas, config.jpegQuality)
+			if err != nil && !config.encoderStrict {
+				fmt.Printf("⚠️  %s: %v, using the built-in encoder\n", job.name(), err)
+				return config.encodeJPEG(w, canvas, config.jpegQuality)
+			}
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			return err
+		default:
+			return config.encodeJPEG(w, canvas, config.jpegQuality)
+		}
+	}
+	if config.autoShrink && config.targetSSIM == 0 && !indexedPNG {
+		shrunk, err := autoShrink(canvas, outputPath, info.inputBytes, config)
+		if err != nil {
+			return info, fmt.Errorf("error encoding output image: %v", err)
+		}
+		if shrunk != nil {
+			encode = func(w io.Writer) error {
+				_, err := w.Write(shrunk.data)
+				return err
+			}
+			info.oversized, info.autoShrink = shrunk.oversized, shrunk.change
+			if shrunk.path != outputPath {
+				outputPath, info.outputPath = shrunk.path, shrunk.path
+			}
+		}
+	}
+	var metadata []byte
+	outputExt := strings.ToLower(filepath.Ext(outputPath))
+	if config.preserveMetadata && format == "jpeg" && (outputExt == ".jpg" || outputExt == ".jpeg") {
+		if metadata, err = jpegMetadata(job.inputPath, canvas, config); err != nil {
+			return info, err
+		}
+	}
+	if config.embedMarker && (outputExt == ".jpg" || outputExt == ".jpeg") {
+		metadata = processingExif(metadata, job.name(), config)
+	}
+	if config.embedSettings && (outputExt == ".jpg" || outputExt == ".jpeg") {
+		metadata = append(metadata, settingsComment(job, l, config)...)
+	}
+	profile, err := config.colorProfileFor(job.inputPath, outputExt, info.convertedFrom != "", isGray(canvas))
+	if err != nil {
+		return info, err
+	}
+	metadata = append(metadata, profile...)
+	info.outputBytes, info.outputSHA256, err = writeOutput(outputPath, config, l.isLandscape, metadata, encode)
+	if err != nil {
+		return info, err
+	}
+
+	if config.verifyOutputs {
+		verifyStart := time.Now()
+		err := verifyOutput(outputPath, config, l.destRect, info.background)
+		info.verifyDuration = time.Since(verifyStart)
+		if err != nil {
+			os.Remove(outputPath)
+			return info, fmt.Errorf("output verification failed: %v", err)
+		}
+	}
+
+	if config.measureQuality {
+		info.qualityPSNR, info.qualitySSIM, err = measureQuality(outputPath, img, l.destRect)
+		if err != nil {
+			return info, fmt.Errorf("error measuring output quality: %v", err)
+		}
+		info.measured = true
+	}
+
+	if job.wantThumbnail {
+		info.thumbnail, err = encodeThumbnail(canvas, reportThumbnailSize)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	if job.wantPreview {
+		info.preview = renderPreview(canvas)
+	}
+
+	return info, nil
+}
+
+// decodeInput decodes the image at path with whichever registered decoder
+// matches its content, and records its format, file size and dimensions in
+// info. SVGs, recognized by extension, are rasterized for the canvas of
+// config, and a page above 0 selects that page of a multi-page TIFF. CMYK
+// JPEGs, with or without an Adobe segment, are converted to RGB, and JPEGs
+// are turned upright according to their EXIF orientation, after being
+// reduced when they are drawn much smaller (see reduceFactor); info keeps
+// their full dimensions. With info nil, as for -tune, whose canvas changes,
+// nothing is reduced or recorded. The file is only held open while
+// decoding, inside an openFiles slot.
+func decodeInput(path string, page int, config *Config, info *imageInfo) (image.Image, error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	// A -dry-run only plans the layout, so there is nothing to spare, and
+	// the decode shared by several -size values must suit them all.
+	reduce := info != nil && !config.dryRun && len(config.sizes) < 2
+	if info == nil {
+		info = &imageInfo{}
+	}
+
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file: %v", err)
+	}
+	defer input.Close()
+	if fi, err := input.Stat(); err == nil {
+		info.inputBytes = fi.Size()
+	}
+	// record sets the dimensions of the decoded image, unless already set.
+	record := func(img image.Image) image.Image {
+		if info.sourceWidth == 0 {
+			info.sourceWidth, info.sourceHeight = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+		return img
+	}
+
+	if format, _ := inputFormatFor(filepath.Ext(path)); format == "svg" {
+		img, err := decodeSVG(path, input, config)
+		if err != nil {
+			return nil, fmt.Errorf("error rasterizing SVG: %v", err)
+		}
+		info.format = format
+		return record(img), nil
+	}
+
+	if page > 0 {
+		img, err := decodeTIFFPage(input, page)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding page %d: %v", page, err)
+		}
+		info.format = "tiff"
+		return record(img), nil
+	}
+
+	img, format, err := decodeSafely(input)
+	if isMissingAdobeError(err) {
+		if _, err = input.Seek(0, io.SeekStart); err == nil {
+			img, err = decodePlainCMYK(input)
+			format = "jpeg"
+		}
+	}
+	if errors.Is(err, image.ErrFormat) {
+		return nil, fmt.Errorf("error decoding image: content is not in any supported format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %v", err)
+	}
+	info.format = format
+	if cmyk, ok := img.(*image.CMYK); ok {
+		img = cmykToRGBA(cmyk)
+	}
+	// Phones store portraits sideways and record the turn in EXIF.
+	if format == "jpeg" {
+		if _, err := input.Seek(0, io.SeekStart); err == nil {
+			orientation := readJPEGOrientation(input)
+			b := img.Bounds()
+			width, height := b.Dx(), b.Dy()
+			if swapsAxes(orientation) {
+				width, height = height, width
+			}
+			info.sourceWidth, info.sourceHeight = width, height
+			if reduce {
+				if f := reduceFactor(width, height, config); f > 1 {
+					img = reduceImage(img, f)
+				}
+			}
+			img = orient(img, orientation)
+		}
+	}
+	return record(img), nil
+}
+
+// decodeSafely runs image.Decode, turning a panic in a decoder on a
+// malformed file into an error for that file.
+func decodeSafely(r io.Reader) (img image.Image, format string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			img, format, err = nil, "", fmt.Errorf("decoder failed on malformed data: %v", p)
+		}
+	}()
+	return image.Decode(r)
+}
+
+// renderCanvas lays img out on a canvas of the target size with the
+// border, keyline and resampling settings of config, and records the
+// decisions in info. canvas is what gets encoded; newImg is the same canvas
+// when it was drawn in RGBA, and nil when the YCbCr fast path, the 16-bit
+// path or the grayscale path for outputPath applied.
+func renderCanvas(img image.Image, outputPath string, config *Config, info *imageInfo) (canvas image.Image, newImg *image.RGBA) {
+	bounds := img.Bounds()
+	// decodeInput has already recorded the full size of a reduced JPEG.
+	if info.sourceWidth == 0 {
+		info.sourceWidth, info.sourceHeight = bounds.Dx(), bounds.Dy()
+	}
+	l := computeLayout(bounds.Dx(), bounds.Dy(), config)
+	info.layout = l
+	if info.sourceWidth != bounds.Dx() {
+		// Report the scale from the full source, not the reduced one.
+		info.layout.scale = l.scale * float64(bounds.Dx()) / float64(info.sourceWidth)
+	}
+
+	// Create the background image
+	info.background, info.luminance = config.resolveBackground(img, info.sourceWidth)
+	info.outputWidth, info.outputHeight = config.targetWidth, config.targetHeight
+	info.edgeBlend = -1
+
+	info.kernel = config.kernelFor(info.layout.scale)
+	if _, ok := img.(*image.Paletted); ok && config.resample == defaultConfig.resample && !config.splitResample() {
+		// Smoothing an indexed image such as pixel art or a chart blurs its
+		// edges and invents colors between its palette entries.
+		info.kernel = resampleNearest
+	}
+	if info.kernel == resampleApproxBiLinear && canUseYCbCr(img, outputPath, info.background, config) {
+		return renderYCbCr(img.(*image.YCbCr), config.targetWidth, config.targetHeight, l.destRect, info.background), nil
+	}
+
+	bc := config.borderConfig()
+	bc.Background, bc.Kernel = info.background, resamplers[info.kernel]
+	var drawn draw.Image
+	gray, deep := keepsGray(img, outputPath, info.background, config), keepsDepth(img, outputPath, config)
+	switch {
+	case gray && deep:
+		drawn, _ = border.RenderGray16(img, bc)
+	case gray:
+		drawn, _ = border.RenderGray(img, bc)
+	case deep:
+		drawn, _ = border.Render64(img, bc)
+	default:
+		newImg, _ = border.Render(img, bc)
+		drawn = newImg
+	}
+
+	if config.vignette > 0 {
+		drawVignette(drawn, l.destRect, config.cornerRadius, config.vignette)
+	}
+	if config.autoKeyline {
+		info.edgeBlend = edgeBlendFraction(drawn, l.destRect, info.background, config.keylineThreshold)
+		if info.edgeBlend >= config.keylineFraction {
+			drawKeyline(drawn, l.destRect, config.keylineWidth, config.keylineColor)
+			info.keyline = true
+		}
+	}
+	if config.watermark != nil {
+		drawWatermark(drawn, config)
+	}
+	return drawn, newImg
+}
+
+// writeOutput creates path and fills it using encode, adding the processing
+// marker and, with -fsync, flushing the file and its folder to disk. The
+// file is written under a temporary name in the same folder and renamed to
+// path once complete, so a failed or interrupted write never leaves a
+// truncated output behind. It returns the number of bytes written and
+// their SHA-256. metadata, if any, is copied right after the SOI marker of
+// a JPEG output or the IHDR chunk of a PNG one.
+func writeOutput(path string, config *Config, isLandscape bool, metadata []byte, encode func(io.Writer) error) (int64, string, error) {
+	if remoteOutput != nil {
+		var buf bytes.Buffer
+		n, sum, err := encodeOutput(&buf, path, config, isLandscape, metadata, encode)
+		if err != nil {
+			return 0, "", err
+		}
+		if err := remoteOutput.upload(path, buf.Bytes()); err != nil {
+			return 0, "", fmt.Errorf("error uploading output: %v", err)
+		}
+		return n, sum, nil
+	}
+
+	openFiles.acquire()
+	defer openFiles.release()
+
+	output, err := os.CreateTemp(filepath.Dir(path), ".wbi-output-*")
+	if err != nil {
+		return 0, "", fmt.Errorf("error creating output file: %v", err)
+	}
+	inFlightOutputs.Store(output.Name(), struct{}{})
+	defer inFlightOutputs.Delete(output.Name())
+	n, sum, err := encodeOutput(output, path, config, isLandscape, metadata, encode)
+	if err == nil {
+		// Temporary files are private; outputs get the usual permissions.
+		if err = output.Chmod(0644); err != nil {
+			err = fmt.Errorf("error writing output file: %v", err)
+		}
+	}
+	if err == nil && config.fsync {
+		if err = output.Sync(); err != nil {
+			err = fmt.Errorf("error syncing output file: %v", err)
+		}
+	}
+	if cerr := output.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("error writing output file: %v", cerr)
+	}
+	if err == nil {
+		if err = os.Rename(output.Name(), path); err != nil {
+			err = fmt.Errorf("error moving output file into place: %v", err)
+		}
+	}
+	if err != nil {
+		os.Remove(output.Name())
+		return 0, "", err
+	}
+	if config.fsync {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			return 0, "", fmt.Errorf("error syncing output folder: %v", err)
+		}
+	}
+	return n, sum, nil
+}
+
+// encodeOutput runs encode into dst, adding metadata and the processing
+// marker to the formats that have a slot for them, and returns the number
+// of bytes written and their SHA-256. With -strip-metadata nothing is
+// added: every output passes through here, so this is where the guarantee
+// is kept.
+func encodeOutput(dst io.Writer, path string, config *Config, isLandscape bool, metadata []byte, encode func(io.Writer) error) (int64, string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	isPNG := ext == ".png"
+	isJPEG := ext == ".jpg" || ext == ".jpeg"
+	hash := sha256.New()
+	w := &countingWriter{w: io.MultiWriter(dst, hash)}
+	var encodeTo io.Writer = w
+	var insert []byte
+	if (isJPEG || isPNG) && !config.stripMetadata {
+		insert = append(insert, metadata...)
+	}
+	if config.embedMarker && !config.stripMetadata && (isPNG || isJPEG) {
+		segment, err := markerSegment(newProcessingMarker(config, isLandscape), isPNG)
+		if err != nil {
+			return 0, "", fmt.Errorf("error building processing marker: %v", err)
+		}
+		insert = append(insert, segment...)
+	}
+	if len(insert) > 0 {
+		encodeTo = newMarkerWriter(w, insert, isPNG)
+	}
+	if err := encode(encodeTo); err != nil {
+		return 0, "", fmt.Errorf("error encoding output image: %v", err)
+	}
+	return w.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
 func drawImage(dst *image.RGBA, src *image.RGBA, offset image.Point) {
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig returns the default settings with a small canvas, so tests
+// render quickly.
+func testConfig() *Config {
+	c := defaultConfig
+	c.targetWidth, c.targetHeight = 120, 120
+	c.maxWorkers = 2
+	return &c
+}
+
+// fill returns a width x height image of c.
+func fill(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// writeImage encodes img to path as a JPEG or PNG, by its extension.
+func writeImage(t testing.TB, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readImage decodes the image at path.
+func readImage(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", path, err)
+	}
+	return img
+}
+
+// near reports whether a and b differ by at most tolerance in each 8-bit
+// channel.
+func near(a, b color.Color, tolerance int) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	for _, d := range []int{int(ar>>8) - int(br>>8), int(ag>>8) - int(bg>>8), int(ab>>8) - int(bb>>8), int(aa>>8) - int(ba>>8)} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// captureStdout returns what fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printed := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		printed <- string(data)
+	}()
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	return <-printed
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"quality 1", func(c *Config) { c.jpegQuality = 1 }, ""},
+		{"quality 100", func(c *Config) { c.jpegQuality = 100 }, ""},
+		{"quality 0", func(c *Config) { c.jpegQuality = 0 }, "-jpeg-quality"},
+		{"quality 101", func(c *Config) { c.jpegQuality = 101 }, "-jpeg-quality"},
+		{"width 0", func(c *Config) { c.targetWidth = 0 }, "-width and -height"},
+		{"height -10", func(c *Config) { c.targetHeight = -10 }, "-width and -height"},
+		{"batch size 0", func(c *Config) { c.batchSize = 0 }, "-batch-size"},
+		{"workers 0", func(c *Config) { c.maxWorkers = 0 }, "-workers"},
+		{"ratio 0", func(c *Config) { c.landscapeVertBorder = 0 }, ""},
+		{"ratio just below 0.5", func(c *Config) { c.portraitHorizBorder = 0.4999 }, ""},
+		{"ratio 0.5", func(c *Config) { c.landscapeHorizBorder = 0.5 }, "-landscape-horiz"},
+		{"negative ratio", func(c *Config) { c.portraitVertBorder = -0.01 }, "-portrait-vert"},
+		{"min px leaving room", func(c *Config) { c.targetHeight = 1080; c.borderMinPx = 539 }, ""},
+		{"min px filling the canvas", func(c *Config) { c.targetHeight = 1080; c.borderMinPx = 600 }, "no room"},
+		{"per-side borders filling the canvas", func(c *Config) { c.topBorder, c.bottomBorder = 0.6, 0.4 }, "no room"},
+		{"second size too small for min px", func(c *Config) {
+			c.sizes = [][2]int{{1080, 1080}, {200, 200}}
+			c.borderMinPx = 100
+		}, "200x200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig
+			tt.modify(&c)
+			err := c.validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("validate() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("validate() = nil, want an error mentioning %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("validate() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRenderBorderClamp(t *testing.T) {
+	c := defaultConfig
+	c.targetWidth, c.targetHeight = 1080, 1080
+	c.borderMinPx = 600
+	if err := c.validateRender(); err == nil {
+		t.Fatal("validateRender() accepted a -border-min-px that leaves no room for the image")
+	}
+}
+
+func TestRunFolderSkipsNonImages(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("img%d.jpg", i)
+		writeImage(t, filepath.Join(dir, name), fill(30, 20, color.RGBA{uint8(50 * i), 0, 0, 255}))
+		names = append(names, name)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("# Shoot notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "raw"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two images per batch leaves a partial last batch after the README.
+	config := testConfig()
+	config.batchSize = 2
+	var processed []string
+	err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+		if r.error != nil {
+			t.Errorf("%s: %v", r.filename, r.error)
+		}
+		processed = append(processed, r.filename)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(processed)
+	if !slices.Equal(processed, names) {
+		t.Errorf("processed %v, want %v", processed, names)
+	}
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, "bordered_images", "bordered_"+name)); err != nil {
+			t.Errorf("output of %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bordered_images", "bordered_README.md")); err == nil {
+		t.Error("README.md was bordered")
+	}
+}
+
+func TestWorkersBoundedByImages(t *testing.T) {
+	dir := t.TempDir()
+	const images = 3
+	for i := 0; i < images; i++ {
+		writeImage(t, filepath.Join(dir, fmt.Sprintf("img%d.jpg", i)), noise(400, 300))
+	}
+	config := testConfig()
+	config.maxWorkers = 64
+
+	// Sample the goroutine count for the whole run.
+	baseline := runtime.NumGoroutine()
+	done := make(chan struct{})
+	peak := make(chan int)
+	go func() {
+		most := 0
+		for {
+			select {
+			case <-done:
+				peak <- most
+				return
+			default:
+				most = max(most, runtime.NumGoroutine())
+				runtime.Gosched()
+			}
+		}
+	}()
+	err := runFolder(context.Background(), config, dir, time.Now(), nil)
+	close(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Besides the workers, a run has a few goroutines of its own: the
+	// sampler, the job feeder and the one closing the results.
+	if extra := <-peak - baseline; extra > images+6 {
+		t.Errorf("run peaked at %d goroutines over the baseline for %d images with -workers %d", extra, images, config.maxWorkers)
+	}
+}
+
+func TestRenderAsymmetricBorders(t *testing.T) {
+	// A caption strip: 5% on top, 25% at the bottom of a 400x400 canvas.
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 400, 400
+	config.topBorder, config.bottomBorder = 0.05, 0.25
+	config.landscapeHorizBorder, config.portraitHorizBorder = 0.1, 0.1
+	red := color.RGBA{200, 0, 0, 255}
+
+	var info imageInfo
+	canvas, _ := renderCanvas(fill(100, 100, red), "out.png", config, &info)
+	if want := image.Rect(60, 20, 340, 300); info.layout.destRect != want {
+		t.Fatalf("photo at %v, want %v", info.layout.destRect, want)
+	}
+	for _, p := range []struct {
+		x, y int
+		want color.Color
+	}{
+		{200, 19, config.backgroundColor}, {200, 20, red},
+		{200, 299, red}, {200, 300, config.backgroundColor},
+		{59, 150, config.backgroundColor}, {60, 150, red},
+	} {
+		if got := canvas.At(p.x, p.y); !near(got, p.want, 0) {
+			t.Errorf("pixel (%d,%d) = %v, want %v", p.x, p.y, got, p.want)
+		}
+	}
+}
+
+// parseArgs runs parseFlags on the command line args, keeping what it
+// prints out of the test output.
+func parseArgs(t *testing.T, args ...string) (config *Config, inputFolder string) {
+	t.Helper()
+	saved := os.Args
+	defer func() { os.Args = saved }()
+	os.Args = append([]string{"white_border_adder"}, args...)
+	captureStdout(t, func() { config, inputFolder, _ = parseFlags() })
+	return config, inputFolder
+}
+
+func TestFolderArgumentMatchesInput(t *testing.T) {
+	dir := t.TempDir()
+	positional, folder := parseArgs(t, dir)
+	flagged, _ := parseArgs(t, "-input", dir)
+	if folder != dir {
+		t.Fatalf("input folder %q, want %q", folder, dir)
+	}
+	if positional.colorProfile != colorProfileNone {
+		t.Errorf("colorProfile %q, want %q", positional.colorProfile, colorProfileNone)
+	}
+	if !reflect.DeepEqual(positional, flagged) {
+		t.Errorf("a lone folder argument gives\n%+v\nwant the -input config\n%+v", *positional, *flagged)
+	}
+}
+
+func TestSkipExistingAndForce(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		writeImage(t, filepath.Join(dir, name), fill(40, 30, color.RGBA{0, 90, 0, 255}))
+	}
+	output := filepath.Join(dir, "bordered_images", "bordered_a.jpg")
+	sentinel := []byte("kept from an earlier run")
+
+	// run processes the folder after replacing the output of a.jpg with
+	// the sentinel, and returns the results, what was printed and whether
+	// the sentinel survived.
+	run := func(t *testing.T, config *Config) (results []processingResult, printed string, kept bool) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(output, sentinel, 0644); err != nil {
+			t.Fatal(err)
+		}
+		printed = captureStdout(t, func() {
+			err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+				results = append(results, r)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results, printed, string(data) == string(sentinel)
+	}
+
+	t.Run("skip existing", func(t *testing.T) {
+		config := testConfig()
+		config.skipExisting = true
+		results, printed, kept := run(t, config)
+		if !kept {
+			t.Error("existing output was overwritten")
+		}
+		for _, r := range results {
+			if skipped := r.filename == "a.jpg"; r.skipped != skipped {
+				t.Errorf("%s skipped = %v, want %v", r.filename, r.skipped, skipped)
+			}
+		}
+		if !strings.Contains(printed, "Skipped images (output exists): 1") {
+			t.Errorf("summary does not count the skipped image:\n%s", printed)
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		config := testConfig()
+		config.force = true
+		results, printed, kept := run(t, config)
+		if kept {
+			t.Error("existing output was kept")
+		}
+		for _, r := range results {
+			if r.skipped || r.error != nil {
+				t.Errorf("%s skipped %v, error %v", r.filename, r.skipped, r.error)
+			}
+		}
+		if strings.Contains(printed, "will be overwritten") {
+			t.Errorf("-force still warned:\n%s", printed)
+		}
+		readImage(t, output)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		_, printed, kept := run(t, testConfig())
+		if kept {
+			t.Error("existing output was kept")
+		}
+		// b.jpg's output exists from the force run too.
+		if !strings.Contains(printed, "2 output(s) already exist and will be overwritten") {
+			t.Errorf("no overwrite warning:\n%s", printed)
+		}
+	})
+}
+
+// processBatchesPerBatch is the scheme processBatches replaced, kept for
+// BenchmarkWorkUnit: each worker takes a whole batch and processes its
+// images one after another.
+func processBatchesPerBatch(batches [][]imageJob, config *Config) {
+	stats := newProcessingStats(0)
+	queue := make(chan []imageJob)
+	var wg sync.WaitGroup
+	for i := 0; i < config.maxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				for _, job := range batch {
+					processJob(context.Background(), job, config, stats)
+				}
+			}
+		}()
+	}
+	for _, batch := range batches {
+		queue <- batch
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// BenchmarkWorkUnit compares handing workers single images with handing
+// them whole batches, on a folder whose large images all fall in the
+// first batch.
+func BenchmarkWorkUnit(b *testing.B) {
+	dir := b.TempDir()
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 600, 600
+	config.maxWorkers, config.batchSize, config.force = 4, 4, true
+	var batches [][]imageJob
+	for i := 0; i < 16; i++ {
+		width, height := 120, 90
+		if i < config.batchSize {
+			width, height = 2400, 1800
+		}
+		name := fmt.Sprintf("img%02d.jpg", i)
+		writeImage(b, filepath.Join(dir, name), noise(width, height))
+		if i%config.batchSize == 0 {
+			batches = append(batches, nil)
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], imageJob{
+			index:      i,
+			inputPath:  filepath.Join(dir, name),
+			outputPath: filepath.Join(dir, "bordered_"+name),
+		})
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	os.Stdout = devNull
+
+	b.Run("image", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processBatches(context.Background(), batches, config, runSinks{}, nil)
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			processBatchesPerBatch(batches, config)
+		}
+	})
+}
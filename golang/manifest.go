@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// manifestRecord is one line of the -manifest JSON Lines file.
+type manifestRecord struct {
+	Input         string   `json:"input"`
+	Output        string   `json:"output"`
+	Status        string   `json:"status"`
+	Error         string   `json:"error,omitempty"`
+	DuplicateOf   string   `json:"duplicate_of,omitempty"`
+	DurationMs    float64  `json:"duration_ms,omitempty"`
+	SourceWidth   int      `json:"source_width,omitempty"`
+	SourceHeight  int      `json:"source_height,omitempty"`
+	OutputWidth   int      `json:"output_width,omitempty"`
+	OutputHeight  int      `json:"output_height,omitempty"`
+	InputBytes    int64    `json:"input_bytes,omitempty"`
+	Format        string   `json:"format,omitempty"`
+	OutputBytes   int64    `json:"output_bytes,omitempty"`
+	OutputSHA256  string   `json:"output_sha256,omitempty"`
+	SizeRatio     float64  `json:"size_ratio,omitempty"`
+	Oversized     bool     `json:"oversized,omitempty"`
+	AutoShrink    string   `json:"auto_shrink,omitempty"`
+	JPEGQuality   int      `json:"jpeg_quality,omitempty"`
+	SSIM          float64  `json:"ssim,omitempty"`
+	QualityPSNR   float64  `json:"quality_psnr,omitempty"`
+	QualitySSIM   float64  `json:"quality_ssim,omitempty"`
+	Background    string   `json:"background,omitempty"`
+	Luminance     *float64 `json:"luminance,omitempty"`
+	PaletteColors int      `json:"palette_colors,omitempty"`
+	EdgeBlend     *float64 `json:"edge_blend,omitempty"`
+	Keyline       bool     `json:"keyline,omitempty"`
+}
+
+func newManifestRecord(r processingResult) manifestRecord {
+	rec := manifestRecord{
+		Input:        r.inputPath,
+		Output:       r.outputPath,
+		Status:       "ok",
+		DurationMs:   float64(r.duration.Microseconds()) / 1000,
+		SourceWidth:  r.info.sourceWidth,
+		SourceHeight: r.info.sourceHeight,
+		InputBytes:   r.info.inputBytes,
+		Format:       r.info.format,
+	}
+	if r.duplicateOf != "" {
+		rec.Status, rec.DuplicateOf = "duplicate", r.duplicateOf
+		return rec
+	}
+	if r.skipped {
+		rec.Status = "skipped"
+		return rec
+	}
+	if r.error != nil {
+		rec.Status = "failed"
+		rec.Error = r.error.Error()
+		return rec
+	}
+	rec.OutputWidth = r.info.outputWidth
+	rec.OutputHeight = r.info.outputHeight
+	rec.OutputBytes = r.info.outputBytes
+	rec.OutputSHA256 = r.info.outputSHA256
+	if r.info.inputBytes > 0 {
+		rec.SizeRatio = float64(r.info.outputBytes) / float64(r.info.inputBytes)
+	}
+	rec.Oversized = r.info.oversized
+	rec.AutoShrink = r.info.autoShrink
+	rec.JPEGQuality = r.info.jpegQuality
+	rec.SSIM = r.info.ssim
+	rec.QualityPSNR = r.info.qualityPSNR
+	rec.QualitySSIM = r.info.qualitySSIM
+	rec.PaletteColors = r.info.paletteColors
+	if r.info.edgeBlend >= 0 {
+		blend := r.info.edgeBlend
+		rec.EdgeBlend = &blend
+	}
+	rec.Keyline = r.info.keyline
+	rec.Background = formatColor(r.info.background)
+	if r.info.luminance >= 0 {
+		lum := r.info.luminance
+		rec.Luminance = &lum
+	}
+	return rec
+}
+
+// manifestWriter appends one JSON record per image as results arrive, so
+// the manifest of an interrupted run still lists everything finished so far.
+// An ordered writer instead holds the records back and writes them in input
+// order on close, so the file does not depend on which worker finished first.
+type manifestWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	ordered bool
+	pending []processingResult
+}
+
+func newManifestWriter(path string, ordered bool) (*manifestWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &manifestWriter{f: f, w: w, enc: json.NewEncoder(w), ordered: ordered}, nil
+}
+
+func (m *manifestWriter) add(results []processingResult) error {
+	if m.ordered {
+		m.pending = append(m.pending, results...)
+		return nil
+	}
+	return m.write(results)
+}
+
+func (m *manifestWriter) write(results []processingResult) error {
+	for _, r := range results {
+		if err := m.enc.Encode(newManifestRecord(r)); err != nil {
+			return err
+		}
+	}
+	return m.w.Flush()
+}
+
+// sortResults puts results in input order: by index, then page, then -size
+// value, so an ordered writer's output does not depend on which of an
+// input's jobs finished first.
+func sortResults(results []processingResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		if a.page != b.page {
+			return a.page < b.page
+		}
+		return a.sizeIndex < b.sizeIndex
+	})
+}
+
+func (m *manifestWriter) close() error {
+	if m.ordered {
+		sortResults(m.pending)
+		if err := m.write(m.pending); err != nil {
+			m.f.Close()
+			return err
+		}
+	}
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
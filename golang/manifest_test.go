@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hashTree returns the SHA-256 of every file under dir, by path relative
+// to it.
+func hashTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	hashes := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		sum := sha256.Sum256(data)
+		hashes[rel] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func TestReproducibleRunsMatch(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		c := color.RGBA{uint8(40 * i), 100, 200 - uint8(30*i), 255}
+		writeImage(t, filepath.Join(input, fmt.Sprintf("img%d.jpg", i)), fill(50+10*i, 80-5*i, c))
+	}
+	writeImage(t, filepath.Join(input, "alpha.png"), fill(30, 30, color.RGBA{0, 80, 0, 128}))
+
+	config := testConfig()
+	config.reproducible, config.force = true, true
+	config.maxWorkers = 4
+	config.sizes = [][2]int{{120, 120}, {90, 150}}
+	config.manifestPath = filepath.Join(dir, "artifacts", "manifest.jsonl")
+	config.reportPath = filepath.Join(dir, "artifacts", "report.csv")
+	config.htmlReport, config.sidecarsJSON = true, true
+	if err := os.Mkdir(filepath.Join(dir, "artifacts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var runs [2]map[string]string
+	for i := range runs {
+		if err := runFolder(context.Background(), config, input, time.Now(), nil); err != nil {
+			t.Fatal(err)
+		}
+		runs[i] = hashTree(t, dir)
+	}
+	if len(runs[0]) < 2*7+3 {
+		t.Fatalf("got %d artifacts, want an output per image and size plus the reports", len(runs[0]))
+	}
+	for path, sum := range runs[0] {
+		if runs[1][path] != sum {
+			t.Errorf("%s differs between runs", path)
+		}
+	}
+}
+
+func TestScheduledRunReportsInInputOrder(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Later names are larger files, so ljf processes them first.
+	var names []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("img%d.jpg", i)
+		writeImage(t, filepath.Join(input, name), noise(40+40*i, 40+40*i))
+		names = append(names, name)
+	}
+
+	config := testConfig()
+	config.schedule, config.maxWorkers, config.batchSize = scheduleLJF, 1, 1
+	config.manifestPath = filepath.Join(dir, "manifest.jsonl")
+	config.reportPath = filepath.Join(dir, "report.csv")
+	var completed []string
+	err := runFolder(context.Background(), config, input, time.Now(), func(r processingResult, total int) {
+		completed = append(completed, r.filename)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed[0] != names[len(names)-1] {
+		t.Fatalf("ljf processed %v, want the largest file first", completed)
+	}
+
+	data, err := os.ReadFile(config.manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record manifestRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		manifest = append(manifest, filepath.Base(record.Input))
+	}
+	if !slices.Equal(manifest, names) {
+		t.Errorf("manifest lists %v, want input order %v", manifest, names)
+	}
+
+	f, err := os.Open(config.reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []string
+	for _, row := range rows[1:] {
+		report = append(report, row[0])
+	}
+	if !slices.Equal(report, names) {
+		t.Errorf("report lists %v, want input order %v", report, names)
+	}
+}
+
+// noise returns a width x height image of opaque pseudo-random pixels,
+// which JPEG cannot compress much.
+func noise(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(width*7919 + height)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
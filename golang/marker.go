@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// markerKeyword identifies the processing marker embedded in outputs: the
+// PNG tEXt keyword, and the prefix of the JPEG COM segment.
+const markerKeyword = "white_border_adder"
+
+// renderSettings are the Config values that influence the rendered pixels.
+type renderSettings struct {
+	Width                int      `json:"width"`
+	Height               int      `json:"height"`
+	LandscapeVertBorder  float64  `json:"landscape_vert"`
+	LandscapeHorizBorder float64  `json:"landscape_horiz"`
+	PortraitVertBorder   float64  `json:"portrait_vert"`
+	PortraitHorizBorder  float64  `json:"portrait_horiz"`
+	TopBorder            *float64 `json:"border_top,omitempty"`
+	BottomBorder         *float64 `json:"border_bottom,omitempty"`
+	LeftBorder           *float64 `json:"border_left,omitempty"`
+	RightBorder          *float64 `json:"border_right,omitempty"`
+	BorderMinPx          int      `json:"border_min_px"`
+	BorderMaxPx          int      `json:"border_max_px"`
+	JPEGQuality          int      `json:"jpeg_quality"`
+	JPEGSubsampling      string   `json:"jpeg_subsampling,omitempty"`
+	EncoderCmd           string   `json:"encoder_cmd,omitempty"`
+	TargetSSIM           float64  `json:"target_ssim,omitempty"`
+	Background           string   `json:"background,omitempty"`
+	BorderMode           string   `json:"border_mode,omitempty"`
+	EdgeWidth            int      `json:"edge_width,omitempty"`
+	ConvertSRGB          bool     `json:"convert_srgb,omitempty"`
+	ContrastThreshold    float64  `json:"contrast_threshold,omitempty"`
+	LightColor           string   `json:"light_color,omitempty"`
+	DarkColor            string   `json:"dark_color,omitempty"`
+	PNGPalette           bool     `json:"png_palette,omitempty"`
+	PaletteOutput        bool     `json:"palette_output,omitempty"`
+	Dither               bool     `json:"dither,omitempty"`
+	Force8Bit            bool     `json:"force_8bit,omitempty"`
+	GrayAsColor          bool     `json:"gray_as_color,omitempty"`
+	Gravity              string   `json:"gravity,omitempty"`
+	OffsetX              int      `json:"offset_x,omitempty"`
+	OffsetY              int      `json:"offset_y,omitempty"`
+	CornerRadius         int      `json:"corner_radius,omitempty"`
+	AutoKeyline          bool     `json:"auto_keyline,omitempty"`
+	KeylineThreshold     float64  `json:"keyline_threshold,omitempty"`
+	KeylineFraction      float64  `json:"keyline_fraction,omitempty"`
+	KeylineWidth         int      `json:"keyline_width,omitempty"`
+	KeylineColor         string   `json:"keyline_color,omitempty"`
+	Watermark            string   `json:"watermark,omitempty"`
+	WatermarkPosition    string   `json:"watermark_position,omitempty"`
+	WatermarkOpacity     float64  `json:"watermark_opacity,omitempty"`
+	Vignette             float64  `json:"vignette,omitempty"`
+	OutputEncoding       string   `json:"output_encoding,omitempty"`
+	JXLDistance          float64  `json:"jxl_distance,omitempty"`
+	JXLEffort            int      `json:"jxl_effort,omitempty"`
+	WebPQuality          int      `json:"webp_quality,omitempty"`
+	AVIFQuality          int      `json:"avif_quality,omitempty"`
+	AVIFSpeed            int      `json:"avif_speed,omitempty"`
+	Resample             string   `json:"resample,omitempty"`
+	ResampleUp           string   `json:"resample_up,omitempty"`
+	ResampleDown         string   `json:"resample_down,omitempty"`
+}
+
+func (c *Config) renderSettings() renderSettings {
+	s := renderSettings{
+		Width:                c.targetWidth,
+		Height:               c.targetHeight,
+		LandscapeVertBorder:  c.landscapeVertBorder,
+		LandscapeHorizBorder: c.landscapeHorizBorder,
+		PortraitVertBorder:   c.portraitVertBorder,
+		PortraitHorizBorder:  c.portraitHorizBorder,
+		BorderMinPx:          c.borderMinPx,
+		BorderMaxPx:          c.borderMaxPx,
+		JPEGQuality:          c.jpegQuality,
+		TargetSSIM:           c.targetSSIM,
+		PNGPalette:           c.pngPalette,
+		PaletteOutput:        c.paletteOutput,
+		Dither:               c.dither && (c.pngPalette || c.paletteOutput),
+		Force8Bit:            c.force8Bit,
+		GrayAsColor:          !c.preserveGray,
+		OffsetX:              c.offsetX,
+		OffsetY:              c.offsetY,
+		CornerRadius:         c.cornerRadius,
+		ConvertSRGB:          c.convertSRGB,
+	}
+	if c.gravity != defaultConfig.gravity {
+		s.Gravity = c.gravity
+	}
+	if c.jpegSubsampling != defaultConfig.jpegSubsampling {
+		s.JPEGSubsampling = c.jpegSubsampling
+	}
+	s.EncoderCmd = c.encoderCmd
+	bc := c.borderConfig()
+	s.TopBorder, s.BottomBorder, s.LeftBorder, s.RightBorder = bc.Top, bc.Bottom, bc.Left, bc.Right
+	if c.outputEncoding == encodingJXL {
+		s.OutputEncoding = encodingJXL
+		s.JXLDistance = c.jxlDistance
+		s.JXLEffort = c.jxlEffort
+	}
+	if c.outputEncoding == encodingWebP {
+		s.OutputEncoding = encodingWebP
+		s.WebPQuality = c.webpQuality
+	}
+	if c.outputEncoding == encodingGIF || c.outputEncoding == encodingTIFF {
+		s.OutputEncoding = c.outputEncoding
+	}
+	if c.outputEncoding == encodingAVIF {
+		s.OutputEncoding = encodingAVIF
+		s.AVIFQuality = c.avifQuality
+		s.AVIFSpeed = c.avifSpeed
+	}
+	// Record the kernels only when they differ from the original
+	// approx-bilinear, so fingerprints of earlier outputs stay valid.
+	if up := c.kernelFor(2); up != resampleApproxBiLinear {
+		s.ResampleUp = up
+	}
+	if down := c.kernelFor(0.5); down != resampleApproxBiLinear {
+		s.ResampleDown = down
+	}
+	if c.resample != resampleApproxBiLinear {
+		s.Resample = c.resample
+	}
+	if c.autoKeyline {
+		s.AutoKeyline = true
+		s.KeylineThreshold = c.keylineThreshold
+		s.KeylineFraction = c.keylineFraction
+		s.KeylineWidth = c.keylineWidth
+		s.KeylineColor = formatColor(c.keylineColor)
+	}
+	if c.borderMode != borderModeSolid {
+		s.BorderMode = c.borderMode
+	}
+	if c.borderMode == borderModeEdge {
+		s.EdgeWidth = c.edgeWidth
+	}
+	if c.watermarkPath != "" {
+		s.Watermark = filepath.Base(c.watermarkPath)
+		s.WatermarkPosition = c.watermarkPosition
+		s.WatermarkOpacity = c.watermarkOpacity
+	}
+	s.Vignette = c.vignette
+	// Leave the default white border out so fingerprints of earlier outputs
+	// stay valid.
+	switch c.background {
+	case defaultConfig.background:
+	case backgroundAutoContrast:
+		s.Background = backgroundAutoContrast
+		s.ContrastThreshold = c.contrastThreshold
+		s.LightColor = formatColor(c.lightColor)
+		s.DarkColor = formatColor(c.darkColor)
+	case backgroundTransparent:
+		s.Background = backgroundTransparent
+	default:
+		s.Background = formatColor(c.backgroundColor)
+	}
+	return s
+}
+
+// fingerprint returns a short stable hash of the settings.
+func (s renderSettings) fingerprint() string {
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// processingMarker is embedded in every output so later runs can tell which
+// settings produced it.
+type processingMarker struct {
+	Tool        string         `json:"tool"`
+	Fingerprint string         `json:"fingerprint"`
+	Landscape   bool           `json:"landscape"`
+	Settings    renderSettings `json:"settings"`
+}
+
+func newProcessingMarker(config *Config, isLandscape bool) processingMarker {
+	settings := config.renderSettings()
+	return processingMarker{
+		Tool:        markerKeyword,
+		Fingerprint: settings.fingerprint(),
+		Landscape:   isLandscape,
+		Settings:    settings,
+	}
+}
+
+// markerWriter injects segments into an encoder's output stream right after
+// the first `at` bytes (the JPEG SOI marker or the PNG IHDR chunk).
+type markerWriter struct {
+	w       io.Writer
+	at      int
+	written int
+	segment []byte
+}
+
+func newMarkerWriter(w io.Writer, segment []byte, isPNG bool) *markerWriter {
+	if isPNG {
+		// 8-byte signature followed by the 25-byte IHDR chunk.
+		return &markerWriter{w: w, at: 33, segment: segment}
+	}
+	return &markerWriter{w: w, at: 2, segment: segment}
+}
+
+// markerSegment builds the PNG tEXt chunk or JPEG COM segment holding the
+// processing marker.
+func markerSegment(marker processingMarker, isPNG bool) ([]byte, error) {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return nil, err
+	}
+	if isPNG {
+		return pngTextChunk(markerKeyword, data), nil
+	}
+	return jpegSegment(0xfe, append([]byte(markerKeyword+":"), data...)), nil
+}
+
+func (mw *markerWriter) Write(p []byte) (int, error) {
+	if mw.segment == nil || mw.written+len(p) < mw.at {
+		n, err := mw.w.Write(p)
+		mw.written += n
+		return n, err
+	}
+
+	head := mw.at - mw.written
+	n, err := mw.w.Write(p[:head])
+	mw.written += n
+	if err != nil {
+		return n, err
+	}
+	if _, err := mw.w.Write(mw.segment); err != nil {
+		return n, err
+	}
+	mw.segment = nil
+	m, err := mw.w.Write(p[head:])
+	mw.written += m
+	return n + m, err
+}
+
+// jpegSegment builds a JPEG marker segment with the given marker byte.
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// pngTextChunk builds a PNG tEXt chunk.
+func pngTextChunk(keyword string, text []byte) []byte {
+	data := append(append([]byte("tEXt"+keyword), 0), text...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)-4))
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(data))
+}
+
+var errNoMarker = errors.New("no processing marker")
+
+// readProcessingMarker extracts the processing marker from a JPEG or PNG
+// file, returning errNoMarker if it has none.
+func readProcessingMarker(path string) (processingMarker, error) {
+	var marker processingMarker
+
+	f, err := os.Open(path)
+	if err != nil {
+		return marker, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	var payload []byte
+	head, err := r.Peek(8)
+	if err != nil {
+		return marker, errNoMarker
+	}
+	switch {
+	case bytes.Equal(head, []byte("\x89PNG\r\n\x1a\n")):
+		payload, err = findPNGText(r, markerKeyword)
+	case head[0] == 0xff && head[1] == 0xd8:
+		payload, err = findJPEGComment(r, markerKeyword+":")
+	default:
+		return marker, errNoMarker
+	}
+	if err != nil {
+		return marker, err
+	}
+	if err := json.Unmarshal(payload, &marker); err != nil || marker.Tool != markerKeyword {
+		return marker, errNoMarker
+	}
+	return marker, nil
+}
+
+// findJPEGComment scans the segments before the image data for a COM
+// segment starting with prefix and returns the rest of its payload.
+func findJPEGComment(r io.Reader, prefix string) ([]byte, error) {
+	if _, err := io.ReadFull(r, make([]byte, 2)); err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return nil, errNoMarker
+		}
+		if hdr[0] != 0xff || hdr[1] == 0xda || hdr[1] == 0xd9 {
+			return nil, errNoMarker
+		}
+		// The length counts its own two bytes; anything less is corrupt.
+		length := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if length < 0 {
+			return nil, errNoMarker
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, errNoMarker
+		}
+		if hdr[1] == 0xfe && strings.HasPrefix(string(payload), prefix) {
+			return payload[len(prefix):], nil
+		}
+	}
+}
+
+// findPNGText scans the chunks before the image data for a tEXt chunk with
+// the given keyword and returns its text.
+func findPNGText(r io.Reader, keyword string) ([]byte, error) {
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil {
+		return nil, err
+	}
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return nil, errNoMarker
+		}
+		length := binary.BigEndian.Uint32(hdr)
+		typ := string(hdr[4:])
+		if typ == "IDAT" || typ == "IEND" || length > 1<<24 {
+			return nil, errNoMarker
+		}
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errNoMarker
+		}
+		if typ == "tEXt" && bytes.HasPrefix(data, append([]byte(keyword), 0)) {
+			return data[len(keyword)+1 : length], nil
+		}
+	}
+}
+
+// sameRatio reports whether two optional per-side ratios are equal.
+func sameRatio(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// settingsDiff describes how the current settings differ from the ones an
+// existing output was produced with.
+func settingsDiff(marker processingMarker, current renderSettings, isPNG bool) []string {
+	old := marker.Settings
+	var reasons []string
+	if old.Width != current.Width || old.Height != current.Height {
+		reasons = append(reasons, "size differs")
+	}
+	oldVert, oldHoriz := old.LandscapeVertBorder, old.LandscapeHorizBorder
+	newVert, newHoriz := current.LandscapeVertBorder, current.LandscapeHorizBorder
+	if !marker.Landscape {
+		oldVert, oldHoriz = old.PortraitVertBorder, old.PortraitHorizBorder
+		newVert, newHoriz = current.PortraitVertBorder, current.PortraitHorizBorder
+	}
+	if oldVert != newVert || oldHoriz != newHoriz || !sameRatio(old.TopBorder, current.TopBorder) ||
+		!sameRatio(old.BottomBorder, current.BottomBorder) || !sameRatio(old.LeftBorder, current.LeftBorder) ||
+		!sameRatio(old.RightBorder, current.RightBorder) {
+		reasons = append(reasons, "ratio differs")
+	}
+	if old.Gravity != current.Gravity || old.OffsetX != current.OffsetX || old.OffsetY != current.OffsetY {
+		reasons = append(reasons, "placement differs")
+	}
+	if old.CornerRadius != current.CornerRadius {
+		reasons = append(reasons, "corner radius differs")
+	}
+	if old.AutoKeyline != current.AutoKeyline || old.KeylineThreshold != current.KeylineThreshold ||
+		old.KeylineFraction != current.KeylineFraction || old.KeylineWidth != current.KeylineWidth ||
+		old.KeylineColor != current.KeylineColor {
+		reasons = append(reasons, "keyline differs")
+	}
+	if old.Watermark != current.Watermark || old.WatermarkPosition != current.WatermarkPosition || old.WatermarkOpacity != current.WatermarkOpacity {
+		reasons = append(reasons, "watermark differs")
+	}
+	if old.Vignette != current.Vignette {
+		reasons = append(reasons, "vignette differs")
+	}
+	if old.ConvertSRGB != current.ConvertSRGB {
+		reasons = append(reasons, "color conversion differs")
+	}
+	if old.Resample != current.Resample || old.ResampleUp != current.ResampleUp || old.ResampleDown != current.ResampleDown {
+		reasons = append(reasons, "resampling differs")
+	}
+	if old.BorderMinPx != current.BorderMinPx || old.BorderMaxPx != current.BorderMaxPx {
+		reasons = append(reasons, "border clamp differs")
+	}
+	if old.Background != current.Background || old.BorderMode != current.BorderMode || old.EdgeWidth != current.EdgeWidth || old.ContrastThreshold != current.ContrastThreshold ||
+		old.LightColor != current.LightColor || old.DarkColor != current.DarkColor {
+		reasons = append(reasons, "background differs")
+	}
+	if isPNG && (old.PNGPalette != current.PNGPalette || old.PaletteOutput != current.PaletteOutput || old.Dither != current.Dither) {
+		reasons = append(reasons, "palette differs")
+	}
+	if isPNG && old.Force8Bit != current.Force8Bit {
+		reasons = append(reasons, "bit depth differs")
+	}
+	if old.GrayAsColor != current.GrayAsColor {
+		reasons = append(reasons, "grayscale output differs")
+	}
+	if !isPNG && (old.JPEGQuality != current.JPEGQuality || old.TargetSSIM != current.TargetSSIM) {
+		reasons = append(reasons, "quality differs")
+	}
+	if !isPNG && old.JPEGSubsampling != current.JPEGSubsampling {
+		reasons = append(reasons, "chroma subsampling differs")
+	}
+	if !isPNG && old.EncoderCmd != current.EncoderCmd {
+		reasons = append(reasons, "encoder differs")
+	}
+	return reasons
+}
+
+// diffSettings compares the current settings against the markers of the
+// existing outputs of jobs, prints a per-file report and a summary, and
+// optionally writes the inputs that would change to listPath.
+func diffSettings(jobs []imageJob, config *Config, listPath string) error {
+	current := config.renderSettings()
+	var unchanged, changed, noRecord int
+	var changedInputs []string
+
+	fmt.Printf("\n🔍 === Settings diff (fingerprint %s) ===\n", current.fingerprint())
+	for _, job := range jobs {
+		name := job.outputPath
+		marker, err := readProcessingMarker(job.outputPath)
+		if err != nil {
+			noRecord++
+			fmt.Printf("❔ %s: no record\n", name)
+			continue
+		}
+		isPNG := strings.ToLower(filepath.Ext(name)) == ".png"
+		settings := current
+		if job.config != nil {
+			// Each -size output was rendered on its own canvas.
+			settings = job.config.renderSettings()
+		}
+		if reasons := settingsDiff(marker, settings, isPNG); len(reasons) > 0 {
+			changed++
+			changedInputs = append(changedInputs, job.inputPath)
+			fmt.Printf("🔁 %s: would change (%s)\n", name, strings.Join(reasons, ", "))
+		} else {
+			unchanged++
+			fmt.Printf("✅ %s: unchanged\n", name)
+		}
+	}
+
+	fmt.Printf("\nUnchanged: %d, would change: %d, no record: %d\n", unchanged, changed, noRecord)
+
+	if listPath != "" {
+		data := strings.Join(changedInputs, "\n")
+		if len(changedInputs) > 0 {
+			data += "\n"
+		}
+		if err := os.WriteFile(listPath, []byte(data), 0644); err != nil {
+			return fmt.Errorf("error writing diff list: %v", err)
+		}
+		fmt.Printf("Would-change list written to %s\n", listPath)
+	}
+	return nil
+}
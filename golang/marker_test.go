@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindJPEGCommentMalformedLength(t *testing.T) {
+	for _, length := range []byte{0, 1} {
+		data := []byte{0xff, 0xd8, 0xff, 0xfe, 0x00, length, 'x', 'y'}
+		if _, err := findJPEGComment(bytes.NewReader(data), markerKeyword+":"); !errors.Is(err, errNoMarker) {
+			t.Errorf("segment length %d: got %v, want errNoMarker", length, err)
+		}
+	}
+}
+
+func TestMarkerOffByDefault(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	writeImage(t, input, noise(60, 40))
+	for _, ext := range []string{".jpg", ".png"} {
+		job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out"+ext)}
+		if _, err := processImage(context.Background(), job, testConfig()); err != nil {
+			t.Fatal(err)
+		}
+		if found := metadataIn(t, job.outputPath); len(found) > 0 {
+			t.Errorf("%s output has %v without -marker", ext, found)
+		}
+	}
+}
+
+func TestDiffSettingsPlansLikeRun(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 6; i++ {
+		writeImage(t, filepath.Join(dir, fmt.Sprintf("img%d.jpg", i)), fill(60, 40, color.RGBA{uint8(40 * i), 90, 0, 255}))
+	}
+	args := []string{"-marker", "-shard", "0/2", "-size", "120x90", "-size", "60x80"}
+	config, _ := parseArgs(t, append(args, dir)...)
+	written := 0
+	captureStdout(t, func() {
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			if r.error == nil {
+				written++
+			}
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if written == 0 || written == 12 {
+		t.Fatalf("-shard 0/2 wrote %d outputs, want some of the 12", written)
+	}
+
+	// diff runs -diff-settings with extra flags and returns its summary.
+	diff := func(extra ...string) string {
+		t.Helper()
+		config, _ := parseArgs(t, append(append(args, extra...), "-diff-settings", dir)...)
+		jobs, err := plannedJobs(config, dir, outputFolderFor(config, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		printed := captureStdout(t, func() {
+			if err := diffSettings(jobs, config, ""); err != nil {
+				t.Error(err)
+			}
+		})
+		return strings.TrimSpace(printed[strings.LastIndex(printed, "\n\n"):])
+	}
+	if got, want := diff(), fmt.Sprintf("Unchanged: %d, would change: 0, no record: 0", written); got != want {
+		t.Errorf("same settings: %q, want %q", got, want)
+	}
+	if got, want := diff("-landscape-vert", "0.2"), fmt.Sprintf("Unchanged: 0, would change: %d, no record: 0", written); got != want {
+		t.Errorf("new ratio: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// byteSize is a flag holding a number of bytes, given as a plain number or
+// with a unit: 512MB, 2GB, 1.5GiB. Units are powers of 1024 either way.
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	shift  uint
+}{
+	{"tib", 40}, {"tb", 40}, {"t", 40},
+	{"gib", 30}, {"gb", 30}, {"g", 30},
+	{"mib", 20}, {"mb", 20}, {"m", 20},
+	{"kib", 10}, {"kb", 10}, {"k", 10},
+	{"b", 0},
+}
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return "0"
+	}
+	return formatBytes(int64(*b))
+}
+
+func (b *byteSize) Set(s string) error {
+	number, shift := strings.ToLower(strings.TrimSpace(s)), uint(0)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number, shift = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.shift
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("expected a size such as 512MB or 2GB")
+	}
+	*b = byteSize(n * float64(int64(1)<<shift))
+	return nil
+}
+
+// memoryBudget bounds the estimated memory of the images being processed
+// at once, across all workers, whatever their number. A worker reserves
+// the estimate of its image before decoding it and blocks until that much
+// of the budget is free. An image estimated above the whole budget still
+// runs, alone, rather than never.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	total int64
+	used  int64
+}
+
+// memory is shared by every worker; it is nil until main sets it for
+// -max-memory, which leaves processing unbounded.
+var memory *memoryBudget
+
+func newMemoryBudget(total int64) *memoryBudget {
+	m := &memoryBudget{total: total}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// acquire blocks until n bytes of the budget are free and reserves them.
+func (m *memoryBudget) acquire(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	for m.used > 0 && m.used+n > m.total {
+		m.cond.Wait()
+	}
+	m.used += n
+	m.mu.Unlock()
+}
+
+func (m *memoryBudget) release(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.used -= n
+	m.mu.Unlock()
+	m.cond.Broadcast()
+}
+
+// estimateMemory returns the peak memory processImage is expected to need
+// for the image at path: the RGBA canvas plus the decoded source, whose
+// size is read from its header. Sources without a readable header, such
+// as SVGs, are counted as large as the canvas.
+func estimateMemory(path string, config *Config) int64 {
+	canvas := int64(config.targetWidth) * int64(config.targetHeight) * 4
+	width, height, ok := sourceDimensions(path)
+	if !ok {
+		return 2 * canvas
+	}
+	return canvas + int64(width)*int64(height)*4
+}
+
+// sourceDimensions reads the pixel size of the image at path from its
+// header, without decoding it.
+func sourceDimensions(path string) (width, height int, ok bool) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	// Like decodeSafely: a decoder may panic on malformed headers.
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetNeverExceeded(t *testing.T) {
+	const total = 100
+	m := newMemoryBudget(total)
+	sizes := []int64{10, 30, 60, 100, 150}
+
+	var holders, peakHolders atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				n := sizes[(w+i)%len(sizes)]
+				m.acquire(n)
+				held := holders.Add(1)
+				if held > peakHolders.Load() {
+					peakHolders.Store(held)
+				}
+				m.mu.Lock()
+				used := m.used
+				m.mu.Unlock()
+				switch {
+				case n > total && used != n:
+					t.Errorf("an image of %d over the budget of %d runs with %d in use", n, total, used)
+				case used > total && n <= total:
+					t.Errorf("%d in use, over the budget of %d", used, total)
+				}
+				time.Sleep(50 * time.Microsecond)
+				holders.Add(-1)
+				m.release(n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.used != 0 {
+		t.Errorf("%d still in use after every release", m.used)
+	}
+	if peakHolders.Load() < 2 {
+		t.Error("no two images ever shared the budget")
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for in, want := range map[string]byteSize{
+		"1024":   1024,
+		"512MB":  512 << 20,
+		"2gb":    2 << 30,
+		"1.5GiB": 3 << 29,
+		"8 k":    8 << 10,
+	} {
+		var b byteSize
+		if err := b.Set(in); err != nil || b != want {
+			t.Errorf("Set(%q) = %d, %v; want %d", in, b, err, want)
+		}
+	}
+	var b byteSize
+	if err := b.Set("-1GB"); err == nil {
+		t.Error("Set(\"-1GB\") accepted a negative size")
+	}
+}
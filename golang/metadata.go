@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// iccAPP2Prefix starts each APP2 segment holding a chunk of an ICC profile.
+var iccAPP2Prefix = []byte("ICC_PROFILE\x00")
+
+// jpegMetadata returns the EXIF APP1 segment of the JPEG at path that
+// -preserve-metadata copies into its output, ready to follow the SOI
+// marker, with its thumbnail handled according to -exif-thumbnail and its
+// orientation reset since the pixels are already upright. The ICC profile
+// is copied by -color-profile keep, which -preserve-metadata implies.
+func jpegMetadata(path string, canvas image.Image, config *Config) ([]byte, error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var segment []byte
+	err = scanJPEGSegments(bufio.NewReader(f), func(marker byte) bool { return marker == 0xe1 }, func(_ byte, payload []byte) bool {
+		if !bytes.HasPrefix(payload, exifHeader) {
+			return true
+		}
+		exif, err := fixExifThumbnail(payload, canvas, config.exifThumbnail)
+		if err != nil {
+			fmt.Printf("⚠️  %s: EXIF not copied: %v\n", filepath.Base(path), err)
+			return false
+		}
+		resetExifOrientation(exif)
+		segment = jpegSegment(0xe1, exif)
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %v", err)
+	}
+	return segment, nil
+}
+
+// processingExif returns the EXIF APP1 segment of a JPEG output: metadata,
+// the segment -preserve-metadata copied or nil, with the Software tag naming
+// this tool and its version and the UserComment holding the settings as
+// JSON, so other tools can tell how the file was made. EXIF that cannot be
+// parsed is kept as it is rather than replaced.
+func processingExif(metadata []byte, name string, config *Config) []byte {
+	var payload []byte
+	if len(metadata) > 4 {
+		payload = metadata[4:]
+	}
+	settings, err := json.Marshal(config.renderSettings())
+	if err == nil {
+		payload, err = stampExif(payload, markerKeyword+" "+Version, settings)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  %s: settings not written to EXIF: %v\n", name, err)
+		return metadata
+	}
+	return jpegSegment(0xe1, payload)
+}
+
+// settingsComment returns the COM segment -embed-settings adds to a JPEG
+// output: one line of key=value pairs with the source file, the canvas
+// size and the border ratios and scale actually applied to it, after the
+// -border-min-px/-border-max-px clamps. Unlike the processing marker it is
+// meant to be read by people, with rdjpgcom or exiftool -Comment.
+func settingsComment(job imageJob, l layout, config *Config) []byte {
+	top, bottom, left, right := config.borderConfig().Borders(l.isLandscape)
+	width, height := float64(config.targetWidth), float64(config.targetHeight)
+	orientation := "portrait"
+	if l.isLandscape {
+		orientation = "landscape"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s settings: source=%q", markerKeyword, filepath.Base(job.inputPath))
+	if job.page > 0 {
+		fmt.Fprintf(&b, " page=%d", job.page)
+	}
+	fmt.Fprintf(&b, " canvas=%dx%d orientation=%s border_top=%.4f border_bottom=%.4f border_left=%.4f border_right=%.4f scale=%.6f",
+		config.targetWidth, config.targetHeight, orientation,
+		top/height, bottom/height, left/width, right/width, l.scale)
+	return jpegSegment(0xfe, []byte(b.String()))
+}
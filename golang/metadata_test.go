@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+const tagDateTime = 0x0132
+
+// exifDateTime returns the DateTime tag of IFD0 in an EXIF APP1 payload, or
+// "" when it has none.
+func exifDateTime(t *testing.T, payload []byte) string {
+	t.Helper()
+	tiff := payload[len(exifHeader):]
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		t.Fatal("invalid TIFF header")
+	}
+	entries, err := ifdEntries(tiff, order, int(order.Uint32(tiff[4:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range entries {
+		if order.Uint16(tiff[pos:]) == tagDateTime {
+			offset, count := order.Uint32(tiff[pos+8:]), order.Uint32(tiff[pos+4:])
+			return string(bytes.TrimRight(tiff[offset:offset+count], "\x00"))
+		}
+	}
+	return ""
+}
+
+func TestPreserveMetadataDateTime(t *testing.T) {
+	const taken = "2024:05:17 14:03:22"
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, tagDateTime)
+	tiff = le.AppendUint16(tiff, typeASCII)
+	tiff = le.AppendUint32(tiff, uint32(len(taken)+1))
+	tiff = le.AppendUint32(tiff, 8+2+12+4)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(append(tiff, taken...), 0)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	if err := os.WriteFile(input, jpegWithExif(t, noise(80, 60), tiff), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The processing marker rewrites IFD0, which must keep the date too.
+	for _, marker := range []bool{false, true} {
+		config := testConfig()
+		config.preserveMetadata, config.colorProfile = true, colorProfileKeep
+		config.embedMarker = marker
+		job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.jpg")}
+		if _, err := processImage(context.Background(), job, config); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(job.outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := findJPEGAPP1(bufio.NewReader(f), exifHeader)
+		f.Close()
+		if err != nil {
+			t.Fatalf("output has no EXIF (-marker=%v): %v", marker, err)
+		}
+		if got := exifDateTime(t, payload); got != taken {
+			t.Errorf("DateTime = %q with -marker=%v, want %q", got, marker, taken)
+		}
+	}
+}
+
+func TestEmbedSettingsComment(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	writeImage(t, input, noise(80, 60))
+
+	config := testConfig()
+	config.embedSettings, config.embedMarker = true, true
+	job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.jpg")}
+	info, err := processImage(context.Background(), job, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(job.outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var comments []string
+	err = scanJPEGSegments(bufio.NewReader(f), func(marker byte) bool { return marker == 0xfe }, func(_ byte, payload []byte) bool {
+		comments = append(comments, string(payload))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top, bottom, left, right := config.borderConfig().Borders(true)
+	want := fmt.Sprintf("%s settings: source=%q canvas=120x120 orientation=landscape border_top=%.4f border_bottom=%.4f border_left=%.4f border_right=%.4f scale=%.6f",
+		markerKeyword, "in.jpg", top/120, bottom/120, left/120, right/120, info.layout.scale)
+	if !slices.Contains(comments, want) {
+		t.Errorf("comments %q, want %q", comments, want)
+	}
+
+	// The processing marker is a separate COM segment and still reads back.
+	if _, err := readProcessingMarker(job.outputPath); err != nil {
+		t.Errorf("processing marker next to the settings comment: %v", err)
+	}
+}
+
+// metadataIn returns the APP1 and COM segments of the JPEG, or the tEXt
+// and iCCP chunks of the PNG, at path.
+func metadataIn(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	if filepath.Ext(path) == ".png" {
+		for pos := 8; pos+8 <= len(data); {
+			length, typ := int(binary.BigEndian.Uint32(data[pos:])), string(data[pos+4:pos+8])
+			if typ == "tEXt" || typ == "iCCP" {
+				found = append(found, typ)
+			}
+			pos += 12 + length
+		}
+		return found
+	}
+	err = scanJPEGSegments(bufio.NewReader(bytes.NewReader(data)), func(marker byte) bool { return marker == 0xe1 || marker == 0xfe }, func(marker byte, _ []byte) bool {
+		found = append(found, fmt.Sprintf("%#x", marker))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestStripMetadata(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "exif.jpg"), jpegWithOrientation(t, noise(60, 40), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(dir, "plain.png"), noise(40, 60))
+	outputs := []string{
+		filepath.Join(dir, "bordered_images", "bordered_exif.jpg"),
+		filepath.Join(dir, "bordered_images", "bordered_plain.png"),
+	}
+
+	run := func(args ...string) {
+		t.Helper()
+		config, input := parseArgs(t, append(append(args, "-force"), dir)...)
+		captureStdout(t, func() {
+			if err := runFolder(context.Background(), config, input, time.Now(), nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	// The processing marker and EXIF stamp are what -strip-metadata has
+	// to turn off, so check they are there without it.
+	run("-marker")
+	for _, output := range outputs {
+		if len(metadataIn(t, output)) == 0 {
+			t.Fatalf("%s has no metadata with -marker", output)
+		}
+	}
+
+	for _, args := range [][]string{{"-strip-metadata"}, {"-strip-metadata", "-marker"}} {
+		run(args...)
+		for _, output := range outputs {
+			if found := metadataIn(t, output); len(found) > 0 {
+				t.Errorf("%v: %s has %v", args, filepath.Base(output), found)
+			}
+		}
+	}
+}
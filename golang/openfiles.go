@@ -0,0 +1,49 @@
+package main
+
+// openFileHeadroom is how many descriptors the automatic -max-open-files
+// leaves for stdio, the manifest, the report and anything the OS needs.
+const openFileHeadroom = 64
+
+// fileSlots bounds how many file-handling sections run at once, across all
+// workers. A section may hold two descriptors (the JPEG XL encoder's temp
+// files, or a vips output copied into place), so the automatic limit counts
+// two per slot. Sections never nest, so a worker holds at most one slot.
+type fileSlots chan struct{}
+
+// openFiles is shared by every worker; it is nil until main sets it, which
+// leaves the sections unbounded.
+var openFiles fileSlots
+
+func newFileSlots(n int) fileSlots {
+	return make(fileSlots, n)
+}
+
+// acquire blocks until a slot is free.
+func (s fileSlots) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s fileSlots) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// defaultMaxOpenFiles derives the -max-open-files default from the
+// process's descriptor limit.
+func defaultMaxOpenFiles() int {
+	limit, ok := openFileLimit()
+	if !ok {
+		return 512
+	}
+	n := (limit - openFileHeadroom) / 2
+	if n < 1 {
+		n = 1
+	}
+	if n > 4096 {
+		n = 4096
+	}
+	return n
+}
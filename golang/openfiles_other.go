@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// openFileLimit is unknown outside Unix; the default falls back to a fixed
+// limit.
+func openFileLimit() (int, bool) {
+	return 0, false
+}
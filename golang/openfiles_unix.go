@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openFileLimit returns the soft RLIMIT_NOFILE. Go raises it to the hard
+// limit at startup, so this is the most the process can have open.
+func openFileLimit() (int, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	if rl.Cur > 1<<20 {
+		return 1 << 20, true
+	}
+	return int(rl.Cur), true
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunWithinOpenFileLimit lowers RLIMIT_NOFILE to a handful of spare
+// descriptors and borders many more images than that with far more
+// workers; -max-open-files must keep every one of them from failing with
+// EMFILE. Without it, this run loses dozens of images.
+func TestRunWithinOpenFileLimit(t *testing.T) {
+	fds, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		t.Skipf("cannot count open descriptors: %v", err)
+	}
+	var saved syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &saved); err != nil {
+		t.Fatal(err)
+	}
+	// Each slot may hold two descriptors; two more are spare.
+	const slots = 4
+	limit := uint64(len(fds)) + 2*slots + 2
+	if saved.Cur < limit {
+		t.Skipf("descriptor limit %d is already below %d", saved.Cur, limit)
+	}
+
+	dir := t.TempDir()
+	const images = 300
+	for i := 0; i < images; i++ {
+		writeImage(t, filepath.Join(dir, fmt.Sprintf("img%03d.jpg", i)), noise(160+i%7, 120))
+	}
+	config := testConfig()
+	config.maxWorkers = 128
+
+	lowered := saved
+	lowered.Cur = limit
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Skipf("cannot lower the descriptor limit: %v", err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &saved)
+	defer func(prev fileSlots) { openFiles = prev }(openFiles)
+	openFiles = newFileSlots(slots)
+
+	var failed []string
+	err = runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+		if r.error != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.filename, r.error))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range failed {
+		t.Error(f)
+	}
+	outputs, err := os.ReadDir(filepath.Join(dir, "bordered_images"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != images {
+		t.Errorf("wrote %d outputs, want %d", len(outputs), images)
+	}
+}
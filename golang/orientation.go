@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+const tagOrientation = 0x0112
+
+// scanJPEGSegments calls fn with the marker byte and payload of each segment
+// before the image data whose marker want accepts, until fn returns false.
+// The payloads of other segments are skipped without being read.
+func scanJPEGSegments(r *bufio.Reader, want func(marker byte) bool, fn func(marker byte, payload []byte) bool) error {
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil || soi[0] != 0xff || soi[1] != 0xd8 {
+		return errNoMarker
+	}
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return errNoMarker
+		}
+		if hdr[0] != 0xff || hdr[1] == 0xda || hdr[1] == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if length < 0 {
+			return errNoMarker
+		}
+		if !want(hdr[1]) {
+			if _, err := r.Discard(length); err != nil {
+				return errNoMarker
+			}
+			continue
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return errNoMarker
+		}
+		if !fn(hdr[1], payload) {
+			return nil
+		}
+	}
+}
+
+// findJPEGAPP1 scans the segments before the image data for an APP1 segment
+// whose payload starts with prefix, and returns the whole payload.
+func findJPEGAPP1(r *bufio.Reader, prefix []byte) ([]byte, error) {
+	var found []byte
+	err := scanJPEGSegments(r, func(marker byte) bool { return marker == 0xe1 }, func(_ byte, payload []byte) bool {
+		if bytes.HasPrefix(payload, prefix) {
+			found = payload
+		}
+		return found == nil
+	})
+	if err != nil || found == nil {
+		return nil, errNoMarker
+	}
+	return found, nil
+}
+
+// readJPEGOrientation returns the EXIF orientation (1-8) of the JPEG read
+// from r, or 1 when it has none or it cannot be read.
+func readJPEGOrientation(r io.Reader) int {
+	payload, err := findJPEGAPP1(bufio.NewReader(r), exifHeader)
+	if err != nil {
+		return 1
+	}
+	return exifOrientation(payload)
+}
+
+// exifOrientation returns the Orientation tag of IFD0 in an EXIF APP1
+// payload, or 1 when it is missing or invalid.
+func exifOrientation(payload []byte) int {
+	tiff := payload[len(exifHeader):]
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return 1
+	}
+	entries, err := ifdEntries(tiff, order, int(order.Uint32(tiff[4:])))
+	if err != nil {
+		return 1
+	}
+	for _, pos := range entries {
+		// A SHORT value sits in the first two bytes of the value field.
+		if order.Uint16(tiff[pos:]) == tagOrientation && order.Uint16(tiff[pos+2:]) == 3 {
+			if o := int(order.Uint16(tiff[pos+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+	return 1
+}
+
+// resetExifOrientation sets the Orientation tag of IFD0 in an EXIF APP1
+// payload to 1 in place, for pixels that were already turned upright.
+func resetExifOrientation(payload []byte) {
+	tiff := payload[len(exifHeader):]
+	order := tiffByteOrder(tiff)
+	if order == nil {
+		return
+	}
+	entries, err := ifdEntries(tiff, order, int(order.Uint32(tiff[4:])))
+	if err != nil {
+		return
+	}
+	for _, pos := range entries {
+		if order.Uint16(tiff[pos:]) == tagOrientation && order.Uint16(tiff[pos+2:]) == 3 {
+			order.PutUint16(tiff[pos+8:], 1)
+		}
+	}
+}
+
+// tiffByteOrder returns the byte order of TIFF data, as given by its
+// header, or nil when the header is invalid or too short to hold the
+// offset of IFD0.
+func tiffByteOrder(tiff []byte) binary.ByteOrder {
+	switch {
+	case len(tiff) < 8:
+		return nil
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		return binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// swapsAxes reports whether an EXIF orientation turns the image a quarter
+// turn, so its displayed width is its stored height.
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// orient returns img as it should be displayed for an EXIF orientation:
+// mirrored for 2 and 4, turned half a turn for 3, and a quarter turn (and
+// mirrored for 5 and 7) for 5 to 8. Orientation 1 returns img as is.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	dw, dh := w, h
+	if swapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+4*dx:dy*dst.Stride+4*dx+4], row[4*x:4*x+4])
+		}
+	}
+	return dst
+}
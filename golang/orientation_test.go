@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// jpegWithExif encodes img as a JPEG with an EXIF APP1 segment holding
+// tiff.
+func jpegWithExif(t *testing.T, img image.Image, tiff []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	payload := append(append([]byte(nil), exifHeader...), tiff...)
+	data := buf.Bytes()
+	out := append([]byte(nil), data[:2]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// jpegWithOrientation encodes img as a JPEG whose EXIF gives orientation.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+	be := binary.BigEndian
+	tiff := []byte("MM\x00*")
+	tiff = be.AppendUint32(tiff, 8)
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, tagOrientation)
+	tiff = be.AppendUint16(tiff, 3)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint16(tiff, orientation)
+	tiff = be.AppendUint16(tiff, 0)
+	tiff = be.AppendUint32(tiff, 0)
+	return jpegWithExif(t, img, tiff)
+}
+
+func TestOrientationQuarterTurns(t *testing.T) {
+	// A stored landscape, red on the left and blue on the right.
+	red, blue := color.RGBA{220, 0, 0, 255}, color.RGBA{0, 0, 220, 255}
+	stored := fill(80, 40, blue)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			stored.Set(x, y, red)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		orientation uint16
+		top, bottom color.RGBA
+	}{
+		// 6 is turned 90° clockwise for display, so the left edge ends up
+		// on top; 8 is turned 270°, so it ends up at the bottom.
+		{"90", 6, red, blue},
+		{"270", 8, blue, red},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "in.jpg")
+			data := jpegWithOrientation(t, stored, tt.orientation)
+			if err := os.WriteFile(input, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := readJPEGOrientation(bytes.NewReader(data)); got != int(tt.orientation) {
+				t.Fatalf("readJPEGOrientation() = %d, want %d", got, tt.orientation)
+			}
+
+			config := testConfig()
+			job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.png")}
+			info, err := processImage(context.Background(), job, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.sourceWidth != 40 || info.sourceHeight != 80 || info.layout.isLandscape {
+				t.Fatalf("source %dx%d (landscape %v), want an upright 40x80 portrait", info.sourceWidth, info.sourceHeight, info.layout.isLandscape)
+			}
+			dest := info.layout.destRect
+			if dest.Dx() >= dest.Dy() {
+				t.Fatalf("photo placed at %v, want it taller than wide", dest)
+			}
+
+			out := readImage(t, job.outputPath)
+			midX := (dest.Min.X + dest.Max.X) / 2
+			if got := out.At(midX, dest.Min.Y+dest.Dy()/4); !near(got, tt.top, 16) {
+				t.Errorf("top of the photo is %v, want %v", got, tt.top)
+			}
+			if got := out.At(midX, dest.Max.Y-dest.Dy()/4); !near(got, tt.bottom, 16) {
+				t.Errorf("bottom of the photo is %v, want %v", got, tt.bottom)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxPaletteColors is the size of an indexed PNG palette.
+	maxPaletteColors = 256
+	// photoColorThreshold is the number of distinct colors above which a
+	// canvas is treated as photographic and left unquantized, since
+	// posterizing a photo is rarely what the user wants.
+	photoColorThreshold = 8192
+)
+
+// colorCount is a distinct opaque color and how many pixels use it.
+type colorCount struct {
+	c     [3]uint8
+	count int
+}
+
+// countColors returns the distinct colors of canvas with their pixel
+// counts, or false as soon as there are more than limit of them.
+func countColors(canvas *image.RGBA, limit int) ([]colorCount, bool) {
+	counts := make(map[[3]uint8]int)
+	b := canvas.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := canvas.Pix[canvas.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			c := [3]uint8{row[4*x], row[4*x+1], row[4*x+2]}
+			counts[c]++
+			if len(counts) > limit {
+				return nil, false
+			}
+		}
+	}
+
+	colors := make([]colorCount, 0, len(counts))
+	for c, n := range counts {
+		colors = append(colors, colorCount{c, n})
+	}
+	// Map iteration order is random; sort so the palette is deterministic.
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i].c, colors[j].c
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return colors, true
+}
+
+// quantizeCanvas converts canvas to an indexed image of at most
+// maxPaletteColors colors, using median cut when it has more than that and
+// optionally Floyd-Steinberg dithering. It returns nil if the canvas looks
+// photographic, and otherwise the number of distinct colors it started with.
+func quantizeCanvas(canvas *image.RGBA, dither bool) (*image.Paletted, int) {
+	colors, ok := countColors(canvas, photoColorThreshold)
+	if !ok {
+		return nil, 0
+	}
+	return palettize(canvas, colors, dither), len(colors)
+}
+
+// palettize converts canvas, whose distinct colors are colors, to an indexed
+// image of at most maxPaletteColors colors. When colors have to be merged,
+// the most common one, normally the border, is kept exact.
+func palettize(canvas *image.RGBA, colors []colorCount, dither bool) *image.Paletted {
+	var pal color.Palette
+	if len(colors) <= maxPaletteColors {
+		for _, cc := range colors {
+			pal = append(pal, color.RGBA{cc.c[0], cc.c[1], cc.c[2], 255})
+		}
+		// Every color is in the palette; there is nothing to dither.
+		dither = false
+	} else {
+		top := 0
+		for i, cc := range colors {
+			if cc.count > colors[top].count {
+				top = i
+			}
+		}
+		rest := append(append([]colorCount{}, colors[:top]...), colors[top+1:]...)
+		pal = append(medianCut(rest, maxPaletteColors-1), color.RGBA{colors[top].c[0], colors[top].c[1], colors[top].c[2], 255})
+	}
+
+	out := image.NewPaletted(canvas.Bounds(), pal)
+	if dither {
+		draw.FloydSteinberg.Draw(out, out.Bounds(), canvas, canvas.Bounds().Min)
+	} else {
+		draw.Draw(out, out.Bounds(), canvas, canvas.Bounds().Min, draw.Src)
+	}
+	return out
+}
+
+// medianCut builds a palette of up to n colors by repeatedly splitting the
+// box of colors with the widest channel range at its pixel-weighted median.
+func medianCut(colors []colorCount, n int) color.Palette {
+	boxes := [][]colorCount{colors}
+	for len(boxes) < n {
+		// Pick the splittable box with the widest channel range.
+		best, bestRange, bestChannel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, r := widestChannel(box)
+			if r > bestRange {
+				best, bestRange, bestChannel = i, r, ch
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool { return box[i].c[bestChannel] < box[j].c[bestChannel] })
+		total := 0
+		for _, cc := range box {
+			total += cc.count
+		}
+		split, seen := 1, 0
+		for i, cc := range box[:len(box)-1] {
+			seen += cc.count
+			if seen*2 >= total {
+				split = i + 1
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		var r, g, b, total int
+		for _, cc := range box {
+			r += int(cc.c[0]) * cc.count
+			g += int(cc.c[1]) * cc.count
+			b += int(cc.c[2]) * cc.count
+			total += cc.count
+		}
+		pal = append(pal, color.RGBA{uint8(r / total), uint8(g / total), uint8(b / total), 255})
+	}
+	return pal
+}
+
+// widestChannel returns the RGB channel with the largest range in box, and
+// that range.
+func widestChannel(box []colorCount) (int, int) {
+	lo := [3]uint8{255, 255, 255}
+	var hi [3]uint8
+	for _, cc := range box {
+		for ch := 0; ch < 3; ch++ {
+			if cc.c[ch] < lo[ch] {
+				lo[ch] = cc.c[ch]
+			}
+			if cc.c[ch] > hi[ch] {
+				hi[ch] = cc.c[ch]
+			}
+		}
+	}
+	best, bestRange := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		if r := int(hi[ch]) - int(lo[ch]); r > bestRange {
+			best, bestRange = ch, r
+		}
+	}
+	return best, bestRange
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// pngCompressionLevels maps the -png-compression values to encoder levels.
+// They trade file size for encoding time and leave the pixels unchanged.
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+	"none":    png.NoCompression,
+}
+
+// keepsDepth reports whether img, with more than 8 bits per channel, is
+// drawn and written at 16 bits per channel: for PNG and TIFF outputs,
+// unless -force-8bit (or -png-palette for PNGs) asks for fewer bits.
+func keepsDepth(img image.Image, outputPath string, config *Config) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+	default:
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".png":
+		return !config.force8Bit && !config.pngPalette
+	case ".tif", ".tiff":
+		return !config.force8Bit
+	}
+	return false
+}
+
+// encodePNG writes img as PNG at the -png-compression level.
+func (c *Config) encodePNG(w io.Writer, img image.Image) error {
+	enc := png.Encoder{CompressionLevel: pngCompressionLevels[c.pngCompression]}
+	return enc.Encode(w, img)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+func init() {
+	for _, magic := range []string{"P2", "P3", "P5", "P6"} {
+		image.RegisterFormat("pnm", magic, decodePNM, decodePNMConfig)
+	}
+}
+
+// pnmMaxPixels caps width x height in a PNM header, which is checked
+// before the raster is allocated: a header claiming 1<<20 x 1<<20 pixels
+// takes a few bytes to write and would otherwise ask for terabytes.
+const pnmMaxPixels = 1 << 27
+
+// pnmHeader is the header of a PGM or PPM file.
+type pnmHeader struct {
+	channels      int
+	ascii         bool
+	width, height int
+	maxval        int
+}
+
+// readPNMHeader reads the magic number, dimensions and maxval.
+func readPNMHeader(br *bufio.Reader) (pnmHeader, error) {
+	var h pnmHeader
+	magic, err := readPNMToken(br)
+	if err != nil {
+		return h, fmt.Errorf("pnm: reading magic number: %v", err)
+	}
+
+	switch magic {
+	case "P2":
+		h.channels, h.ascii = 1, true
+	case "P3":
+		h.channels, h.ascii = 3, true
+	case "P5":
+		h.channels = 1
+	case "P6":
+		h.channels = 3
+	default:
+		return h, fmt.Errorf("pnm: unsupported magic number %q", magic)
+	}
+
+	if h.width, err = readPNMHeaderInt(br, "width", 1, 1<<20); err != nil {
+		return h, err
+	}
+	if h.height, err = readPNMHeaderInt(br, "height", 1, 1<<20); err != nil {
+		return h, err
+	}
+	if int64(h.width)*int64(h.height) > pnmMaxPixels {
+		return h, fmt.Errorf("pnm: %dx%d exceeds the limit of %d pixels", h.width, h.height, pnmMaxPixels)
+	}
+	if h.maxval, err = readPNMHeaderInt(br, "maxval", 1, 65535); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// decodePNMConfig returns the color model and dimensions of a PGM or PPM.
+func decodePNMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	model := color.GrayModel
+	switch {
+	case h.channels == 1 && h.maxval > 255:
+		model = color.Gray16Model
+	case h.channels == 3 && h.maxval > 255:
+		model = color.RGBA64Model
+	case h.channels == 3:
+		model = color.RGBAModel
+	}
+	return image.Config{ColorModel: model, Width: h.width, Height: h.height}, nil
+}
+
+// decodePNM decodes the binary (P5/P6) and ASCII (P2/P3) PGM and PPM formats.
+// Maxval values other than 255 are scaled to the full range of the returned
+// image; anything above 255 produces a 16-bit image.
+func decodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	h, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	channels, ascii := h.channels, h.ascii
+	width, height, maxval := h.width, h.height, h.maxval
+
+	// Binary rasters start after exactly one whitespace byte following maxval,
+	// which readPNMToken has already consumed.
+	next := func() (int, error) {
+		if ascii {
+			tok, err := readPNMToken(br)
+			if err != nil {
+				return 0, err
+			}
+			v, err := strconv.Atoi(tok)
+			if err != nil {
+				return 0, fmt.Errorf("invalid sample %q", tok)
+			}
+			return v, nil
+		}
+		hi, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if maxval < 256 {
+			return int(hi), nil
+		}
+		lo, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return int(hi)<<8 | int(lo), nil
+	}
+
+	sample := func() (int, error) {
+		v, err := next()
+		if err == io.EOF {
+			return 0, fmt.Errorf("pnm: unexpected end of raster data")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("pnm: %v", err)
+		}
+		if v < 0 || v > maxval {
+			return 0, fmt.Errorf("pnm: sample %d exceeds maxval %d", v, maxval)
+		}
+		return v, nil
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	wide := maxval > 255
+	scale8 := func(v int) uint8 { return uint8((v*255 + maxval/2) / maxval) }
+	scale16 := func(v int) uint16 { return uint16((v*65535 + maxval/2) / maxval) }
+
+	switch {
+	case channels == 1 && !wide:
+		img := image.NewGray(rect)
+		for i := range img.Pix {
+			v, err := sample()
+			if err != nil {
+				return nil, err
+			}
+			img.Pix[i] = scale8(v)
+		}
+		return img, nil
+	case channels == 1:
+		img := image.NewGray16(rect)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				v, err := sample()
+				if err != nil {
+					return nil, err
+				}
+				img.SetGray16(x, y, color.Gray16{Y: scale16(v)})
+			}
+		}
+		return img, nil
+	case !wide:
+		img := image.NewRGBA(rect)
+		for i := 0; i < len(img.Pix); i += 4 {
+			for c := 0; c < 3; c++ {
+				v, err := sample()
+				if err != nil {
+					return nil, err
+				}
+				img.Pix[i+c] = scale8(v)
+			}
+			img.Pix[i+3] = 0xff
+		}
+		return img, nil
+	default:
+		img := image.NewRGBA64(rect)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				var rgb [3]uint16
+				for c := range rgb {
+					v, err := sample()
+					if err != nil {
+						return nil, err
+					}
+					rgb[c] = scale16(v)
+				}
+				img.SetRGBA64(x, y, color.RGBA64{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xffff})
+			}
+		}
+		return img, nil
+	}
+}
+
+// readPNMHeaderInt reads one header field and checks it lies within [lo, hi].
+func readPNMHeaderInt(br *bufio.Reader, name string, lo, hi int) (int, error) {
+	tok, err := readPNMToken(br)
+	if err != nil {
+		return 0, fmt.Errorf("pnm: reading %s: %v", name, err)
+	}
+	v, err := strconv.Atoi(tok)
+	if err != nil {
+		return 0, fmt.Errorf("pnm: invalid %s %q", name, tok)
+	}
+	if v < lo || v > hi {
+		return 0, fmt.Errorf("pnm: %s %d out of range [%d, %d]", name, v, lo, hi)
+	}
+	return v, nil
+}
+
+// readPNMToken returns the next whitespace-delimited token, skipping
+// '#' comments. The single whitespace byte terminating the token is consumed.
+func readPNMToken(br *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(tok) > 0 {
+				return string(tok), nil
+			}
+			return "", err
+		}
+		switch {
+		case b == '#' && len(tok) == 0:
+			if _, err := br.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+		case isPNMSpace(b):
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, b)
+		}
+	}
+}
+
+func isPNMSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestDecodePNM(t *testing.T) {
+	for name, data := range map[string]string{
+		"P2": "P2\n# comment\n2 1\n15\n0 15\n",
+		"P5": "P5 2 1 255\n\x00\xff",
+	} {
+		img, format, err := image.Decode(strings.NewReader(data))
+		if err != nil || format != "pnm" {
+			t.Fatalf("%s: format %q, %v", name, format, err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 2, 1) || img.At(0, 0) != (color.Gray{0}) || img.At(1, 0) != (color.Gray{255}) {
+			t.Errorf("%s: decoded %v", name, img)
+		}
+	}
+}
+
+func TestDecodePNMTooLarge(t *testing.T) {
+	// Both dimensions are in range, but their product is far over the cap.
+	data := []byte("P6 1048576 1048576 255\n\x00\x00\x00")
+	if _, err := decodePNMConfig(bytes.NewReader(data)); err == nil {
+		t.Error("decodePNMConfig accepted 1048576x1048576")
+	}
+	if _, err := decodePNM(bytes.NewReader(data)); err == nil {
+		t.Error("decodePNM accepted 1048576x1048576")
+	}
+}
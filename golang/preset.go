@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// presetExcludedFlags are the flags that describe a particular run, or the
+// machine it runs on, rather than the look of the output, so they are not
+// saved in presets; a preset shared with someone else must not change which
+// files their run picks up or how it uses their machine. They are ignored
+// in presets saved before they were excluded, too.
+var presetExcludedFlags = map[string]bool{
+	"input":                true,
+	"preset":               true,
+	"size":                 true,
+	"profile":              true,
+	"color":                true,
+	"output-format":        true,
+	"keep-metadata":        true,
+	"scaler":               true,
+	"dry-run":              true,
+	"watch":                true,
+	"verbose":              true,
+	"save-preset":          true,
+	"force":                true,
+	"shard":                true,
+	"manifest":             true,
+	"report":               true,
+	"diff-settings":        true,
+	"diff-list":            true,
+	"show-preview":         true,
+	"preview-count":        true,
+	"jobs":                 true,
+	"jobs-result":          true,
+	"qa-sample":            true,
+	"qa-seed":              true,
+	"max-open-files":       true,
+	"output":               true,
+	"sftp-key":             true,
+	"known-hosts":          true,
+	"transfer-concurrency": true,
+	"tune":                 true,
+	"tune-addr":            true,
+	"wizard":               true,
+	"config":               true,
+	"workers":              true,
+	"batch-size":           true,
+	"schedule":             true,
+	"max-memory":           true,
+	"stdin":                true,
+	"recursive":            true,
+	"pattern":              true,
+	"ext":                  true,
+	"skip-existing":        true,
+	"dedup":                true,
+	"min-rating":           true,
+	"include-unrated":      true,
+	"fsync":                true,
+	"log-format":           true,
+	"html-report":          true,
+	"sidecars-json":        true,
+	"verify-outputs":       true,
+	"measure-quality":      true,
+	"reproducible":         true,
+}
+
+// userConfigPath returns the path of the user's config file, e.g.
+// ~/.config/white_border_adder/config.json on Linux.
+func userConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, markerKeyword, "config.json"), nil
+}
+
+// readUserConfig reads the config file as its top-level sections and its
+// presets. A missing file yields empty ones.
+func readUserConfig(path string) (map[string]json.RawMessage, map[string]map[string]string, error) {
+	sections := make(map[string]json.RawMessage)
+	presets := make(map[string]map[string]string)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sections, presets, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if raw, ok := sections["presets"]; ok {
+		if err := json.Unmarshal(raw, &presets); err != nil {
+			return nil, nil, fmt.Errorf("parsing presets in %s: %v", path, err)
+		}
+	}
+	return sections, presets, nil
+}
+
+// loadPreset returns the flag values saved under name.
+func loadPreset(name string) (map[string]string, error) {
+	path, err := userConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	_, presets, err := readUserConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := presets[name]
+	if !ok {
+		names := make([]string, 0, len(presets))
+		for n := range presets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no preset %q in %s (available: %v)", name, path, names)
+	}
+	return values, nil
+}
+
+// applyPreset sets every flag saved in values that was not given on the
+// command line, so explicit flags win over the preset.
+func applyPreset(flagSet *flag.FlagSet, values map[string]string) error {
+	return applySettings(flagSet, values, "preset", presetExcludedFlags)
+}
+
+// applySettings sets every flag in values that is not already set, under
+// its own name or an alias, and not excluded. They are set in name order,
+// so messages come out the same every time. source names where the values
+// come from in messages.
+func applySettings(flagSet *flag.FlagSet, values map[string]string, source string, excluded map[string]bool) error {
+	explicit := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for alias, name := range flagAliases {
+		if explicit[alias] || explicit[name] {
+			explicit[alias], explicit[name] = true, true
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := values[name]
+		if explicit[name] || excluded[name] {
+			continue
+		}
+		if flagSet.Lookup(name) == nil {
+			fmt.Printf("⚠️  Ignoring unknown setting %q in %s\n", name, source)
+			continue
+		}
+		if err := flagSet.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for -%s in %s: %v", value, name, source, err)
+		}
+	}
+	return nil
+}
+
+// savePresetTo stores the effective value of every setting flag under name in
+// the user's config file, leaving the rest of the file untouched. The file
+// is rewritten atomically.
+func savePresetTo(flagSet *flag.FlagSet, name string, force bool) (string, error) {
+	path, err := userConfigPath()
+	if err != nil {
+		return "", err
+	}
+	sections, presets, err := readUserConfig(path)
+	if err != nil {
+		return "", err
+	}
+	if _, exists := presets[name]; exists && !force {
+		return "", fmt.Errorf("preset %q already exists in %s (use -force to overwrite it)", name, path)
+	}
+
+	values := make(map[string]string)
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if !presetExcludedFlags[f.Name] {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	presets[name] = values
+
+	raw, err := json.Marshal(presets)
+	if err != nil {
+		return "", err
+	}
+	sections["presets"] = raw
+	data, err := json.MarshalIndent(sections, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
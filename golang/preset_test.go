@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPresetLeavesOutRunFlags(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	runFlags := map[string]string{
+		"workers":       "3",
+		"recursive":     "true",
+		"pattern":       "*.jpg",
+		"ext":           "jpg",
+		"skip-existing": "true",
+		"dedup":         "true",
+		"fsync":         "true",
+		"log-format":    "json",
+	}
+	args := []string{"-save-preset", "look", "-background", "black"}
+	for name, value := range runFlags {
+		args = append(args, "-"+name+"="+value)
+	}
+	parseArgs(t, append(args, dir)...)
+
+	path, err := userConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, presets, err := readUserConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	look := presets["look"]
+	if look["background"] != "black" {
+		t.Errorf("preset has background %q, want black", look["background"])
+	}
+	for name := range runFlags {
+		if value, ok := look[name]; ok {
+			t.Errorf("preset saved -%s=%s", name, value)
+		}
+	}
+
+	// A preset saved before these flags were excluded does not apply them.
+	old := `{"presets": {"old": {"background": "black", "workers": "7", "recursive": "true", "pattern": "*.png"}}}`
+	if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := parseArgs(t, "-preset", "old", dir)
+	if config.background != "black" {
+		t.Errorf("-preset old gave background %q, want black", config.background)
+	}
+	if config.maxWorkers == 7 || config.recursive || config.pattern != "" {
+		t.Errorf("-preset old applied run flags: workers %d, recursive %v, pattern %q", config.maxWorkers, config.recursive, config.pattern)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/png"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// previewSize is the longest side of the inline terminal preview.
+const previewSize = 480
+
+// Inline image protocols supported by -show-preview.
+const (
+	previewKitty = "kitty"
+	previewITerm = "iterm"
+	previewSixel = "sixel"
+)
+
+// detectPreviewProtocol returns the inline image protocol of the terminal
+// attached to stdout, or "" when stdout is not a terminal or none is known to
+// be supported.
+func detectPreviewProtocol() string {
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return ""
+	}
+
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+	switch {
+	case term == "xterm-kitty" || os.Getenv("KITTY_WINDOW_ID") != "":
+		return previewKitty
+	case termProgram == "iTerm.app" || termProgram == "WezTerm":
+		return previewITerm
+	case strings.Contains(term, "sixel") || term == "mlterm" || term == "foot" || term == "yaft-256color":
+		return previewSixel
+	}
+	return ""
+}
+
+// renderPreview downscales the canvas for display in the terminal.
+func renderPreview(canvas image.Image) *image.RGBA {
+	b := canvas.Bounds()
+	w, h := previewSize, previewSize
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*previewSize/b.Dx())
+	} else {
+		w = max(1, b.Dx()*previewSize/b.Dy())
+	}
+	preview := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(preview, preview.Bounds(), canvas, b, draw.Src, nil)
+	return preview
+}
+
+// showPreview writes img to stdout as an inline image using protocol. The
+// whole escape sequence goes out in a single write so concurrent output
+// cannot interleave with it.
+func showPreview(img image.Image, name, protocol string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "🖼️  Preview of %s:\n", name)
+
+	switch protocol {
+	case previewKitty, previewITerm:
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, img); err != nil {
+			return err
+		}
+		data := base64.StdEncoding.EncodeToString(encoded.Bytes())
+		if protocol == previewITerm {
+			fmt.Fprintf(&buf, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a", encoded.Len(), data)
+			break
+		}
+		// Kitty transmits base64 payloads in chunks of at most 4096 bytes.
+		for i := 0; i < len(data); i += 4096 {
+			end, more := i+4096, 1
+			if end >= len(data) {
+				end, more = len(data), 0
+			}
+			if i == 0 {
+				fmt.Fprintf(&buf, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, data[i:end])
+			} else {
+				fmt.Fprintf(&buf, "\x1b_Gm=%d;%s\x1b\\", more, data[i:end])
+			}
+		}
+	case previewSixel:
+		writeSixel(&buf, img)
+	default:
+		return fmt.Errorf("unsupported preview protocol %q", protocol)
+	}
+	buf.WriteString("\n")
+
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// writeSixel encodes img as a DEC sixel image, dithered to the Plan 9
+// palette.
+func writeSixel(buf *bytes.Buffer, img image.Image) {
+	b := img.Bounds()
+	pal := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(pal, pal.Bounds(), img, b.Min)
+	w, h := pal.Rect.Dx(), pal.Rect.Dy()
+
+	fmt.Fprintf(buf, "\x1bPq\"1;1;%d;%d", w, h)
+	for i, c := range pal.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(buf, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	for band := 0; band < h; band += 6 {
+		// Collect the colors present in this band of six rows.
+		used := make(map[uint8]bool)
+		for y := band; y < band+6 && y < h; y++ {
+			for x := 0; x < w; x++ {
+				used[pal.ColorIndexAt(x, y)] = true
+			}
+		}
+		first := true
+		for idx := 0; idx < len(pal.Palette); idx++ {
+			if !used[uint8(idx)] {
+				continue
+			}
+			if !first {
+				buf.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(buf, "#%d", idx)
+
+			var run int
+			var last byte
+			flush := func() {
+				if run > 3 {
+					fmt.Fprintf(buf, "!%d%c", run, last)
+				} else {
+					for ; run > 0; run-- {
+						buf.WriteByte(last)
+					}
+				}
+				run = 0
+			}
+			for x := 0; x < w; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < h; dy++ {
+					if pal.ColorIndexAt(x, band+dy) == uint8(idx) {
+						bits |= 1 << dy
+					}
+				}
+				ch := 63 + bits
+				if run > 0 && ch != last {
+					flush()
+				}
+				last = ch
+				run++
+			}
+			flush()
+		}
+		buf.WriteByte('-')
+	}
+	buf.WriteString("\x1b\\")
+}
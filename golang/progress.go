@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// progressBarWidth is the number of cells of the progress bar.
+	progressBarWidth = 30
+	// progressRedrawInterval limits how often the bar is redrawn on a
+	// terminal.
+	progressRedrawInterval = 100 * time.Millisecond
+	// progressLogSteps is the number of lines a run logs when stdout is
+	// not a terminal, one per tenth of the images.
+	progressLogSteps = 10
+)
+
+// progressLine is the live "completed/total, ETA" display shown instead of
+// the per-file log unless -verbose is given. On a terminal it is one line
+// redrawn in place; otherwise a plain line is printed every tenth of the
+// run. It is guarded by the processingStats mutex.
+type progressLine struct {
+	w        io.Writer
+	terminal bool
+	total    int
+	done     int
+	failed   int
+	// busy is the summed processing time of the completed images.
+	busy     time.Duration
+	start    time.Time
+	lastDraw time.Time
+	lastStep int
+}
+
+func newProgressLine(total int) *progressLine {
+	fi, err := os.Stdout.Stat()
+	return &progressLine{
+		w:        os.Stdout,
+		terminal: err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		total:    total,
+		start:    time.Now(),
+	}
+}
+
+// eta estimates the time left: the average per-image time, divided by the
+// number of images processed at once so far, for each image left.
+func (p *progressLine) eta() time.Duration {
+	if p.done == 0 || p.busy == 0 {
+		return 0
+	}
+	elapsed := time.Since(p.start)
+	average := p.busy / time.Duration(p.done)
+	concurrency := float64(p.busy) / float64(elapsed)
+	return time.Duration(float64(average) * float64(p.total-p.done) / concurrency)
+}
+
+func (p *progressLine) String() string {
+	filled := 0
+	if p.total > 0 {
+		filled = progressBarWidth * p.done / p.total
+	}
+	s := fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("█", filled), strings.Repeat("░", progressBarWidth-filled), p.done, p.total)
+	if p.failed > 0 {
+		s += fmt.Sprintf(", %d failed", p.failed)
+	}
+	if p.done < p.total && p.done > 0 {
+		s += fmt.Sprintf(", ETA %s", p.eta().Round(time.Second))
+	}
+	return s
+}
+
+// draw shows the current progress. On a terminal it is throttled unless
+// force is set; otherwise it only prints when a tenth of the run is crossed.
+func (p *progressLine) draw(force bool) {
+	if p.terminal {
+		if !force && time.Since(p.lastDraw) < progressRedrawInterval {
+			return
+		}
+		p.lastDraw = time.Now()
+		fmt.Fprintf(p.w, "\r\033[K%s", p)
+		return
+	}
+	if step := progressLogSteps * p.done / max(p.total, 1); step > p.lastStep {
+		p.lastStep = step
+		fmt.Fprintf(p.w, "⏳ %s\n", p)
+	}
+}
+
+// clear removes the progress line from a terminal so other output can be
+// printed in its place.
+func (p *progressLine) clear() {
+	if p.terminal {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+}
+
+// imageDone records a finished image on the progress line. Workers call it
+// concurrently.
+func (ps *processingStats) imageDone(result processingResult) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.progress == nil {
+		return
+	}
+	p := ps.progress
+	p.done++
+	p.busy += result.duration
+	if result.error != nil {
+		p.failed++
+	}
+	p.draw(p.done == p.total)
+}
+
+// logf prints a line of the run's log without garbling the progress line.
+func (ps *processingStats) logf(format string, args ...any) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.progress == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+	ps.progress.clear()
+	fmt.Printf(format, args...)
+	ps.progress.draw(true)
+}
+
+// finishProgress leaves the final progress line in place before the
+// summary is printed.
+func (ps *processingStats) finishProgress() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p := ps.progress
+	if p == nil {
+		return
+	}
+	switch {
+	case p.terminal:
+		p.draw(true)
+		fmt.Fprintln(p.w)
+	case p.done < p.total:
+		// An interrupted run stops between the logged steps.
+		fmt.Fprintf(p.w, "⏳ %s\n", p)
+	}
+	ps.progress = nil
+}
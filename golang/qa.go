@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// qaSampleFolder is the folder, inside the output folder, that -qa-sample
+// copies its picks into.
+const qaSampleFolder = "qa_sample"
+
+// qaSampler keeps a uniform random sample of the successful results of a
+// run, one reservoir per orientation, so the final pick can be split between
+// landscapes and portraits even when one of them is rare. Each result gets a
+// pseudo-random key from the seed and its path, and the reservoirs keep the
+// lowest keys: unlike replacing at random as results arrive, this picks the
+// same images for the same seed whatever order the workers finish in.
+type qaSampler struct {
+	size   int
+	seed   int64
+	strata [2][]qaPick // landscape, portrait
+}
+
+type qaPick struct {
+	key    uint64
+	result processingResult
+}
+
+func newQASampler(size int, seed int64) *qaSampler {
+	return &qaSampler{size: size, seed: seed}
+}
+
+// add offers a result to the sample. Failed and skipped results are
+// ignored.
+func (s *qaSampler) add(r processingResult) {
+	if r.error != nil || r.skipped {
+		return
+	}
+	stratum := &s.strata[1]
+	if r.info.layout.isLandscape {
+		stratum = &s.strata[0]
+	}
+
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, s.seed)
+	h.Write([]byte(r.inputPath))
+	pick := qaPick{key: h.Sum64(), result: r}
+
+	// Each reservoir holds up to the full sample size, in case the other
+	// orientation has too few images to fill its half.
+	if len(*stratum) < s.size {
+		*stratum = append(*stratum, pick)
+		return
+	}
+	highest := 0
+	for i, p := range *stratum {
+		if p.key > (*stratum)[highest].key {
+			highest = i
+		}
+	}
+	if pick.key < (*stratum)[highest].key {
+		(*stratum)[highest] = pick
+	}
+}
+
+// sample returns the final pick: half of each orientation where possible,
+// the shorter one's remainder going to the other.
+func (s *qaSampler) sample() []processingResult {
+	land, port := s.strata[0], s.strata[1]
+	for _, stratum := range s.strata {
+		sort.Slice(stratum, func(i, j int) bool { return stratum[i].key < stratum[j].key })
+	}
+
+	nLand := max((s.size+1)/2, s.size-len(port))
+	if nLand > len(land) {
+		nLand = len(land)
+	}
+	nPort := s.size - nLand
+	if nPort > len(port) {
+		nPort = len(port)
+	}
+	var picked []processingResult
+	for _, p := range append(land[:nLand:nLand], port[:nPort]...) {
+		picked = append(picked, p.result)
+	}
+	return picked
+}
+
+// copyTo replaces the contents of the qa_sample folder in outputFolder with
+// copies of the sampled outputs, and returns their paths.
+func (s *qaSampler) copyTo(outputFolder string) ([]string, error) {
+	dir := filepath.Join(outputFolder, qaSampleFolder)
+	// The folder belongs to the tool: a sample from an earlier run would
+	// be mixed into this one otherwise.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	for _, r := range s.sample() {
+		dst := filepath.Join(dir, filepath.Base(r.outputPath))
+		if err := copyFile(r.outputPath, dst); err != nil {
+			return copied, fmt.Errorf("error copying %s: %v", r.outputPath, err)
+		}
+		copied = append(copied, dst)
+	}
+	return copied, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
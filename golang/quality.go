@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// ssimWindow and ssimStride define the sliding windows SSIM is averaged
+	// over.
+	ssimWindow = 8
+	ssimStride = 4
+	// minSearchQuality is the lowest JPEG quality -target-ssim will try.
+	minSearchQuality = 30
+	// maxSearchIterations bounds the encode/decode/measure rounds per image.
+	maxSearchIterations = 7
+	// maxPSNR is reported for identical images, whose PSNR is infinite.
+	maxPSNR = 100
+)
+
+// luma returns the luminance plane of img as 8-bit values, row by row.
+func luma(img image.Image) []uint8 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]uint8, w*h)
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			row := src.Y[src.YOffset(b.Min.X, b.Min.Y+y):]
+			copy(out[y*w:(y+1)*w], row[:w])
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				r, g, bl := uint32(row[4*x]), uint32(row[4*x+1]), uint32(row[4*x+2])
+				out[y*w+x] = uint8((19595*r + 38470*g + 7471*bl + 1<<15) >> 16)
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out[y*w+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+	return out
+}
+
+// ssim computes the mean structural similarity of the luminance of two
+// images of equal size, in [-1, 1] with 1 meaning identical.
+func ssim(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return 0
+	}
+	return ssimLuma(luma(a), luma(b), ab.Dx(), ab.Dy())
+}
+
+func ssimLuma(la, lb []uint8, w, h int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	if w < ssimWindow || h < ssimWindow {
+		if bytes.Equal(la, lb) {
+			return 1
+		}
+		return 0
+	}
+
+	var total float64
+	var windows int
+	const n = ssimWindow * ssimWindow
+	for y0 := 0; y0+ssimWindow <= h; y0 += ssimStride {
+		for x0 := 0; x0+ssimWindow <= w; x0 += ssimStride {
+			var sa, sb, saa, sbb, sab float64
+			for y := y0; y < y0+ssimWindow; y++ {
+				for x := x0; x < x0+ssimWindow; x++ {
+					va, vb := float64(la[y*w+x]), float64(lb[y*w+x])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			ma, mb := sa/n, sb/n
+			varA := saa/n - ma*ma
+			varB := sbb/n - mb*mb
+			cov := sab/n - ma*mb
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// encodeJPEGForSSIM binary-searches the smallest JPEG quality, between
+// minSearchQuality and maxQuality, whose decoded result reaches target SSIM
+// against img. It returns the encoded bytes, the chosen quality and the SSIM
+// achieved. If even maxQuality misses the target, maxQuality is used.
+// encode writes a JPEG at a given quality.
+func encodeJPEGForSSIM(img image.Image, target float64, maxQuality int, encode func(io.Writer, image.Image, int) error) ([]byte, int, float64, error) {
+	ref := luma(img)
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	measure := func(quality int) ([]byte, float64, error) {
+		var buf bytes.Buffer
+		if err := encode(&buf, img, quality); err != nil {
+			return nil, 0, err
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, 0, fmt.Errorf("decoding trial encode: %v", err)
+		}
+		return buf.Bytes(), ssimLuma(ref, luma(decoded), w, h), nil
+	}
+
+	lo, hi := minSearchQuality, maxQuality
+	if lo > hi {
+		lo = hi
+	}
+	var best []byte
+	bestQuality, bestScore := 0, 0.0
+	for i := 0; i < maxSearchIterations && lo <= hi; i++ {
+		mid := (lo + hi) / 2
+		data, score, err := measure(mid)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if score >= target {
+			best, bestQuality, bestScore = data, mid, score
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if best == nil {
+		data, score, err := measure(maxQuality)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		best, bestQuality, bestScore = data, maxQuality, score
+	}
+	return best, bestQuality, bestScore, nil
+}
+
+// measureQuality compares the fitted region of the written output with a
+// high-quality reference downscale of the source and returns the PSNR (in
+// dB, over RGB) and luminance SSIM.
+func measureQuality(outputPath string, src image.Image, destRect image.Rectangle) (float64, float64, error) {
+	out, err := decodeOutput(outputPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fitted := image.NewRGBA(image.Rect(0, 0, destRect.Dx(), destRect.Dy()))
+	draw.Draw(fitted, fitted.Bounds(), out, destRect.Min, draw.Src)
+
+	ref := image.NewRGBA(fitted.Bounds())
+	if src.Bounds().Size() == destRect.Size() {
+		draw.Draw(ref, ref.Bounds(), src, src.Bounds().Min, draw.Src)
+	} else {
+		draw.CatmullRom.Scale(ref, ref.Bounds(), src, src.Bounds(), draw.Src, nil)
+	}
+
+	return psnr(ref, fitted), ssim(ref, fitted), nil
+}
+
+// psnr returns the peak signal-to-noise ratio between two RGBA images of
+// equal size, capped at maxPSNR.
+func psnr(a, b *image.RGBA) float64 {
+	var sum float64
+	var n int
+	for i := 0; i < len(a.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := float64(a.Pix[i+c]) - float64(b.Pix[i+c])
+			sum += d * d
+			n++
+		}
+	}
+	if n == 0 || sum == 0 {
+		return maxPSNR
+	}
+	return math.Min(maxPSNR, 10*math.Log10(255*255/(sum/float64(n))))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// xmpNamespace is the namespace of the xmp:Rating property.
+	xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+	// xmpAPP1Prefix starts the APP1 segment holding a JPEG's XMP packet.
+	xmpAPP1Prefix = xmpNamespace + "\x00"
+)
+
+// errNoRating reports that an image has no XMP rating.
+var errNoRating = errors.New("no rating")
+
+// readRating returns the xmp:Rating of the image at path, from a sidecar
+// .xmp with the same basename if there is one, as written for files an
+// editor does not modify, or else from the XMP packet embedded in a JPEG.
+// It returns errNoRating when neither has a rating.
+func readRating(path string) (float64, error) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".xmp", ".XMP"} {
+		data, err := os.ReadFile(base + ext)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		rating, err := parseXMPRating(data)
+		if err != nil && err != errNoRating {
+			return 0, fmt.Errorf("%s: %v", filepath.Base(base+ext), err)
+		}
+		return rating, err
+	}
+
+	packet, err := readJPEGXMP(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseXMPRating(packet)
+}
+
+// readJPEGXMP returns the XMP packet of a JPEG file, or errNoRating if the
+// file is not a JPEG or has none.
+func readJPEGXMP(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	payload, err := findJPEGAPP1(bufio.NewReader(f), []byte(xmpAPP1Prefix))
+	if err != nil {
+		return nil, errNoRating
+	}
+	return payload[len(xmpAPP1Prefix):], nil
+}
+
+// parseXMPRating finds xmp:Rating in an XMP packet, written either as an
+// attribute of rdf:Description or as an element.
+func parseXMPRating(packet []byte) (float64, error) {
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return 0, errNoRating
+		}
+		if err != nil {
+			return 0, fmt.Errorf("malformed XMP: %v", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Space == xmpNamespace && start.Name.Local == "Rating" {
+			var text string
+			if err := d.DecodeElement(&text, &start); err != nil {
+				return 0, fmt.Errorf("malformed XMP: %v", err)
+			}
+			return parseRatingValue(text)
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Space == xmpNamespace && attr.Name.Local == "Rating" {
+				return parseRatingValue(attr.Value)
+			}
+		}
+	}
+}
+
+func parseRatingValue(s string) (float64, error) {
+	rating, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed XMP: invalid rating %q", s)
+	}
+	return rating, nil
+}
+
+// passesRating reports whether the image at path is rated at least
+// -min-rating. Unrated images count as 0 unless -include-unrated is set, and
+// images whose rating cannot be read are kept with a warning.
+func passesRating(path string, config *Config) bool {
+	rating, err := readRating(path)
+	switch {
+	case err == errNoRating:
+		return config.includeUnrated
+	case err != nil:
+		fmt.Printf("⚠️  %s: cannot read rating, not filtering it: %v\n", filepath.Base(path), err)
+		return true
+	}
+	return rating >= float64(config.minRating)
+}
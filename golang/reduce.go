@@ -0,0 +1,134 @@
+package main
+
+import "image"
+
+// reduceFactor returns how many times smaller, 8, 4 or 2, a JPEG displayed
+// at width x height can be made before it is drawn on the canvas of config,
+// or 1. image/jpeg cannot decode at a reduced DCT scale like libjpeg, but
+// averaging blocks of pixels right after decoding still spares the EXIF
+// rotation and the resampler from working on all of a 48-megapixel photo
+// that ends up 1080 pixels wide. The reduced image is never smaller than
+// the area it is drawn at, so the final resample still only shrinks it, and
+// it must land on exactly the same pixels of the canvas as the full one.
+// Only the bilinear and catmull-rom kernels read every source pixel;
+// nearest and approx-bilinear sample one or four per output pixel whatever
+// the source size, so reducing first would only cost time. -measure-quality
+// compares outputs with the full source, so it gets no reduction either.
+func reduceFactor(width, height int, config *Config) int {
+	if config.measureQuality {
+		return 1
+	}
+	full := computeLayout(width, height, config)
+	switch config.kernelFor(full.scale) {
+	case resampleBiLinear, resampleCatmullRom:
+	default:
+		return 1
+	}
+	for _, f := range []int{8, 4, 2} {
+		if full.scale*float64(f) > 1 {
+			continue
+		}
+		if computeLayout((width+f-1)/f, (height+f-1)/f, config).destRect == full.destRect {
+			return f
+		}
+	}
+	return 1
+}
+
+// reduceImage returns img f times smaller, rounded up, each pixel the
+// average of an f x f block, or of what is left of one along the right and
+// bottom edges. The YCbCr and grayscale images JPEGs decode to keep their
+// type and chroma subsampling, as do RGBA ones (converted CMYK JPEGs);
+// anything else is returned as is.
+func reduceImage(img image.Image, f int) image.Image {
+	b := img.Bounds()
+	if b.Min != (image.Point{}) {
+		return img
+	}
+	w, h := (b.Dx()+f-1)/f, (b.Dy()+f-1)/f
+	switch src := img.(type) {
+	case *image.YCbCr:
+		dst := image.NewYCbCr(image.Rect(0, 0, w, h), src.SubsampleRatio)
+		boxPlane(dst.Y, dst.YStride, w, h, src.Y, src.YStride, b.Dx(), b.Dy(), f, 1)
+		cw, ch := chromaSize(w, h, src.SubsampleRatio)
+		scw, sch := chromaSize(b.Dx(), b.Dy(), src.SubsampleRatio)
+		boxPlane(dst.Cb, dst.CStride, cw, ch, src.Cb, src.CStride, scw, sch, f, 1)
+		boxPlane(dst.Cr, dst.CStride, cw, ch, src.Cr, src.CStride, scw, sch, f, 1)
+		return dst
+	case *image.Gray:
+		dst := image.NewGray(image.Rect(0, 0, w, h))
+		boxPlane(dst.Pix, dst.Stride, w, h, src.Pix, src.Stride, b.Dx(), b.Dy(), f, 1)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		boxPlane(dst.Pix, dst.Stride, w, h, src.Pix, src.Stride, b.Dx(), b.Dy(), f, 4)
+		return dst
+	}
+	return img
+}
+
+// chromaSize returns the size of the chroma planes of a width x height
+// YCbCr image with subsampling ratio r.
+func chromaSize(width, height int, r image.YCbCrSubsampleRatio) (int, int) {
+	switch r {
+	case image.YCbCrSubsampleRatio422:
+		return (width + 1) / 2, height
+	case image.YCbCrSubsampleRatio420:
+		return (width + 1) / 2, (height + 1) / 2
+	case image.YCbCrSubsampleRatio440:
+		return width, (height + 1) / 2
+	case image.YCbCrSubsampleRatio411:
+		return (width + 3) / 4, height
+	case image.YCbCrSubsampleRatio410:
+		return (width + 3) / 4, (height + 1) / 2
+	}
+	return width, height
+}
+
+// boxPlane fills the dw x dh plane dst with the averages of the f x f
+// blocks of the sw x sh plane src, both with the given number of interleaved
+// channels. Blocks at the right and bottom edges of src may be partial.
+func boxPlane(dst []byte, dstStride, dw, dh int, src []byte, srcStride, sw, sh, f, channels int) {
+	sums := make([]int, dw*channels)
+	for dy := 0; dy < dh; dy++ {
+		clear(sums)
+		y0, y1 := dy*f, dy*f+f
+		if y1 > sh {
+			y1 = sh
+		}
+		for sy := y0; sy < y1; sy++ {
+			row := src[sy*srcStride : sy*srcStride+sw*channels]
+			for i, dx := 0, 0; i < len(row); dx++ {
+				end := i + f*channels
+				if end > len(row) {
+					end = len(row)
+				}
+				if channels == 1 {
+					sum := 0
+					for ; i < end; i++ {
+						sum += int(row[i])
+					}
+					sums[dx] += sum
+					continue
+				}
+				sum := sums[dx*channels : dx*channels+channels]
+				for ; i < end; i += channels {
+					for c := range sum {
+						sum[c] += int(row[i+c])
+					}
+				}
+			}
+		}
+		out := dst[dy*dstStride:]
+		for dx := 0; dx < dw; dx++ {
+			x1 := dx*f + f
+			if x1 > sw {
+				x1 = sw
+			}
+			n := (y1 - y0) * (x1 - dx*f)
+			for c := 0; c < channels; c++ {
+				out[dx*channels+c] = uint8((sums[dx*channels+c] + n/2) / n)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// reportThumbnailSize is the longest side of the previews inlined into
+	// report.html.
+	reportThumbnailSize = 240
+	// htmlInlineThumbnailLimit is the largest run for which previews are
+	// inlined as data URIs; bigger runs link the output files directly.
+	htmlInlineThumbnailLimit = 1000
+	// htmlPageSize is the number of images per report page.
+	htmlPageSize = 500
+)
+
+// htmlReport collects per-image results for the report.html gallery.
+type htmlReport struct {
+	results []processingResult
+}
+
+func (r *htmlReport) add(results []processingResult) {
+	r.results = append(r.results, results...)
+}
+
+type reportCard struct {
+	Name    string
+	Href    string
+	Src     template.URL
+	Details string
+}
+
+type reportFailure struct {
+	Name  string
+	Error string
+}
+
+type reportPage struct {
+	Title     string
+	Processed int
+	Failed    int
+	Page      int
+	Pages     int
+	Prev      string
+	Next      string
+	Last      string
+	Cards     []reportCard
+	Failures  []reportFailure
+	ShowFails bool
+}
+
+// write renders the report into outputFolder, split into pages of
+// htmlPageSize images, and returns the path of the first page.
+func (r *htmlReport) write(outputFolder string) (string, error) {
+	sortResults(r.results)
+
+	var cards []reportCard
+	var failures []reportFailure
+	for _, res := range r.results {
+		if res.skipped {
+			continue
+		}
+		if res.error != nil {
+			failures = append(failures, reportFailure{Name: res.filename, Error: res.error.Error()})
+			continue
+		}
+		cards = append(cards, newReportCard(res, outputFolder))
+	}
+
+	pages := max(1, (len(cards)+htmlPageSize-1)/htmlPageSize)
+	for page := 1; page <= pages; page++ {
+		lo := (page - 1) * htmlPageSize
+		hi := lo + htmlPageSize
+		if hi > len(cards) {
+			hi = len(cards)
+		}
+		p := reportPage{
+			Title:     "white_border_adder report",
+			Processed: len(cards),
+			Failed:    len(failures),
+			Page:      page,
+			Pages:     pages,
+			Cards:     cards[lo:hi],
+			ShowFails: page == pages,
+			Last:      reportPageName(pages),
+		}
+		if page > 1 {
+			p.Prev = reportPageName(page - 1)
+		}
+		if page < pages {
+			p.Next = reportPageName(page + 1)
+		}
+		if p.ShowFails {
+			p.Failures = failures
+		}
+
+		f, err := os.Create(filepath.Join(outputFolder, reportPageName(page)))
+		if err != nil {
+			return "", err
+		}
+		err = reportTemplate.Execute(f, p)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(outputFolder, reportPageName(1)), nil
+}
+
+func newReportCard(res processingResult, outputFolder string) reportCard {
+	href := filepath.Base(res.outputPath)
+	if rel, err := filepath.Rel(outputFolder, res.outputPath); err == nil {
+		href = rel
+	}
+	href = (&url.URL{Path: filepath.ToSlash(href)}).String()
+
+	src := template.URL(href)
+	if len(res.info.thumbnail) > 0 {
+		src = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(res.info.thumbnail))
+	}
+
+	details := []string{
+		fmt.Sprintf("%s → %s", formatDimensions(res.info.sourceWidth, res.info.sourceHeight),
+			formatDimensions(res.info.outputWidth, res.info.outputHeight)),
+	}
+	// -reproducible clears durations so the report does not change between
+	// runs.
+	if res.duration > 0 {
+		details = append(details, fmt.Sprintf("%.2f seconds", res.duration.Seconds()))
+	}
+	details = append(details, fmt.Sprintf("%s → %s", formatBytes(res.info.inputBytes), formatBytes(res.info.outputBytes)))
+
+	return reportCard{
+		Name:    res.filename,
+		Href:    href,
+		Src:     src,
+		Details: strings.Join(details, " · "),
+	}
+}
+
+func reportPageName(page int) string {
+	if page == 1 {
+		return "report.html"
+	}
+	return fmt.Sprintf("report-%d.html", page)
+}
+
+func formatDimensions(w, h int) string {
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}{{if gt .Pages 1}} ({{.Page}}/{{.Pages}}){{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; background: #f4f4f4; color: #222; }
+nav a { margin-right: 1em; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(200px, 1fr)); gap: 1em; }
+.card { background: #fff; padding: .5em; box-shadow: 0 1px 3px rgba(0,0,0,.2); text-align: center; }
+.card img { max-width: 100%; max-height: 200px; }
+.card .name { font-size: .8em; word-break: break-all; margin-top: .3em; }
+table { border-collapse: collapse; background: #fff; }
+td, th { border: 1px solid #ccc; padding: .3em .6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>✅ {{.Processed}} processed · ❌ {{.Failed}} failed{{if .Failed}}{{if not .ShowFails}} (<a href="{{.Last}}#failures">see failures</a>){{end}}{{end}}</p>
+{{if gt .Pages 1}}<nav>Page {{.Page}} of {{.Pages}}{{if .Prev}} <a href="{{.Prev}}">← previous</a>{{end}}{{if .Next}} <a href="{{.Next}}">next →</a>{{end}}</nav>{{end}}
+<div class="grid">
+{{range .Cards}}<div class="card"><a href="{{.Href}}"><img loading="lazy" src="{{.Src}}" alt="{{.Name}}" title="{{.Details}}"></a><div class="name" title="{{.Details}}">{{.Name}}</div></div>
+{{end}}</div>
+{{if and .ShowFails .Failures}}<h2 id="failures">Failures</h2>
+<table>
+<tr><th>File</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.Name}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/image/draw"
+)
+
+// Resampling kernels selectable with -resample, -resample-up and
+// -resample-down.
+const (
+	resampleNearest        = "nearest"
+	resampleApproxBiLinear = "approx-bilinear"
+	resampleBiLinear       = "bilinear"
+	resampleCatmullRom     = "catmull-rom"
+)
+
+var resamplers = map[string]draw.Interpolator{
+	resampleNearest:        draw.NearestNeighbor,
+	resampleApproxBiLinear: draw.ApproxBiLinear,
+	resampleBiLinear:       draw.BiLinear,
+	resampleCatmullRom:     draw.CatmullRom,
+}
+
+// validateResample checks the kernel names. -resample-up and -resample-down
+// may be empty, meaning -resample.
+func (c *Config) validateResample() error {
+	for _, k := range []struct{ flag, name string }{
+		{"-resample", c.resample},
+		{"-resample-up", c.resampleUp},
+		{"-resample-down", c.resampleDown},
+	} {
+		if k.name == "" && k.flag != "-resample" {
+			continue
+		}
+		if _, ok := resamplers[k.name]; !ok {
+			return fmt.Errorf("invalid %s value %q (expected nearest, approx-bilinear, bilinear or catmull-rom)", k.flag, k.name)
+		}
+	}
+	return nil
+}
+
+// kernelFor returns the kernel used to draw an image at the given scale:
+// -resample-up above 1, -resample-down below, and -resample otherwise or
+// when those are not set.
+func (c *Config) kernelFor(scale float64) string {
+	switch {
+	case scale > 1 && c.resampleUp != "":
+		return c.resampleUp
+	case scale < 1 && c.resampleDown != "":
+		return c.resampleDown
+	}
+	return c.resample
+}
+
+// splitResample reports whether upscales and downscales may use different
+// kernels, in which case the kernel is printed for every image.
+func (c *Config) splitResample() bool {
+	return c.resampleUp != "" || c.resampleDown != ""
+}
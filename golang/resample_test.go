@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// checkerboard returns a size x size board of cell x cell black and white
+// squares.
+func checkerboard(size, cell int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x/cell+y/cell)%2 == 0 {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+// graysIn counts the pixels of dest in img that are neither black nor
+// white.
+func graysIn(img image.Image, dest image.Rectangle) int {
+	n := 0
+	for y := dest.Min.Y; y < dest.Max.Y; y++ {
+		for x := dest.Min.X; x < dest.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			if r>>8 != 0 && r>>8 != 255 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestResampleUpAndDown(t *testing.T) {
+	config := testConfig()
+	config.landscapeVertBorder, config.landscapeHorizBorder = 0, 0
+	config.portraitVertBorder, config.portraitHorizBorder = 0, 0
+	config.borderMinPx = 0
+	config.resample = resampleApproxBiLinear
+	config.resampleUp, config.resampleDown = resampleNearest, resampleCatmullRom
+
+	var up imageInfo
+	canvas, _ := renderCanvas(checkerboard(60, 3), "up.png", config, &up)
+	if up.layout.scale != 2 || up.kernel != resampleNearest {
+		t.Fatalf("upscale at %v used %s, want 2 with nearest", up.layout.scale, up.kernel)
+	}
+	if n := graysIn(canvas, up.layout.destRect); n != 0 {
+		t.Errorf("nearest 2x upscale blurred %d pixels", n)
+	}
+	// Every source cell becomes an exact 6x6 block.
+	for y := 0; y < 120; y += 6 {
+		for x := 0; x < 120; x += 6 {
+			want := canvas.At(x, y)
+			for dy := 0; dy < 6; dy++ {
+				for dx := 0; dx < 6; dx++ {
+					if !near(canvas.At(x+dx, y+dy), want, 0) {
+						t.Fatalf("pixel (%d,%d) differs from its cell", x+dx, y+dy)
+					}
+				}
+			}
+		}
+	}
+
+	var down imageInfo
+	canvas, _ = renderCanvas(checkerboard(300, 5), "down.png", config, &down)
+	if down.layout.scale >= 1 || down.kernel != resampleCatmullRom {
+		t.Fatalf("downscale at %v used %s, want catmull-rom", down.layout.scale, down.kernel)
+	}
+	if graysIn(canvas, down.layout.destRect) == 0 {
+		t.Error("catmull-rom downscale kept only black and white pixels")
+	}
+
+	// Without -resample-up, the upscale falls back to -resample.
+	config.resampleUp = ""
+	var fallback imageInfo
+	canvas, _ = renderCanvas(checkerboard(60, 3), "up.png", config, &fallback)
+	if fallback.kernel != resampleApproxBiLinear || graysIn(canvas, fallback.layout.destRect) == 0 {
+		t.Errorf("upscale without -resample-up used %s and kept hard edges", fallback.kernel)
+	}
+}
+
+func TestScalerOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, kernel := range []string{resampleNearest, resampleApproxBiLinear, resampleBiLinear, resampleCatmullRom} {
+		for _, flag := range []string{"-resample", "-scaler"} {
+			config, _ := parseArgs(t, flag, kernel, dir)
+			if config.resample != kernel {
+				t.Fatalf("%s %s set -resample to %q", flag, kernel, config.resample)
+			}
+		}
+
+		config := testConfig()
+		config.landscapeVertBorder, config.landscapeHorizBorder = 0, 0
+		config.portraitVertBorder, config.portraitHorizBorder = 0, 0
+		config.borderMinPx = 0
+		config.resample = kernel
+		var info imageInfo
+		canvas, _ := renderCanvas(checkerboard(60, 3), "up.png", config, &info)
+		if info.kernel != kernel || info.layout.destRect.Dx() != 120 {
+			t.Fatalf("-scaler %s drew %v with %s", kernel, info.layout.destRect, info.kernel)
+		}
+		// Only nearest neighbour keeps the checkerboard's hard edges.
+		if grays := graysIn(canvas, info.layout.destRect); (grays == 0) != (kernel == resampleNearest) {
+			t.Errorf("-scaler %s left %d gray pixels", kernel, grays)
+		}
+	}
+
+	config := testConfig()
+	config.resample = "lanczos"
+	if err := config.validateResample(); err == nil {
+		t.Error("validateResample accepted -scaler lanczos")
+	}
+}
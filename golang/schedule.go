@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sort"
+)
+
+// Job scheduling orders accepted by -schedule.
+const (
+	scheduleFIFO = "fifo" // directory listing order
+	scheduleLJF  = "ljf"  // largest files first, so big images don't finish last
+	scheduleSJF  = "sjf"  // smallest files first
+)
+
+// scheduleOrder returns the order in which the input files should be
+// queued. File size stands in for the work an image needs; ties keep the
+// listing order.
+func scheduleOrder(files []inputFile, schedule string) []int {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	if schedule == scheduleFIFO {
+		return order
+	}
+
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		if info, err := file.entry.Info(); err == nil {
+			sizes[i] = info.Size()
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if schedule == scheduleSJF {
+			return sizes[order[a]] < sizes[order[b]]
+		}
+		return sizes[order[a]] > sizes[order[b]]
+	})
+	return order
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpTarget is an sftp://[user@]host[:port]/path output destination.
+type sftpTarget struct {
+	user string
+	host string // with port
+	dir  string
+}
+
+func parseSFTPTarget(raw string) (*sftpTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "sftp" || u.Host == "" {
+		return nil, fmt.Errorf("expected sftp://[user@]host[:port]/path, got %q", raw)
+	}
+	t := &sftpTarget{host: u.Host, dir: path.Clean("/" + u.Path)}
+	if u.Port() == "" {
+		t.host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if u.User != nil {
+		t.user = u.User.Username()
+	} else if cur, err := user.Current(); err == nil {
+		t.user = cur.Username
+	}
+	if t.user == "" {
+		return nil, fmt.Errorf("no user in %q", raw)
+	}
+	return t, nil
+}
+
+func (t *sftpTarget) String() string {
+	return "sftp://" + t.user + "@" + t.host + t.dir
+}
+
+// join returns the URI of a file in the target folder. Outputs are named by
+// their URI so the manifest cannot be mistaken for local paths.
+func (t *sftpTarget) join(name string) string {
+	return "sftp://" + t.user + "@" + t.host + path.Join(t.dir, name)
+}
+
+// remotePath returns the path on the server of a URI returned by join.
+func (t *sftpTarget) remotePath(uri string) string {
+	return strings.TrimPrefix(uri, "sftp://"+t.user+"@"+t.host)
+}
+
+// validateSFTP rejects the options that need to read outputs back or write
+// next to them, which an SFTP destination cannot offer.
+func (c *Config) validateSFTP() error {
+	if c.sftp == nil {
+		return nil
+	}
+	switch {
+	case c.transferConcurrency < 1:
+		return fmt.Errorf("-transfer-concurrency must be at least 1, got %d", c.transferConcurrency)
+	case c.verifyOutputs || c.measureQuality:
+		return fmt.Errorf("-verify-outputs and -measure-quality cannot read SFTP outputs back")
+	case c.htmlReport || c.sidecarsJSON || c.qaSample > 0:
+		return fmt.Errorf("-html-report, -sidecars-json and -qa-sample need a local -output")
+	case c.diffSettings:
+		return fmt.Errorf("-diff-settings needs a local -output")
+	case c.skipExisting:
+		return fmt.Errorf("-skip-existing needs a local -output")
+	case c.fsync:
+		return fmt.Errorf("-fsync has no effect on SFTP outputs; uploads are renamed into place once complete")
+	case c.backend == backendVips:
+		return fmt.Errorf("-backend vips needs a local -output")
+	}
+	return nil
+}
+
+// remoteOutput receives every output when -output is an SFTP destination;
+// it is nil otherwise.
+var remoteOutput *sftpPool
+
+// sftpPool is a fixed set of SFTP connections shared by the workers, so
+// uploads are bounded by -transfer-concurrency rather than -workers.
+type sftpPool struct {
+	target  *sftpTarget
+	clients chan *sftp.Client
+	conns   []*ssh.Client
+
+	mu      sync.Mutex
+	madeDir map[string]bool
+}
+
+// dialSFTPPool opens n connections to target, authenticating with keyFile
+// or, if it is empty, the SSH agent. Host keys are checked against
+// knownHostsFile.
+func dialSFTPPool(target *sftpTarget, n int, keyFile, knownHostsFile string) (*sftpPool, error) {
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts: %v", err)
+	}
+	auth, err := sftpAuth(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:            target.user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeys,
+	}
+
+	p := &sftpPool{target: target, clients: make(chan *sftp.Client, n), madeDir: make(map[string]bool)}
+	for i := 0; i < n; i++ {
+		conn, err := ssh.Dial("tcp", target.host, sshConfig)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("error connecting to %s: %v", target.host, err)
+		}
+		p.conns = append(p.conns, conn)
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("error starting SFTP on %s: %v", target.host, err)
+		}
+		p.clients <- client
+	}
+	return p, nil
+}
+
+// sftpAuth returns the key file's signer, or the SSH agent's keys.
+func sftpAuth(keyFile string) (ssh.AuthMethod, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSH key: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing SSH key %s (for keys with a passphrase, add them to ssh-agent instead): %v", keyFile, err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("no SSH agent running (SSH_AUTH_SOCK is not set); use -sftp-key")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to SSH agent: %v", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+}
+
+// probe creates the destination folder and checks it is writable, so a bad
+// destination fails once up front.
+func (p *sftpPool) probe() error {
+	client := <-p.clients
+	defer func() { p.clients <- client }()
+
+	if err := client.MkdirAll(p.target.dir); err != nil {
+		return fmt.Errorf("creating %s: %v", p.target.dir, err)
+	}
+	probe := path.Join(p.target.dir, ".wbi-probe")
+	f, err := client.Create(probe)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("probe"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := client.Remove(probe); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// upload writes data to the file named by uri. It is written under a
+// temporary name and renamed into place, so the server never shows a
+// partial image. Missing folders are created.
+func (p *sftpPool) upload(uri string, data []byte) error {
+	client := <-p.clients
+	defer func() { p.clients <- client }()
+
+	remote := p.target.remotePath(uri)
+	dir := path.Dir(remote)
+	p.mu.Lock()
+	made := p.madeDir[dir]
+	p.mu.Unlock()
+	if !made {
+		if err := client.MkdirAll(dir); err != nil {
+			return fmt.Errorf("creating %s: %v", dir, err)
+		}
+		p.mu.Lock()
+		p.madeDir[dir] = true
+		p.mu.Unlock()
+	}
+
+	var suffix [6]byte
+	rand.Read(suffix[:])
+	tmp := path.Join(dir, ".wbi-upload-"+hex.EncodeToString(suffix[:]))
+	f, err := client.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating %s: %v", tmp, err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// posix-rename@openssh.com replaces an existing file; plain
+		// SFTP rename refuses to.
+		if err = client.PosixRename(tmp, remote); err != nil {
+			client.Remove(remote)
+			err = client.Rename(tmp, remote)
+		}
+	}
+	if err != nil {
+		client.Remove(tmp)
+		return fmt.Errorf("writing %s: %v", remote, err)
+	}
+	return nil
+}
+
+func (p *sftpPool) close() {
+	for len(p.clients) > 0 {
+		(<-p.clients).Close()
+	}
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// defaultKnownHosts returns ~/.ssh/known_hosts.
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
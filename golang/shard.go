@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+)
+
+// shardValue is the -shard flag, "index/count", selecting which part of a
+// run split across several machines this process handles.
+type shardValue struct {
+	index int
+	count int
+}
+
+func (v *shardValue) String() string {
+	if v.count == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", v.index, v.count)
+}
+
+func (v *shardValue) Set(s string) error {
+	var index, count int
+	if _, err := fmt.Sscanf(s, "%d/%d", &index, &count); err != nil || fmt.Sprintf("%d/%d", index, count) != s {
+		return fmt.Errorf("expected index/count, e.g. 0/4")
+	}
+	if count < 1 || index < 0 || index >= count {
+		return fmt.Errorf("shard index must be in [0, %d)", count)
+	}
+	v.index, v.count = index, count
+	return nil
+}
+
+// owns reports whether the image at relPath (relative to the input folder)
+// belongs to this shard. Ownership depends only on the path, so adding files
+// never moves existing ones to another shard.
+func (v shardValue) owns(relPath string) bool {
+	if v.count <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(filepath.ToSlash(relPath)))
+	return h.Sum64()%uint64(v.count) == uint64(v.index)
+}
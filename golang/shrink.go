@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+// autoShrinkStep is how much -auto-shrink lowers the JPEG quality at a time.
+const autoShrinkStep = 5
+
+// oversized reports whether an output of outputBytes is more than
+// -size-warning times larger than its input.
+func (c *Config) oversized(outputBytes, inputBytes int64) bool {
+	return c.sizeWarning > 0 && inputBytes > 0 && float64(outputBytes) > c.sizeWarning*float64(inputBytes)
+}
+
+// shrunkOutput is the result of autoShrink.
+type shrunkOutput struct {
+	data []byte
+	path string
+	// oversized is set when the first encode exceeded -size-warning.
+	oversized bool
+	// change describes the adjustment made, if any.
+	change string
+}
+
+// autoShrink encodes canvas for path and, if the result is more than
+// -size-warning times larger than the input, encodes it again: JPEGs at
+// lower qualities, down to -auto-shrink-min-quality, and opaque PNGs as
+// JPEG, renaming the output, when that is smaller. It returns a nil result for outputs it does not
+// handle, which are encoded as usual.
+func autoShrink(canvas image.Image, path string, inputBytes int64, config *Config) (*shrunkOutput, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	isPNG := ext == ".png"
+	switch {
+	case isPNG && !config.pngPalette:
+	case ext == ".jpg" || ext == ".jpeg":
+	default:
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if isPNG {
+		err = config.encodePNG(&buf, canvas)
+	} else {
+		err = config.encodeJPEG(&buf, canvas, config.jpegQuality)
+	}
+	if err != nil {
+		return nil, err
+	}
+	first := buf.Bytes()
+	out := &shrunkOutput{data: first, path: path}
+	if !config.oversized(int64(buf.Len()), inputBytes) {
+		return out, nil
+	}
+	out.oversized = true
+
+	minQuality := config.autoShrinkMinQuality
+	if minQuality > config.jpegQuality {
+		minQuality = config.jpegQuality
+	}
+	quality := config.jpegQuality
+	if isPNG {
+		if o, ok := canvas.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			return out, nil
+		}
+	} else {
+		if quality == minQuality {
+			return out, nil
+		}
+		quality = max(quality-autoShrinkStep, minQuality)
+	}
+
+	for {
+		var jbuf bytes.Buffer
+		if err := config.encodeJPEG(&jbuf, canvas, quality); err != nil {
+			return nil, err
+		}
+		out.data = jbuf.Bytes()
+		if !config.oversized(int64(jbuf.Len()), inputBytes) || quality == minQuality {
+			break
+		}
+		quality = max(quality-autoShrinkStep, minQuality)
+	}
+
+	if isPNG {
+		// Flat graphics can compress better as PNG than as any JPEG.
+		if len(out.data) >= len(first) {
+			out.data = first
+			return out, nil
+		}
+		out.path = strings.TrimSuffix(path, filepath.Ext(path)) + ".jpg"
+		out.change = fmt.Sprintf("PNG → JPEG quality %d", quality)
+	} else {
+		out.change = fmt.Sprintf("quality %d → %d", config.jpegQuality, quality)
+	}
+	return out, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Version is set at build time through -ldflags "-X main.Version=...".
+var Version = "dev"
+
+// sidecarSchema is bumped whenever a field of sidecar changes meaning or is
+// removed; new fields may be added without a bump.
+const sidecarSchema = 1
+
+// sidecar is the JSON document -sidecars-json writes next to each output as
+// <output>.json.
+type sidecar struct {
+	Schema      int            `json:"schema"`
+	Tool        string         `json:"tool"`
+	Version     string         `json:"version"`
+	Source      sidecarImage   `json:"source"`
+	Output      sidecarImage   `json:"output"`
+	Scale       float64        `json:"scale"`
+	Orientation string         `json:"orientation"`
+	Borders     sidecarBorders `json:"borders"`
+	Fingerprint string         `json:"fingerprint"`
+	Settings    renderSettings `json:"settings"`
+	DurationMs  float64        `json:"duration_ms,omitempty"`
+}
+
+type sidecarImage struct {
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// sidecarBorders are the border widths in pixels between the image and
+// each edge of the canvas.
+type sidecarBorders struct {
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+}
+
+func sidecarPath(outputPath string) string {
+	return outputPath + ".json"
+}
+
+func newSidecar(r processingResult, config *Config) sidecar {
+	settings := config.renderSettings()
+	l := r.info.layout
+	orientation := "portrait"
+	if l.isLandscape {
+		orientation = "landscape"
+	}
+	return sidecar{
+		Schema:  sidecarSchema,
+		Tool:    markerKeyword,
+		Version: Version,
+		Source: sidecarImage{
+			Path:   r.inputPath,
+			Width:  r.info.sourceWidth,
+			Height: r.info.sourceHeight,
+			Bytes:  r.info.inputBytes,
+		},
+		Output: sidecarImage{
+			Path:   r.outputPath,
+			Width:  r.info.outputWidth,
+			Height: r.info.outputHeight,
+			Bytes:  r.info.outputBytes,
+		},
+		Scale:       l.scale,
+		Orientation: orientation,
+		Borders: sidecarBorders{
+			Top:    l.destRect.Min.Y,
+			Right:  r.info.outputWidth - l.destRect.Max.X,
+			Bottom: r.info.outputHeight - l.destRect.Max.Y,
+			Left:   l.destRect.Min.X,
+		},
+		Fingerprint: settings.fingerprint(),
+		Settings:    settings,
+		DurationMs:  float64(r.duration.Microseconds()) / 1000,
+	}
+}
+
+// writeSidecar atomically writes the sidecar of a successful result: it is
+// written to a temporary file in the output folder and renamed into place,
+// so readers never see a partial document.
+func writeSidecar(r processingResult, config *Config) error {
+	data, err := json.MarshalIndent(newSidecar(r, config), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := sidecarPath(r.outputPath)
+	openFiles.acquire()
+	defer openFiles.release()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".wbi-sidecar-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil && config.fsync {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// removeStaleSidecar deletes the sidecar next to outputPath if this tool
+// wrote it, so a failed or sidecar-less re-run does not leave behind a
+// document describing an old output.
+func removeStaleSidecar(outputPath string) error {
+	path := sidecarPath(outputPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var s sidecar
+	if json.Unmarshal(data, &s) != nil || s.Tool != markerKeyword {
+		return nil
+	}
+	return os.Remove(path)
+}
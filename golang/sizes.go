@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sizePresets are the canvas sizes selectable by name with -size.
+var sizePresets = map[string][2]int{
+	"ig-square":   {1080, 1080},
+	"ig-portrait": {1080, 1350},
+	"ig-story":    {1080, 1920},
+	"4x5":         {1080, 1350},
+	"16x9":        {1920, 1080},
+}
+
+// sizeList is the -size flag, which may be given more than once to border
+// every image once per size.
+type sizeList []string
+
+func (s *sizeList) String() string { return strings.Join(*s, ",") }
+
+func (s *sizeList) Set(value string) error {
+	if _, err := parseSize(value); err != nil {
+		return err
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// parseSize returns the canvas size named by value, or given as WxH.
+func parseSize(value string) ([2]int, error) {
+	if size, ok := sizePresets[value]; ok {
+		return size, nil
+	}
+	w, h, found := strings.Cut(strings.ToLower(value), "x")
+	width, werr := strconv.Atoi(w)
+	height, herr := strconv.Atoi(h)
+	if !found || werr != nil || herr != nil || width <= 0 || height <= 0 {
+		names := make([]string, 0, len(sizePresets))
+		for n := range sizePresets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return [2]int{}, fmt.Errorf("invalid -size value %q (expected WxH or one of %v)", value, names)
+	}
+	return [2]int{width, height}, nil
+}
+
+// applySize sets -width and -height to the single -size, except those
+// given explicitly, which win over it. Several sizes are kept in the
+// config's sizes instead, so they cannot be combined with either.
+func applySize(flagSet *flag.FlagSet, sizes sizeList, config *Config) error {
+	if len(sizes) > 1 {
+		explicit := false
+		flagSet.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "width" || f.Name == "height" })
+		if explicit {
+			return fmt.Errorf("-width and -height cannot be combined with several -size values")
+		}
+		config.sizes = config.sizes[:0]
+		for _, value := range sizes {
+			size, _ := parseSize(value)
+			config.sizes = append(config.sizes, size)
+		}
+		config.targetWidth, config.targetHeight = config.sizes[0][0], config.sizes[0][1]
+		return nil
+	}
+	size, _ := parseSize(sizes[0])
+	return applySettings(flagSet, map[string]string{
+		"width":  strconv.Itoa(size[0]),
+		"height": strconv.Itoa(size[1]),
+	}, "-size "+sizes[0], nil)
+}
+
+// validateSizes checks that several -size values are only combined with
+// runs that name their outputs themselves.
+func (c *Config) validateSizes() error {
+	if len(c.sizes) < 2 {
+		return nil
+	}
+	switch {
+	case c.jobsPath != "":
+		return fmt.Errorf("several -size values cannot be combined with -jobs, whose spec names each output")
+	case c.tune:
+		return fmt.Errorf("several -size values cannot be combined with -tune")
+	}
+	return nil
+}
+
+// sizeConfigs returns a copy of config per -size value, with that canvas,
+// or nil for a run with a single size.
+func (c *Config) sizeConfigs() []*Config {
+	if len(c.sizes) < 2 {
+		return nil
+	}
+	configs := make([]*Config, len(c.sizes))
+	for i, size := range c.sizes {
+		sc := *c
+		sc.targetWidth, sc.targetHeight = size[0], size[1]
+		configs[i] = &sc
+	}
+	return configs
+}
+
+// sizeJobs returns job once per config of sizeConfigs, each with its
+// output named after its canvas size and all sharing one decode of the
+// input, or just job without sizeConfigs. SVGs are rasterized for each
+// canvas, so they are decoded per size.
+func sizeJobs(job imageJob, configs []*Config) []imageJob {
+	if configs == nil {
+		return []imageJob{job}
+	}
+	var decoded *sharedDecode
+	if format, _ := inputFormatFor(filepath.Ext(job.inputPath)); format != "svg" {
+		decoded = &sharedDecode{users: len(configs)}
+	}
+	jobs := make([]imageJob, len(configs))
+	for i, cfg := range configs {
+		sized := job
+		sized.size = fmt.Sprintf("%dx%d", cfg.targetWidth, cfg.targetHeight)
+		ext := filepath.Ext(job.outputPath)
+		sized.outputPath = strings.TrimSuffix(job.outputPath, ext) + "_" + sized.size + ext
+		sized.sizeIndex, sized.config, sized.decoded = i, cfg, decoded
+		jobs[i] = sized
+	}
+	return jobs
+}
+
+// sharedDecode lets the jobs bordering one input at several -size values
+// decode it once: the first to need it decodes it, the others wait for
+// that, and it is dropped once every job is done.
+type sharedDecode struct {
+	once  sync.Once
+	img   image.Image
+	info  imageInfo
+	err   error
+	mu    sync.Mutex
+	users int
+}
+
+// decode returns the decoded input of job and fills in what decodeInput
+// records in info.
+func (d *sharedDecode) decode(job imageJob, config *Config, info *imageInfo) (image.Image, error) {
+	d.once.Do(func() {
+		d.img, d.err = decodeInput(job.inputPath, job.page, config, &d.info)
+	})
+	info.format, info.inputBytes = d.info.format, d.info.inputBytes
+	info.sourceWidth, info.sourceHeight = d.info.sourceWidth, d.info.sourceHeight
+	return d.img, d.err
+}
+
+// release records that one of the jobs is done with the image.
+func (d *sharedDecode) release() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.users--; d.users == 0 {
+		d.img = nil
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSizePresets(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	writeImage(t, input, fill(90, 60, color.RGBA{120, 60, 30, 255}))
+
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{"ig-square", 1080, 1080},
+		{"ig-portrait", 1080, 1350},
+		{"ig-story", 1080, 1920},
+		{"4x5", 1080, 1350},
+		{"16x9", 1920, 1080},
+	}
+	if len(tests) != len(sizePresets) {
+		t.Fatalf("%d presets tested, %d defined", len(tests), len(sizePresets))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _ := parseArgs(t, "-size", tt.name, dir)
+			if config.targetWidth != tt.width || config.targetHeight != tt.height {
+				t.Fatalf("-size %s set %dx%d, want %dx%d", tt.name, config.targetWidth, config.targetHeight, tt.width, tt.height)
+			}
+			job := imageJob{inputPath: input, outputPath: filepath.Join(dir, tt.name+".jpg")}
+			if _, err := processImage(context.Background(), job, config); err != nil {
+				t.Fatal(err)
+			}
+			b := readImage(t, job.outputPath).Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("output is %v, want %dx%d", b.Size(), tt.width, tt.height)
+			}
+		})
+	}
+
+	// Explicit -width and -height win, whichever comes first.
+	config, _ := parseArgs(t, "-width", "800", "-size", "ig-story", dir)
+	if config.targetWidth != 800 || config.targetHeight != 1920 {
+		t.Errorf("-width 800 -size ig-story set %dx%d, want 800x1920", config.targetWidth, config.targetHeight)
+	}
+	config, _ = parseArgs(t, "-size", "16x9", "-height", "900", dir)
+	if config.targetWidth != 1920 || config.targetHeight != 900 {
+		t.Errorf("-size 16x9 -height 900 set %dx%d, want 1920x900", config.targetWidth, config.targetHeight)
+	}
+}
+
+func TestSeveralSizes(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "a.jpg"), fill(90, 60, color.RGBA{120, 60, 30, 255}))
+	writeImage(t, filepath.Join(dir, "b.png"), fill(40, 70, color.RGBA{30, 60, 120, 255}))
+
+	config, _ := parseArgs(t, "-size", "120x90", "-size", "60x80", dir)
+	if len(config.sizes) != 2 {
+		t.Fatalf("two -size values kept %v", config.sizes)
+	}
+	config.maxWorkers = 2
+	results := 0
+	captureStdout(t, func() {
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			results++
+			if r.error != nil {
+				t.Errorf("%s: %v", r.outputPath, r.error)
+			}
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	if results != 4 {
+		t.Errorf("got %d results, want one per input and size", results)
+	}
+
+	for _, tt := range []struct {
+		output        string
+		width, height int
+	}{
+		{"bordered_a_120x90.jpg", 120, 90},
+		{"bordered_a_60x80.jpg", 60, 80},
+		{"bordered_b_120x90.png", 120, 90},
+		{"bordered_b_60x80.png", 60, 80},
+	} {
+		b := readImage(t, filepath.Join(dir, "bordered_images", tt.output)).Bounds()
+		if b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("%s is %v, want %dx%d", tt.output, b.Size(), tt.width, tt.height)
+		}
+	}
+}
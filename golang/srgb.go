@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// wideGamut describes an RGB color space -convert-srgb converts from: the
+// D50-adapted colorants of its ICC profile and its tone curve.
+type wideGamut struct {
+	name      string
+	colorants [3][3]float64 // rXYZ, gXYZ, bXYZ
+	decode    func(v float64) float64
+}
+
+// srgbColorants are the rXYZ, gXYZ and bXYZ tags of sRGB profiles, as
+// written by srgbICCProfile.
+var srgbColorants = [3][3]float64{
+	{0.4361, 0.2225, 0.0139},
+	{0.3851, 0.7169, 0.0971},
+	{0.1431, 0.0606, 0.7141},
+}
+
+var wideGamuts = []wideGamut{
+	{
+		name: "Adobe RGB",
+		colorants: [3][3]float64{
+			{0.6097, 0.3111, 0.0195},
+			{0.2053, 0.6257, 0.0609},
+			{0.1492, 0.0632, 0.7446},
+		},
+		decode: func(v float64) float64 { return math.Pow(v, 563.0/256) },
+	},
+	{
+		name: "Display P3",
+		colorants: [3][3]float64{
+			{0.5151, 0.2412, -0.0011},
+			{0.2920, 0.6922, 0.0419},
+			{0.1571, 0.0666, 0.7841},
+		},
+		decode: srgbDecode,
+	},
+}
+
+// colorantTolerance absorbs the rounding of colorants written by
+// different profile makers.
+const colorantTolerance = 0.003
+
+// detectWideGamut returns the wide-gamut space an ICC profile describes,
+// recognized by its colorants, or nil for sRGB, other spaces and profiles
+// it cannot read.
+func detectWideGamut(profile []byte) *wideGamut {
+	if len(profile) < 132 || string(profile[12:16]) != "mntr" && string(profile[12:16]) != "scnr" || string(profile[16:20]) != "RGB " {
+		return nil
+	}
+	var colorants [3][3]float64
+	found := 0
+	channel := map[string]int{"rXYZ": 0, "gXYZ": 1, "bXYZ": 2}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(profile); i++ {
+		entry := profile[132+12*i:]
+		c, ok := channel[string(entry[:4])]
+		offset, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if !ok || size < 20 || offset+20 > len(profile) || string(profile[offset:offset+4]) != "XYZ " {
+			continue
+		}
+		for j := range colorants[c] {
+			colorants[c][j] = float64(int32(binary.BigEndian.Uint32(profile[offset+8+4*j:]))) / 65536
+		}
+		found++
+	}
+	if found != 3 {
+		return nil
+	}
+	for i := range wideGamuts {
+		if colorantsClose(colorants, wideGamuts[i].colorants) {
+			return &wideGamuts[i]
+		}
+	}
+	return nil
+}
+
+func colorantsClose(a, b [3][3]float64) bool {
+	for i := range a {
+		for j := range a[i] {
+			if math.Abs(a[i][j]-b[i][j]) > colorantTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// srgbDecode is the sRGB tone curve, from encoded to linear light.
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// srgbEncode is the inverse of srgbDecode.
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// toSRGB returns img converted from the color space g to sRGB, with
+// colors outside the sRGB gamut clipped. Both spaces share the D50
+// connection space of their profiles, so the conversion is the source
+// colorants followed by the inverse of the sRGB ones, in linear light.
+func toSRGB(img image.Image, g *wideGamut) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+
+	m := mul3(inverse3(transpose3(srgbColorants)), transpose3(g.colorants))
+	var decode [256]float64
+	for i := range decode {
+		decode[i] = g.decode(float64(i) / 255)
+	}
+	const encodeSteps = 4096
+	var encode [encodeSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(255 * srgbEncode(float64(i)/encodeSteps)))
+	}
+	toByte := func(v float64) uint8 {
+		return encode[int(math.Round(math.Max(0, math.Min(1, v))*encodeSteps))]
+	}
+
+	for i := 0; i < len(out.Pix); i += 4 {
+		px := out.Pix[i : i+4 : i+4]
+		a := px[3]
+		if a == 0 {
+			continue
+		}
+		// The tone curves apply to straight, not premultiplied, color.
+		var rgb [3]float64
+		for c := range rgb {
+			v := px[c]
+			if a != 255 {
+				v = uint8((int(v)*255 + int(a)/2) / int(a))
+			}
+			rgb[c] = decode[v]
+		}
+		for c := range rgb {
+			v := toByte(m[c][0]*rgb[0] + m[c][1]*rgb[1] + m[c][2]*rgb[2])
+			if a != 255 {
+				v = uint8((int(v)*int(a) + 127) / 255)
+			}
+			px[c] = v
+		}
+	}
+	return out
+}
+
+// transpose3 turns a list of colorant columns into a matrix mapping linear
+// RGB to XYZ.
+func transpose3(m [3][3]float64) [3][3]float64 {
+	var t [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			t[j][i] = m[i][j]
+		}
+	}
+	return t
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var p [3][3]float64
+	for i := range a {
+		for j := range b[0] {
+			for k := range b {
+				p[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return p
+}
+
+func inverse3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var inv [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of m[j][i], for the adjugate.
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]) / det
+		}
+	}
+	return inv
+}
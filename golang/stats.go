@@ -0,0 +1,403 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// histogramSubBuckets is the number of linear buckets per power of two
+	// in durationHistogram, giving percentiles within about 6%.
+	histogramSubBuckets = 16
+	// histogramBuckets covers durations up to 2^40µs (about 12 days).
+	histogramBuckets = 41 * histogramSubBuckets
+	// topImages is the number of fastest and slowest images the -verbose
+	// summary lists.
+	topImages = 3
+)
+
+// processingStats aggregates results as they stream in. Memory use does not
+// grow with the number of images: per-image data only goes to the report and
+// manifest sinks, and only the few numbers the summary prints are kept per
+// batch.
+type processingStats struct {
+	totalImages   atomic.Int64
+	failedImages  atomic.Int64
+	totalDuration atomic.Int64 // nanoseconds
+	inputBytes    atomic.Int64
+	outputBytes   atomic.Int64
+	oversized     atomic.Int64
+	autoShrunk    atomic.Int64
+	converted     atomic.Int64
+	skipped       atomic.Int64
+	duplicates    atomic.Int64
+
+	mu        sync.Mutex
+	fastest   topDurations
+	slowest   topDurations
+	durations durationHistogram
+	// Quality metrics of the images measured with -measure-quality.
+	measuredImages int
+	sumPSNR        float64
+	minPSNR        float64
+	sumSSIM        float64
+	minSSIM        float64
+	// tail holds the most recent completion times, up to its capacity, to
+	// measure how long the run spent finishing its last few images.
+	tail []time.Time
+	// batches holds what the summary prints about each batch, in the
+	// order they finished.
+	batches []batchSummary
+	// planned holds the first maxPlanLog outputs of a -dry-run.
+	planned      []plannedOutput
+	omittedPlans int
+	// progress is the live progress line, nil with -verbose.
+	progress *progressLine
+	// json writes the records of -log-format json, in place of the log
+	// lines and summary; nil for text.
+	json *json.Encoder
+	// verbose lists the topImages fastest and slowest images in the
+	// summary, after the fastest and slowest one.
+	verbose bool
+}
+
+// batchSummary is what the summary prints about one batch.
+type batchSummary struct {
+	batchID    int
+	successful int
+	total      int
+	duration   time.Duration
+}
+
+func newProcessingStats(tailSize int) *processingStats {
+	return &processingStats{
+		fastest: topDurations{n: topImages, fastest: true},
+		slowest: topDurations{n: topImages},
+		tail:    make([]time.Time, 0, tailSize),
+	}
+}
+
+func (ps *processingStats) addResult(br batchResult) {
+	var successful int
+	for _, result := range br.results {
+		if result.duplicateOf != "" {
+			ps.duplicates.Add(1)
+			continue
+		}
+		if result.skipped {
+			ps.skipped.Add(1)
+			continue
+		}
+		if result.error != nil {
+			ps.failedImages.Add(1)
+			continue
+		}
+		successful++
+		ps.totalImages.Add(1)
+		ps.totalDuration.Add(int64(result.duration))
+		ps.inputBytes.Add(result.info.inputBytes)
+		ps.outputBytes.Add(result.info.outputBytes)
+		if result.info.oversized {
+			ps.oversized.Add(1)
+		}
+		if result.info.autoShrink != "" {
+			ps.autoShrunk.Add(1)
+		}
+		if outputFormatFor(result.outputPath) != result.info.format {
+			ps.converted.Add(1)
+		}
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.batches = append(ps.batches, batchSummary{
+		batchID:    br.batchID,
+		successful: successful,
+		total:      len(br.results),
+		duration:   br.endTime.Sub(br.startTime),
+	})
+
+	for _, result := range br.results {
+		if result.error != nil || result.skipped {
+			continue
+		}
+
+		ps.durations.add(result.duration)
+
+		if result.info.planned {
+			if len(ps.planned) < maxPlanLog {
+				ps.planned = append(ps.planned, plannedOutput{
+					filename:   result.filename,
+					outputPath: result.outputPath,
+					layout:     result.info.layout,
+				})
+			} else {
+				ps.omittedPlans++
+			}
+		}
+
+		if result.info.measured {
+			if ps.measuredImages == 0 || result.info.qualityPSNR < ps.minPSNR {
+				ps.minPSNR = result.info.qualityPSNR
+			}
+			if ps.measuredImages == 0 || result.info.qualitySSIM < ps.minSSIM {
+				ps.minSSIM = result.info.qualitySSIM
+			}
+			ps.measuredImages++
+			ps.sumPSNR += result.info.qualityPSNR
+			ps.sumSSIM += result.info.qualitySSIM
+		}
+
+		if cap(ps.tail) > 0 {
+			if len(ps.tail) == cap(ps.tail) {
+				ps.tail = append(ps.tail[:0], ps.tail[1:]...)
+			}
+			ps.tail = append(ps.tail, result.finishedAt)
+		}
+
+		// Only keep what the summary prints, not the image data.
+		summary := processingResult{filename: result.filename, duration: result.duration}
+		ps.fastest.add(summary)
+		ps.slowest.add(summary)
+	}
+}
+
+func (ps *processingStats) printSummary(elapsed time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.json != nil {
+		ps.printSummaryJSON(elapsed)
+		return
+	}
+
+	fmt.Printf("\nTotal execution time: %.2f seconds\n", elapsed.Seconds())
+
+	totalImages := ps.totalImages.Load()
+	fmt.Printf("\n📊 === Processing Summary ===\n")
+	fmt.Printf("✅ Total images processed: %d\n", totalImages)
+	fmt.Printf("❌ Failed images: %d\n", ps.failedImages.Load())
+	if n := ps.skipped.Load(); n > 0 {
+		fmt.Printf("⏭️  Skipped images (output exists): %d\n", n)
+	}
+	if n := ps.duplicates.Load(); n > 0 {
+		fmt.Printf("🔁 Duplicates skipped (same content as an earlier image): %d\n", n)
+	}
+
+	if totalImages > 0 {
+		avgDuration := time.Duration(ps.totalDuration.Load()) / time.Duration(totalImages)
+		fmt.Printf("⏱️  Average processing time: %.2f seconds\n", avgDuration.Seconds())
+		fastest, slowest := ps.fastest.top(), ps.slowest.top()
+		fmt.Printf("🚀 Fastest image: %s (%.2f seconds)\n", fastest.filename, fastest.duration.Seconds())
+		fmt.Printf("🐢 Slowest image: %s (%.2f seconds)\n", slowest.filename, slowest.duration.Seconds())
+		if ps.verbose && ps.fastest.Len() > 1 {
+			fmt.Printf("🚀 Fastest %d images: %s\n", ps.fastest.Len(), ps.fastest.String())
+			fmt.Printf("🐢 Slowest %d images: %s\n", ps.slowest.Len(), ps.slowest.String())
+		}
+		fmt.Printf("📐 Percentiles: p50 %.2f, p90 %.2f, p99 %.2f seconds\n",
+			ps.percentile(0.50).Seconds(), ps.percentile(0.90).Seconds(), ps.percentile(0.99).Seconds())
+		if in, out := ps.inputBytes.Load(), ps.outputBytes.Load(); in > 0 && out > 0 {
+			fmt.Printf("💾 Size: %s in → %s out (%+.1f%%)\n",
+				formatBytes(in), formatBytes(out), float64(out-in)/float64(in)*100)
+		}
+		if n := ps.oversized.Load(); n > 0 {
+			fmt.Printf("📏 Outputs over -size-warning: %d (auto-shrunk: %d)\n", n, ps.autoShrunk.Load())
+		}
+		if n := ps.converted.Load(); n > 0 {
+			fmt.Printf("🔄 Converted to another format: %d image(s)\n", n)
+		}
+	}
+
+	if len(ps.planned) > 0 {
+		ps.printPlan()
+	}
+
+	if ps.measuredImages > 0 {
+		n := float64(ps.measuredImages)
+		fmt.Printf("🔬 Quality: PSNR mean %.2f dB (min %.2f), SSIM mean %.4f (min %.4f)\n",
+			ps.sumPSNR/n, ps.minPSNR, ps.sumSSIM/n, ps.minSSIM)
+	}
+
+	if len(ps.tail) == cap(ps.tail) && len(ps.tail) > 1 {
+		last := ps.tail[len(ps.tail)-1]
+		fmt.Printf("⏳ Tail time (last %d completions): %.2f seconds\n",
+			len(ps.tail)-1, last.Sub(ps.tail[0]).Seconds())
+	}
+
+	fmt.Printf("\n📈 Batch Statistics:\n")
+	for _, batch := range ps.sortedBatches() {
+		fmt.Printf("📦 Batch %d: %d/%d successful, took %.2f seconds\n",
+			batch.batchID, batch.successful, batch.total, batch.duration.Seconds())
+	}
+}
+
+// sortedBatches returns the batches by id: they finish in any order, and
+// the summary lists them the same way whatever the schedule.
+func (ps *processingStats) sortedBatches() []batchSummary {
+	sorted := slices.Clone(ps.batches)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].batchID < sorted[j].batchID })
+	return sorted
+}
+
+// percentile returns the q-th quantile of the successful durations, clamped
+// to the exact fastest and slowest times.
+func (ps *processingStats) percentile(q float64) time.Duration {
+	d := ps.durations.quantile(q)
+	if fastest := ps.fastest.top().duration; d < fastest {
+		d = fastest
+	}
+	if slowest := ps.slowest.top().duration; d > slowest {
+		d = slowest
+	}
+	return d
+}
+
+// topDurations keeps the n results with the longest durations, or with
+// fastest the shortest, in a heap whose root is the one to drop next. Of
+// results with equal durations, the first to arrive is kept.
+type topDurations struct {
+	n       int
+	fastest bool
+	results []rankedResult
+	seen    int
+}
+
+// rankedResult is a result with the order it arrived in.
+type rankedResult struct {
+	processingResult
+	seq int
+}
+
+func (t *topDurations) Len() int { return len(t.results) }
+
+// Less orders the heap with the result to drop next at its root: the
+// quickest of the slowest, or the slowest of the fastest, and of equal
+// ones the last to arrive.
+func (t *topDurations) Less(i, j int) bool {
+	a, b := t.results[i], t.results[j]
+	if a.duration != b.duration {
+		return (a.duration < b.duration) != t.fastest
+	}
+	return a.seq > b.seq
+}
+
+func (t *topDurations) Swap(i, j int) { t.results[i], t.results[j] = t.results[j], t.results[i] }
+
+func (t *topDurations) Push(x any) { t.results = append(t.results, x.(rankedResult)) }
+
+func (t *topDurations) Pop() any {
+	last := t.results[len(t.results)-1]
+	t.results = t.results[:len(t.results)-1]
+	return last
+}
+
+// add offers r to the heap, which keeps it if it is among the n fastest
+// or slowest so far.
+func (t *topDurations) add(r processingResult) {
+	ranked := rankedResult{r, t.seen}
+	t.seen++
+	if len(t.results) < t.n {
+		heap.Push(t, ranked)
+		return
+	}
+	root := t.results[0]
+	if t.fastest && r.duration < root.duration || !t.fastest && r.duration > root.duration {
+		t.results[0] = ranked
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the kept results, fastest or slowest first.
+func (t *topDurations) sorted() []processingResult {
+	ranked := append([]rankedResult(nil), t.results...)
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.duration != b.duration {
+			return (a.duration < b.duration) == t.fastest
+		}
+		return a.seq < b.seq
+	})
+	results := make([]processingResult, len(ranked))
+	for i, r := range ranked {
+		results[i] = r.processingResult
+	}
+	return results
+}
+
+// top returns the fastest or slowest result, or a zero one if there is
+// none.
+func (t *topDurations) top() processingResult {
+	if sorted := t.sorted(); len(sorted) > 0 {
+		return sorted[0]
+	}
+	return processingResult{}
+}
+
+// String lists the kept results for the -verbose summary, fastest or
+// slowest first, as "name (0.12 seconds)" separated by commas.
+func (t *topDurations) String() string {
+	var parts []string
+	for _, r := range t.sorted() {
+		parts = append(parts, fmt.Sprintf("%s (%.2f seconds)", r.filename, r.duration.Seconds()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// durationHistogram is a fixed-size log-linear histogram of durations in
+// microseconds: each power of two is split into histogramSubBuckets linear
+// buckets.
+type durationHistogram struct {
+	counts [histogramBuckets]uint64
+	total  uint64
+}
+
+func histogramBucket(us uint64) int {
+	if us < histogramSubBuckets {
+		return int(us)
+	}
+	exp := bits.Len64(us) - 5 // us >> exp is in [16, 32)
+	idx := (exp+1)*histogramSubBuckets + int(us>>exp) - histogramSubBuckets
+	if idx >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return idx
+}
+
+// histogramBucketMid returns the midpoint of bucket idx in microseconds.
+func histogramBucketMid(idx int) uint64 {
+	if idx < histogramSubBuckets {
+		return uint64(idx)
+	}
+	exp := idx/histogramSubBuckets - 1
+	low := uint64(idx%histogramSubBuckets+histogramSubBuckets) << exp
+	return low + (uint64(1)<<exp)/2
+}
+
+func (h *durationHistogram) add(d time.Duration) {
+	h.counts[histogramBucket(uint64(d.Microseconds()))]++
+	h.total++
+}
+
+// quantile returns the approximate q-th quantile (0-1) of the durations.
+func (h *durationHistogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.total-1)) + 1
+	var seen uint64
+	for idx, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return time.Duration(histogramBucketMid(idx)) * time.Microsecond
+		}
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// referenceStats is the aggregation processingStats replaced: it keeps
+// every batch and works the summary out from all of their results.
+type referenceStats struct {
+	batches []batchResult
+	seen    []processingResult
+}
+
+func (r *referenceStats) addResult(br batchResult) {
+	r.batches = append(r.batches, br)
+	for _, result := range br.results {
+		if result.error == nil {
+			r.seen = append(r.seen, result)
+		}
+	}
+}
+
+// byDuration returns the successful results sorted by duration, the first
+// to arrive first among equal ones.
+func (r *referenceStats) byDuration() []processingResult {
+	sorted := append([]processingResult(nil), r.seen...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].duration < sorted[j].duration })
+	return sorted
+}
+
+func (r *referenceStats) summaryLines(tailSize int) []string {
+	var total time.Duration
+	var measured int
+	var sumPSNR, sumSSIM float64
+	minPSNR, minSSIM := -1.0, -1.0
+	for _, result := range r.seen {
+		total += result.duration
+		if result.info.measured {
+			if minPSNR < 0 || result.info.qualityPSNR < minPSNR {
+				minPSNR = result.info.qualityPSNR
+			}
+			if minSSIM < 0 || result.info.qualitySSIM < minSSIM {
+				minSSIM = result.info.qualitySSIM
+			}
+			measured++
+			sumPSNR += result.info.qualityPSNR
+			sumSSIM += result.info.qualitySSIM
+		}
+	}
+	failed := 0
+	for _, br := range r.batches {
+		failed += len(br.results)
+	}
+	failed -= len(r.seen)
+
+	sorted := r.byDuration()
+	fastest, slowest := sorted[0], sorted[len(sorted)-1]
+	for _, result := range sorted {
+		// Of equal durations, the first to arrive is the slowest too.
+		if result.duration == slowest.duration {
+			slowest = result
+			break
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("✅ Total images processed: %d", len(r.seen)),
+		fmt.Sprintf("❌ Failed images: %d", failed),
+		fmt.Sprintf("⏱️  Average processing time: %.2f seconds", (total / time.Duration(len(r.seen))).Seconds()),
+		fmt.Sprintf("🚀 Fastest image: %s (%.2f seconds)", fastest.filename, fastest.duration.Seconds()),
+		fmt.Sprintf("🐢 Slowest image: %s (%.2f seconds)", slowest.filename, slowest.duration.Seconds()),
+		fmt.Sprintf("🔬 Quality: PSNR mean %.2f dB (min %.2f), SSIM mean %.4f (min %.4f)",
+			sumPSNR/float64(measured), minPSNR, sumSSIM/float64(measured), minSSIM),
+	}
+	tail := r.seen[len(r.seen)-tailSize:]
+	lines = append(lines, fmt.Sprintf("⏳ Tail time (last %d completions): %.2f seconds",
+		tailSize-1, tail[tailSize-1].finishedAt.Sub(tail[0].finishedAt).Seconds()))
+	for _, br := range r.batches {
+		successful := 0
+		for _, result := range br.results {
+			if result.error == nil {
+				successful++
+			}
+		}
+		lines = append(lines, fmt.Sprintf("📦 Batch %d: %d/%d successful, took %.2f seconds",
+			br.batchID, successful, len(br.results), br.endTime.Sub(br.startTime).Seconds()))
+	}
+	return lines
+}
+
+// syntheticBatches returns batches of results with random durations, some
+// failed and some measured with -measure-quality.
+func syntheticBatches(seed int64, batches, size int) []batchResult {
+	rng := rand.New(rand.NewSource(seed))
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	var out []batchResult
+	for b := 0; b < batches; b++ {
+		br := batchResult{batchID: b, startTime: now}
+		for i := 0; i < size; i++ {
+			d := time.Duration(1+rng.Intn(2000)) * time.Millisecond
+			now = now.Add(d)
+			r := processingResult{
+				index:      b*size + i,
+				batch:      b,
+				filename:   fmt.Sprintf("img%03d.jpg", b*size+i),
+				duration:   d,
+				finishedAt: now,
+			}
+			switch {
+			case rng.Intn(8) == 0:
+				r.error = errors.New("decode failed")
+			case rng.Intn(3) == 0:
+				r.info.measured = true
+				r.info.qualityPSNR = 30 + 20*rng.Float64()
+				r.info.qualitySSIM = 0.9 + 0.1*rng.Float64()
+			}
+			br.results = append(br.results, r)
+		}
+		br.endTime = now
+		out = append(out, br)
+	}
+	return out
+}
+
+func TestStatsMatchReferenceAggregation(t *testing.T) {
+	const tailSize = 4
+	for _, seed := range []int64{1, 2, 3} {
+		stats := newProcessingStats(tailSize)
+		var ref referenceStats
+		for _, br := range syntheticBatches(seed, 6, 7) {
+			stats.addResult(br)
+			ref.addResult(br)
+		}
+
+		sorted := ref.byDuration()
+		for i, r := range stats.fastest.sorted() {
+			if r.filename != sorted[i].filename || r.duration != sorted[i].duration {
+				t.Errorf("seed %d: fastest #%d = %s %v, want %s %v", seed, i+1, r.filename, r.duration, sorted[i].filename, sorted[i].duration)
+			}
+		}
+		slowest := append([]processingResult(nil), sorted...)
+		sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].duration > slowest[j].duration })
+		for i, r := range stats.slowest.sorted() {
+			if r.filename != slowest[i].filename || r.duration != slowest[i].duration {
+				t.Errorf("seed %d: slowest #%d = %s %v, want %s %v", seed, i+1, r.filename, r.duration, slowest[i].filename, slowest[i].duration)
+			}
+		}
+		if n := len(stats.fastest.sorted()); n != topImages {
+			t.Errorf("seed %d: %d fastest images kept, want %d", seed, n, topImages)
+		}
+
+		// The histogram's buckets are within 1/16 of the exact durations.
+		for _, q := range []float64{0.5, 0.9, 0.99} {
+			exact := sorted[int(q*float64(len(sorted)-1))].duration
+			got := stats.percentile(q)
+			if diff := (got - exact).Seconds(); diff > exact.Seconds()/16 || -diff > exact.Seconds()/16 {
+				t.Errorf("seed %d: p%.0f = %v, exact %v", seed, q*100, got, exact)
+			}
+		}
+
+		printed := captureStdout(t, func() { stats.printSummary(time.Second) })
+		for _, line := range ref.summaryLines(tailSize) {
+			if !strings.Contains(printed, line+"\n") {
+				t.Errorf("seed %d: summary lacks %q", seed, line)
+			}
+		}
+		if strings.Contains(printed, fmt.Sprintf("%d images: ", topImages)) {
+			t.Errorf("seed %d: summary without -verbose lists several fastest or slowest images", seed)
+		}
+
+		// -verbose adds the top lists after the single lines.
+		stats.verbose = true
+		printed = captureStdout(t, func() { stats.printSummary(time.Second) })
+		fastest, slowest0 := sorted[0], slowest[0]
+		for _, want := range []string{
+			fmt.Sprintf("🚀 Fastest %d images: %s (%.2f seconds), ", topImages, fastest.filename, fastest.duration.Seconds()),
+			fmt.Sprintf("🐢 Slowest %d images: %s (%.2f seconds), ", topImages, slowest0.filename, slowest0.duration.Seconds()),
+		} {
+			if !strings.Contains(printed, want) {
+				t.Errorf("seed %d: -verbose summary lacks %q", seed, want)
+			}
+		}
+	}
+}
+
+func TestTopDurationsTies(t *testing.T) {
+	top := topDurations{n: 2, fastest: true}
+	for _, name := range []string{"a", "b", "c"} {
+		top.add(processingResult{filename: name, duration: time.Second})
+	}
+	got := top.sorted()
+	if len(got) != 2 || got[0].filename != "a" || got[1].filename != "b" {
+		t.Errorf("equal durations kept %v, want the first two to arrive", got)
+	}
+}
+
+func TestBatchSummaryInBatchOrder(t *testing.T) {
+	ps := newProcessingStats(0)
+	ids := rand.New(rand.NewSource(1)).Perm(250)
+	for _, id := range ids {
+		ps.addResult(batchResult{batchID: id, results: []processingResult{{filename: "a.jpg"}}})
+	}
+	batches := ps.sortedBatches()
+	if len(batches) != len(ids) {
+		t.Fatalf("summary lists %d batches, want all %d", len(batches), len(ids))
+	}
+	for i, b := range batches {
+		if b.batchID != i {
+			t.Fatalf("summary batch %d is batch %d, want every batch in order", i, b.batchID)
+		}
+	}
+}
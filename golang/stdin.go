@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readInputList reads the -stdin list of input paths, one per line, as
+// written by find or ls. Blank lines are ignored, and paths that do not
+// name a file are reported and left out. The rel of each file is its path
+// as listed, so its output is placed relative to it.
+func readInputList(r io.Reader) ([]inputFile, error) {
+	var files []inputFile
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		path := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Printf("⚠️  Skipping %s: %v\n", path, err)
+			continue
+		}
+		if info.IsDir() {
+			fmt.Printf("⚠️  Skipping %s: it is a folder; list the images in it\n", path)
+			continue
+		}
+		files = append(files, inputFile{rel: filepath.Clean(path), entry: fs.FileInfoToDirEntry(info)})
+	}
+	return files, scanner.Err()
+}
+
+// validateStdinMode rejects options that need an input folder, or a single
+// output folder, when -stdin is given.
+func (c *Config) validateStdinMode(inputFolder string) error {
+	if !c.stdin {
+		return nil
+	}
+	switch {
+	case inputFolder != "":
+		return fmt.Errorf("-stdin cannot be combined with an input folder")
+	case c.jobsPath != "":
+		return fmt.Errorf("-stdin and -jobs are two ways of listing the images; use one")
+	case c.recursive:
+		return fmt.Errorf("-recursive works on an input folder; -stdin lists the images to process")
+	case c.tune:
+		return fmt.Errorf("-tune works on an input folder, not with -stdin")
+	case c.outputDir == "" && (c.htmlReport || c.qaSample > 0):
+		return fmt.Errorf("-html-report and -qa-sample need -output with -stdin, whose outputs are otherwise spread over the input folders")
+	}
+	return nil
+}
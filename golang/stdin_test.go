@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stdinInputs writes a.jpg and sub/b.png under dir and returns their paths.
+func stdinInputs(t *testing.T, dir string) (a, b string) {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	a, b = filepath.Join(dir, "a.jpg"), filepath.Join(dir, "sub", "b.png")
+	writeImage(t, a, fill(40, 30, color.RGBA{200, 100, 0, 255}))
+	writeImage(t, b, fill(30, 40, color.RGBA{0, 100, 200, 255}))
+	return a, b
+}
+
+func TestReadInputList(t *testing.T) {
+	dir := t.TempDir()
+	a, b := stdinInputs(t, dir)
+	// Blank lines, CRLF endings, folders and missing files, as a list
+	// from a Windows tool or a stale find might have.
+	list := strings.Join([]string{a, "", "   ", filepath.Join(dir, "sub"), filepath.Join(dir, "gone.jpg"), b + "\r", filepath.Join(dir, ".", "a.jpg")}, "\n")
+
+	var files []inputFile
+	var err error
+	printed := captureStdout(t, func() { files, err = readInputList(bytes.NewBufferString(list)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, f := range files {
+		rels = append(rels, f.rel)
+	}
+	if want := []string{a, b, a}; !slices.Equal(rels, want) {
+		t.Errorf("read %v, want %v", rels, want)
+	}
+	for _, skipped := range []string{"sub: it is a folder", "gone.jpg"} {
+		if !strings.Contains(printed, skipped) {
+			t.Errorf("no warning about %s:\n%s", skipped, printed)
+		}
+	}
+
+	// Each output goes in a bordered_images folder next to its input.
+	config := testConfig()
+	config.stdin = true
+	for _, f := range files[:2] {
+		want := filepath.Join(filepath.Dir(f.rel), "bordered_images", "bordered_"+filepath.Base(f.rel))
+		if got := outputPathFor(config, "", f.rel, filepath.Base(f.rel)); got != want {
+			t.Errorf("output of %s = %s, want %s", f.rel, got, want)
+		}
+	}
+}
+
+func TestStdinRun(t *testing.T) {
+	dir := t.TempDir()
+	a, b := stdinInputs(t, dir)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+	go func() {
+		w.WriteString(a + "\n" + b + "\n")
+		w.Close()
+	}()
+
+	config := testConfig()
+	config.stdin = true
+	if err := runFolder(context.Background(), config, "", time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{
+		filepath.Join(dir, "bordered_images", "bordered_a.jpg"),
+		filepath.Join(dir, "sub", "bordered_images", "bordered_b.png"),
+	} {
+		if img := readImage(t, out); img.Bounds().Dx() != config.targetWidth {
+			t.Errorf("%s is %v wide, want %d", out, img.Bounds().Dx(), config.targetWidth)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// The -jpeg-subsampling values.
+const (
+	subsampling420 = "420"
+	subsampling422 = "422"
+	subsampling444 = "444"
+)
+
+// validateJPEGSubsampling checks -jpeg-subsampling.
+func (c *Config) validateJPEGSubsampling() error {
+	switch c.jpegSubsampling {
+	case subsampling420, subsampling444:
+		return nil
+	case subsampling422:
+		if c.backend == backendVips {
+			return fmt.Errorf("-backend vips writes 4:2:0 or 4:4:4 JPEGs, not -jpeg-subsampling 422")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid -jpeg-subsampling value %q (expected 420, 422 or 444)", c.jpegSubsampling)
+}
+
+// encodeJPEG writes img as a baseline JPEG at quality, with the chroma
+// subsampling of -jpeg-subsampling.
+//
+// image/jpeg only writes 4:2:0, so for 4:2:2 and 4:4:4 each of the Y, Cb
+// and Cr planes is encoded by it as a grayscale JPEG of the plane's size,
+// and the three scans are put together under one frame header: baseline
+// JPEG allows a scan per component. The chroma planes are quantized and
+// coded with the luma tables, so they come out a little finer, and larger,
+// than with a 4:2:0 encoder.
+func (c *Config) encodeJPEG(w io.Writer, img image.Image, quality int) error {
+	if _, gray := img.(*image.Gray); gray || c.jpegSubsampling == subsampling420 {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	planes := ycbcrPlanes(img, c.jpegSubsampling == subsampling422)
+
+	var tables [][]byte
+	var scans [3][]byte
+	for i, plane := range planes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, plane, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+		segments, scan, err := splitGrayJPEG(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			tables = segments
+		}
+		scans[i] = scan
+	}
+
+	b := img.Bounds()
+	lumaSampling := byte(0x11)
+	if c.jpegSubsampling == subsampling422 {
+		lumaSampling = 0x21
+	}
+	sof := []byte{8, 0, 0, 0, 0, 3, 1, lumaSampling, 0, 2, 0x11, 0, 3, 0x11, 0}
+	binary.BigEndian.PutUint16(sof[1:], uint16(b.Dy()))
+	binary.BigEndian.PutUint16(sof[3:], uint16(b.Dx()))
+
+	out := []byte{0xff, 0xd8}
+	out = append(out, tables[0]...) // DQT
+	out = append(out, jpegSegment(0xc0, sof)...)
+	out = append(out, tables[1]...) // DHT
+	for i, scan := range scans {
+		// One component, its id, the luma Huffman tables, and the full
+		// spectral range of a sequential scan.
+		out = append(out, jpegSegment(0xda, []byte{1, byte(i + 1), 0x00, 0x00, 0x3f, 0x00})...)
+		out = append(out, scan...)
+	}
+	out = append(out, 0xff, 0xd9)
+	_, err := w.Write(out)
+	return err
+}
+
+// ycbcrPlanes splits img into its Y, Cb and Cr planes, as grayscale images
+// for the JPEG encoder, with the chroma planes halved horizontally (with
+// the width rounded up) when halveChroma is set.
+func ycbcrPlanes(img image.Image, halveChroma bool) [3]*image.Gray {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw := w
+	if halveChroma {
+		cw = (w + 1) / 2
+	}
+	planes := [3]*image.Gray{
+		image.NewGray(image.Rect(0, 0, w, h)),
+		image.NewGray(image.Rect(0, 0, cw, h)),
+		image.NewGray(image.Rect(0, 0, cw, h)),
+	}
+	cb, cr := make([]int, w), make([]int, w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			yy, u, v := ycbcrAt(img, b.Min.X+x, b.Min.Y+y)
+			planes[0].Pix[y*planes[0].Stride+x] = yy
+			cb[x], cr[x] = int(u), int(v)
+		}
+		for x := 0; x < cw; x++ {
+			u, v := cb[x], cr[x]
+			if halveChroma {
+				right := 2*x + 1
+				if right == w {
+					right = w - 1
+				}
+				u = (cb[2*x] + cb[right] + 1) / 2
+				v = (cr[2*x] + cr[right] + 1) / 2
+			}
+			planes[1].Pix[y*planes[1].Stride+x] = uint8(u)
+			planes[2].Pix[y*planes[2].Stride+x] = uint8(v)
+		}
+	}
+	return planes
+}
+
+// ycbcrAt returns the JPEG YCbCr values of the pixel of img at x, y,
+// reading the planes of a YCbCr image directly.
+func ycbcrAt(img image.Image, x, y int) (uint8, uint8, uint8) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		ci := m.COffset(x, y)
+		return m.Y[m.YOffset(x, y)], m.Cb[ci], m.Cr[ci]
+	case *image.RGBA:
+		px := m.Pix[m.PixOffset(x, y):]
+		return color.RGBToYCbCr(px[0], px[1], px[2])
+	}
+	r, g, bl, _ := img.At(x, y).RGBA()
+	return color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+}
+
+// splitGrayJPEG splits a grayscale JPEG written by image/jpeg into its DQT
+// and DHT segments and the entropy-coded data of its single scan.
+func splitGrayJPEG(data []byte) (tables [][]byte, scan []byte, err error) {
+	tables = make([][]byte, 2)
+	for i := 2; i+4 <= len(data); {
+		marker := data[i+1]
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if data[i] != 0xff || end > len(data) {
+			break
+		}
+		switch marker {
+		case 0xdb:
+			tables[0] = data[i:end]
+		case 0xc4:
+			tables[1] = data[i:end]
+		case 0xda:
+			if tables[0] == nil || tables[1] == nil || len(data)-2 < end {
+				return nil, nil, errors.New("unexpected JPEG encoder output")
+			}
+			return tables, data[end : len(data)-2], nil
+		}
+		i = end
+	}
+	return nil, nil, errors.New("unexpected JPEG encoder output")
+}
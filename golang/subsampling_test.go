@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestJPEGSubsampling(t *testing.T) {
+	// Alternating red and blue columns, the colored hard edges that chroma
+	// subsampling smears.
+	src := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{220, 30, 30, 255}
+			if x%2 == 1 {
+				c = color.RGBA{30, 30, 220, 255}
+			}
+			src.SetRGBA(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		subsampling string
+		ratio       image.YCbCrSubsampleRatio
+	}{
+		{subsampling420, image.YCbCrSubsampleRatio420},
+		{subsampling422, image.YCbCrSubsampleRatio422},
+		{subsampling444, image.YCbCrSubsampleRatio444},
+	}
+	errs := make(map[string]int)
+	for _, tt := range tests {
+		config := testConfig()
+		config.jpegSubsampling = tt.subsampling
+		var buf bytes.Buffer
+		if err := config.encodeJPEG(&buf, src, 95); err != nil {
+			t.Fatalf("%s: %v", tt.subsampling, err)
+		}
+		img, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", tt.subsampling, err)
+		}
+		ycbcr, ok := img.(*image.YCbCr)
+		if !ok || ycbcr.SubsampleRatio != tt.ratio {
+			t.Fatalf("%s: decoded as %T, want YCbCr %v", tt.subsampling, img, tt.ratio)
+		}
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				r, _, b, _ := img.At(x, y).RGBA()
+				want := src.RGBAAt(x, y)
+				errs[tt.subsampling] += max(int(r>>8)-int(want.R), int(want.R)-int(r>>8))
+				errs[tt.subsampling] += max(int(b>>8)-int(want.B), int(want.B)-int(b>>8))
+			}
+		}
+	}
+	// Stripes one column wide need full horizontal chroma: 4:4:4 keeps them,
+	// 4:2:2 and 4:2:0 average each pair of columns to purple.
+	if errs[subsampling444]*4 > errs[subsampling420] {
+		t.Errorf("4:4:4 error %d is not far below 4:2:0's %d", errs[subsampling444], errs[subsampling420])
+	}
+	if errs[subsampling444]*4 > errs[subsampling422] {
+		t.Errorf("4:4:4 error %d is not far below 4:2:2's %d", errs[subsampling444], errs[subsampling422])
+	}
+}
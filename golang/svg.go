@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// decodeSVG rasterizes the SVG at path at the size it will cover on the
+// canvas of config, so it is drawn crisp rather than scaled up from its
+// intrinsic size. Its background stays transparent and shows the border
+// color.
+func decodeSVG(path string, r io.Reader, config *Config) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSVGReferences(path, data); err != nil {
+		return nil, err
+	}
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVG: %v", err)
+	}
+	if len(icon.SVGPaths) == 0 {
+		return nil, errors.New("SVG has no shapes to draw")
+	}
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("SVG has no size: set width and height or a viewBox")
+	}
+
+	dest := computeLayout(int(math.Ceil(w)), int(math.Ceil(h)), config).destRect
+	width, height := max(1, dest.Dx()), max(1, dest.Dy())
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return img, nil
+}
+
+// checkSVGReferences fails for SVGs the rasterizer would silently draw
+// incompletely: ones referring to other files, which it never loads, and
+// ones with <image> elements, which it skips.
+func checkSVGReferences(path string, data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	for {
+		t, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid SVG: %v", err)
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if se.Name.Local == "image" {
+			return errors.New("SVG <image> elements are not supported")
+		}
+		for _, attr := range se.Attr {
+			if attr.Name.Local != "href" || strings.HasPrefix(attr.Value, "#") {
+				continue
+			}
+			ref := strings.SplitN(attr.Value, "#", 2)[0]
+			if strings.Contains(ref, ":") {
+				return fmt.Errorf("SVG refers to %q, which is not loaded", attr.Value)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), filepath.FromSlash(ref))); err != nil {
+				return fmt.Errorf("SVG refers to missing file %q", attr.Value)
+			}
+			return fmt.Errorf("SVG refers to external file %q, which is not loaded; inline it instead", attr.Value)
+		}
+	}
+}
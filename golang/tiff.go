@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/tiff"
+)
+
+// maxTIFFPages bounds the IFD chain followed in a TIFF, so a file whose
+// chain loops or runs on does not stall the listing.
+const maxTIFFPages = 10000
+
+// tiffNewSubfileType is the tag marking an IFD as a reduced-resolution copy
+// of another, such as a preview stored next to each scanned page.
+const tiffNewSubfileType = 254
+
+// tiffCompressions maps the -tiff-compression values to the compressions
+// the TIFF encoder supports. Both are lossless.
+var tiffCompressions = map[string]tiff.CompressionType{
+	"deflate": tiff.Deflate,
+	"none":    tiff.Uncompressed,
+}
+
+// validateTIFFCompression checks -tiff-compression.
+func (c *Config) validateTIFFCompression() error {
+	if _, ok := tiffCompressions[c.tiffCompression]; !ok {
+		return fmt.Errorf("invalid -tiff-compression value %q (expected deflate or none)", c.tiffCompression)
+	}
+	return nil
+}
+
+// encodeTIFF writes img as TIFF with the -tiff-compression compression.
+func (c *Config) encodeTIFF(w io.Writer, img image.Image) error {
+	return tiff.Encode(w, img, &tiff.Options{Compression: tiffCompressions[c.tiffCompression]})
+}
+
+// tiffPages returns the offsets of the IFDs of the TIFF read by r that hold
+// a page, in order, leaving out reduced-resolution copies.
+func tiffPages(r io.ReaderAt) ([]uint32, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF file")
+	}
+
+	var pages []uint32
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(header[4:]); offset != 0; {
+		if seen[offset] || len(seen) == maxTIFFPages {
+			return nil, fmt.Errorf("IFD chain loops or holds more than %d pages", maxTIFFPages)
+		}
+		seen[offset] = true
+
+		count := make([]byte, 2)
+		if _, err := r.ReadAt(count, int64(offset)); err != nil {
+			return nil, fmt.Errorf("IFD at %d: %v", offset, err)
+		}
+		// The entries plus the pointer to the next IFD.
+		ifd := make([]byte, 12*int(order.Uint16(count))+4)
+		if _, err := r.ReadAt(ifd, int64(offset)+2); err != nil {
+			return nil, fmt.Errorf("IFD at %d truncated", offset)
+		}
+		reduced := false
+		for e := 0; e+12 <= len(ifd)-4; e += 12 {
+			if order.Uint16(ifd[e:]) == tiffNewSubfileType {
+				reduced = order.Uint32(ifd[e+8:])&1 != 0
+			}
+		}
+		if !reduced {
+			pages = append(pages, offset)
+		}
+		offset = order.Uint32(ifd[len(ifd)-4:])
+	}
+	return pages, nil
+}
+
+// tiffPageCount returns the number of pages of the TIFF at path, or 1 when
+// it cannot tell, leaving the error to decoding.
+func tiffPageCount(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	pages, err := tiffPages(f)
+	if err != nil || len(pages) == 0 {
+		return 1
+	}
+	return len(pages)
+}
+
+// decodeTIFFPage decodes page (counting from 1) of the TIFF read by r. The
+// decoder only reads the first IFD, so the header is made to point at the
+// IFD of the page instead.
+func decodeTIFFPage(r io.Reader, page int) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := tiffPages(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 || page > len(pages) {
+		return nil, fmt.Errorf("no page %d in a TIFF of %d pages", page, len(pages))
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	order.PutUint32(data[4:], pages[page-1])
+	img, _, err := decodeSafely(bytes.NewReader(data))
+	return img, err
+}
+
+// pageOutputPath returns the output path of page of a TIFF of count pages:
+// outputPath with a _p01-style suffix, padded so the pages sort in order.
+func pageOutputPath(outputPath string, page, count int) string {
+	ext := filepath.Ext(outputPath)
+	digits := max(2, len(fmt.Sprint(count)))
+	return fmt.Sprintf("%s_p%0*d%s", strings.TrimSuffix(outputPath, ext), digits, page, ext)
+}
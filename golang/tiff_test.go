@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestTIFFRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "scan.tif")
+	f, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same-size output: the scan is copied 1:1 between 10px borders.
+	src := noise(100, 80)
+	if err := tiff.Encode(f, src, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	sizes := make(map[string]int64)
+	for _, compression := range []string{"deflate", "none"} {
+		t.Run(compression, func(t *testing.T) {
+			config := testConfig()
+			config.targetWidth, config.targetHeight = 120, 100
+			config.landscapeVertBorder, config.landscapeHorizBorder = 0.1, 1.0/12
+			config.tiffCompression = compression
+			job := imageJob{inputPath: input, outputPath: filepath.Join(dir, compression+".tiff")}
+			info, err := processImage(context.Background(), job, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.format != "tiff" {
+				t.Errorf("decoded as %q, want tiff", info.format)
+			}
+			sizes[compression] = info.outputBytes
+
+			out := readImage(t, job.outputPath)
+			if out.Bounds() != image.Rect(0, 0, 120, 100) {
+				t.Fatalf("output is %v, want 120x100", out.Bounds())
+			}
+			dest := info.layout.destRect
+			if dest != image.Rect(10, 10, 110, 90) {
+				t.Fatalf("scan placed at %v, want unscaled at (10,10)", dest)
+			}
+			// TIFF is lossless, so every pixel comes back exactly.
+			for y := 0; y < 80; y++ {
+				for x := 0; x < 100; x++ {
+					if got, want := out.At(dest.Min.X+x, dest.Min.Y+y), src.At(x, y); !near(got, want, 0) {
+						t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+			if got := out.At(0, 0); !near(got, color.White, 0) {
+				t.Errorf("border is %v, want white", got)
+			}
+		})
+	}
+	if sizes["none"] < 120*100*3 {
+		t.Errorf("uncompressed output is %d bytes, smaller than its pixels", sizes["none"])
+	}
+}
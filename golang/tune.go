@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// tuneSampleCount is how many images of the input folder -tune
+	// previews.
+	tuneSampleCount = 6
+	// tuneSourceSize caps the longer side of the sample images, which are
+	// shrunk once when loaded so previews re-render quickly.
+	tuneSourceSize = 1600
+)
+
+// tuneSettings are the settings the -tune page controls, named like the
+// -jobs overrides.
+var tuneSettings = []string{
+	"width", "height",
+	"landscape_vert", "landscape_horiz", "portrait_vert", "portrait_horiz",
+	"jpeg_quality", "background",
+}
+
+// validateTune checks -tune and that it only listens on the local machine.
+func (c *Config) validateTune() error {
+	if !c.tune {
+		return nil
+	}
+	switch {
+	case c.jobsPath != "":
+		return fmt.Errorf("-tune works on an input folder, not with -jobs")
+	case c.diffSettings:
+		return fmt.Errorf("-tune cannot be combined with -diff-settings")
+	}
+	host, _, err := net.SplitHostPort(c.tuneAddr)
+	if err != nil {
+		return fmt.Errorf("invalid -tune-addr %q: %v", c.tuneAddr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("-tune-addr must be a loopback address such as 127.0.0.1:8642, got %q", c.tuneAddr)
+	}
+	return nil
+}
+
+// tuneSample is an image previewed by -tune.
+type tuneSample struct {
+	Name string
+	img  image.Image
+}
+
+// tuneServer serves the -tune page.
+type tuneServer struct {
+	config      *Config
+	inputFolder string
+	flagSet     *flag.FlagSet
+	samples     []tuneSample
+
+	// mu serializes preset saves and folder runs, which share the flag set
+	// and the console.
+	mu      sync.Mutex
+	running bool
+}
+
+// runTune loads a sample of inputFolder and serves the tuning page until
+// the process is stopped.
+func runTune(config *Config, inputFolder string, flagSet *flag.FlagSet) error {
+	samples, err := loadTuneSamples(inputFolder, config)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no images to preview in %s", inputFolder)
+	}
+
+	ts := &tuneServer{config: config, inputFolder: inputFolder, flagSet: flagSet, samples: samples}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", ts.handlePage)
+	mux.HandleFunc("/preview", ts.handlePreview)
+	mux.HandleFunc("/save", ts.handleSave)
+	mux.HandleFunc("/apply", ts.handleApply)
+
+	listener, err := net.Listen("tcp", config.tuneAddr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("🎛️  Tuning %d sample image(s) at http://%s/ (Ctrl+C to stop)\n", len(samples), listener.Addr())
+	return http.Serve(listener, localOnly(mux))
+}
+
+// localOnly rejects requests whose Host is not a loopback name, so a web
+// page elsewhere cannot reach the server through DNS rebinding, and
+// cross-origin POSTs, so it cannot trigger saves or runs.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); r.Method != http.MethodGet && origin != "" && origin != "http://"+r.Host {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loadTuneSamples decodes up to tuneSampleCount images spread evenly over
+// the folder listing, shrunk to tuneSourceSize.
+func loadTuneSamples(inputFolder string, config *Config) ([]tuneSample, error) {
+	files, err := listInputs(inputFolder, outputFolderFor(config, inputFolder), config.recursive)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory: %v", err)
+	}
+	var names []string
+	for _, file := range filterPattern(files, config.pattern) {
+		if _, ok := outputNameFor(file.entry.Name(), config); ok {
+			names = append(names, file.rel)
+		}
+	}
+
+	var samples []tuneSample
+	step := max(1, len(names)/tuneSampleCount)
+	for i := 0; i < len(names) && len(samples) < tuneSampleCount; i += step {
+		img, err := decodeInput(filepath.Join(inputFolder, names[i]), 0, config, nil)
+		if err != nil {
+			fmt.Printf("⚠️  Skipping %s: %v\n", names[i], err)
+			continue
+		}
+		samples = append(samples, tuneSample{Name: names[i], img: shrinkToFit(img, tuneSourceSize)})
+	}
+	return samples, nil
+}
+
+// shrinkToFit returns img scaled down so its longer side is at most size.
+func shrinkToFit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return img
+	}
+	w, h := size, b.Dy()*size/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*size/b.Dy(), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, max(1, w), max(1, h)))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// tuneValues returns the tune settings given in the request's query, as
+// strings keyed by setting name.
+func tuneValues(r *http.Request) map[string]string {
+	values := make(map[string]string)
+	q := r.URL.Query()
+	for _, key := range tuneSettings {
+		if v := q.Get(key); v != "" {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// tuneConfig applies the request's settings to a copy of the base config,
+// through the same overrides and validation as -jobs.
+func (ts *tuneServer) tuneConfig(values map[string]string) (*Config, error) {
+	doc := make(map[string]any)
+	for key, v := range values {
+		if key == "background" {
+			doc[key] = v
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", key, v)
+		}
+		doc[key] = n
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var overrides jobOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("invalid settings: %v", err)
+	}
+
+	c := *ts.config
+	overrides.apply(&c)
+	if err := c.validateRender(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (ts *tuneServer) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	c := ts.config
+	data := struct {
+		Samples  []tuneSample
+		Folder   string
+		Settings map[string]string
+	}{
+		Samples: ts.samples,
+		Folder:  ts.inputFolder,
+		Settings: map[string]string{
+			"width":           strconv.Itoa(c.targetWidth),
+			"height":          strconv.Itoa(c.targetHeight),
+			"landscape_vert":  strconv.FormatFloat(c.landscapeVertBorder, 'f', -1, 64),
+			"landscape_horiz": strconv.FormatFloat(c.landscapeHorizBorder, 'f', -1, 64),
+			"portrait_vert":   strconv.FormatFloat(c.portraitVertBorder, 'f', -1, 64),
+			"portrait_horiz":  strconv.FormatFloat(c.portraitHorizBorder, 'f', -1, 64),
+			"jpeg_quality":    strconv.Itoa(c.jpegQuality),
+			"background":      c.background,
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tunePage.Execute(w, data); err != nil {
+		fmt.Printf("Error rendering tuning page: %v\n", err)
+	}
+}
+
+// handlePreview renders one sample with the requested settings, encoded as
+// the JPEG a run would write.
+func (ts *tuneServer) handlePreview(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.Atoi(r.URL.Query().Get("i"))
+	if err != nil || i < 0 || i >= len(ts.samples) {
+		http.Error(w, "no such sample", http.StatusNotFound)
+		return
+	}
+	cfg, err := ts.tuneConfig(tuneValues(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var info imageInfo
+	canvas, _ := renderCanvas(ts.samples[i].img, "preview.jpg", cfg, &info)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: cfg.jpegQuality}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// handleSave stores the requested settings as a preset, like -save-preset.
+func (ts *tuneServer) handleSave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		http.Error(w, "missing preset name", http.StatusBadRequest)
+		return
+	}
+	values := tuneValues(r)
+	if _, err := ts.tuneConfig(values); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for key, v := range values {
+		if err := ts.flagSet.Set(strings.ReplaceAll(key, "_", "-"), v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	path, err := savePresetTo(ts.flagSet, name, r.URL.Query().Get("force") == "1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	fmt.Printf("💾 Saved preset %q to %s\n", name, path)
+	fmt.Fprintf(w, "Saved preset %q to %s", name, path)
+}
+
+// tuneProgress is one line of the /apply event stream.
+type tuneProgress struct {
+	Done     int    `json:"done"`
+	Total    int    `json:"total"`
+	File     string `json:"file,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Finished bool   `json:"finished,omitempty"`
+	Failed   int    `json:"failed"`
+}
+
+// handleApply runs the whole folder with the requested settings and streams
+// the progress as server-sent events.
+func (ts *tuneServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := ts.tuneConfig(tuneValues(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ts.mu.Lock()
+	if ts.running {
+		ts.mu.Unlock()
+		http.Error(w, "a run is already in progress", http.StatusConflict)
+		return
+	}
+	ts.running = true
+	ts.mu.Unlock()
+	defer func() {
+		ts.mu.Lock()
+		ts.running = false
+		ts.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	flusher, _ := w.(http.Flusher)
+	send := func(p tuneProgress) {
+		data, _ := json.Marshal(p)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var progress tuneProgress
+	err = runFolder(context.Background(), cfg, ts.inputFolder, time.Now(), func(res processingResult, total int) {
+		progress.Done++
+		progress.Total = total
+		progress.File = res.filename
+		progress.Error = ""
+		if res.error != nil {
+			progress.Failed++
+			progress.Error = res.error.Error()
+		}
+		send(progress)
+	})
+	progress.File, progress.Finished = "", true
+	progress.Error = ""
+	if err != nil {
+		progress.Error = err.Error()
+	}
+	send(progress)
+}
+
+var tunePage = template.Must(template.New("tune").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>white_border_adder tuning</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; display: flex; min-height: 100vh; background: #eee; }
+form { width: 300px; padding: 16px; background: #fff; box-shadow: 0 0 4px #0003; }
+label { display: block; margin: 10px 0 2px; font-size: 13px; }
+input[type=range] { width: 100%; }
+output { float: right; font-variant-numeric: tabular-nums; }
+button { margin-top: 12px; width: 100%; padding: 6px; }
+#grid { flex: 1; display: grid; grid-template-columns: repeat(auto-fill, minmax(320px, 1fr)); gap: 16px; padding: 16px; align-content: start; }
+figure { margin: 0; }
+figure img { width: 100%; box-shadow: 0 1px 4px #0004; }
+figcaption { font-size: 12px; color: #555; margin-top: 4px; }
+#status { font-size: 13px; white-space: pre-wrap; margin-top: 10px; }
+progress { width: 100%; }
+</style>
+</head>
+<body>
+<form id="settings" onsubmit="return false">
+<h3>Tuning {{.Folder}}</h3>
+<label>Width <output></output><input type="range" name="width" min="100" max="6000" step="10" value="{{index .Settings "width"}}"></label>
+<label>Height <output></output><input type="range" name="height" min="100" max="6000" step="10" value="{{index .Settings "height"}}"></label>
+<label>Landscape vertical <output></output><input type="range" name="landscape_vert" min="0" max="0.45" step="0.005" value="{{index .Settings "landscape_vert"}}"></label>
+<label>Landscape horizontal <output></output><input type="range" name="landscape_horiz" min="0" max="0.45" step="0.005" value="{{index .Settings "landscape_horiz"}}"></label>
+<label>Portrait vertical <output></output><input type="range" name="portrait_vert" min="0" max="0.45" step="0.005" value="{{index .Settings "portrait_vert"}}"></label>
+<label>Portrait horizontal <output></output><input type="range" name="portrait_horiz" min="0" max="0.45" step="0.005" value="{{index .Settings "portrait_horiz"}}"></label>
+<label>JPEG quality <output></output><input type="range" name="jpeg_quality" min="1" max="100" value="{{index .Settings "jpeg_quality"}}"></label>
+<label>Background <output></output><input type="text" name="background" value="{{index .Settings "background"}}" placeholder="white, black, #rrggbb or auto-contrast"></label>
+<label>Preset name<input type="text" id="preset"></label>
+<label><input type="checkbox" id="force"> Overwrite an existing preset</label>
+<button id="save">Save preset</button>
+<button id="apply">Apply to folder</button>
+<progress id="progress" value="0" max="1" hidden></progress>
+<div id="status"></div>
+</form>
+<div id="grid">
+{{range $i, $s := .Samples}}<figure><img data-i="{{$i}}" alt="{{$s.Name}}"><figcaption>{{$s.Name}}</figcaption></figure>
+{{end}}</div>
+<script>
+const form = document.getElementById('settings');
+const status = document.getElementById('status');
+const bar = document.getElementById('progress');
+
+function query() {
+  const q = new URLSearchParams();
+  for (const el of form.querySelectorAll('[name]')) q.set(el.name, el.value);
+  return q;
+}
+
+let timer;
+function refresh() {
+  for (const el of form.querySelectorAll('input[type=range]')) el.previousElementSibling.value = el.value;
+  clearTimeout(timer);
+  timer = setTimeout(() => {
+    const q = query();
+    for (const img of document.querySelectorAll('#grid img')) {
+      q.set('i', img.dataset.i);
+      img.src = '/preview?' + q;
+    }
+  }, 150);
+}
+form.addEventListener('input', refresh);
+refresh();
+
+document.getElementById('save').onclick = async () => {
+  const q = query();
+  q.set('name', document.getElementById('preset').value);
+  if (document.getElementById('force').checked) q.set('force', '1');
+  const res = await fetch('/save?' + q, {method: 'POST'});
+  status.textContent = await res.text();
+};
+
+document.getElementById('apply').onclick = async () => {
+  const res = await fetch('/apply?' + query(), {method: 'POST'});
+  if (!res.ok) { status.textContent = await res.text(); return; }
+  bar.hidden = false;
+  const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffered = '', failures = [];
+  for (;;) {
+    const {value, done} = await reader.read();
+    if (done) break;
+    buffered += value;
+    let end;
+    while ((end = buffered.indexOf('\n\n')) >= 0) {
+      const p = JSON.parse(buffered.slice(0, end).replace(/^data: /, ''));
+      buffered = buffered.slice(end + 2);
+      bar.max = Math.max(p.total, 1);
+      bar.value = p.done;
+      if (p.error && !p.finished) failures.push(p.file + ': ' + p.error);
+      status.textContent = p.finished
+        ? (p.error ? 'Run failed: ' + p.error : 'Done: ' + (p.done - p.failed) + ' processed, ' + p.failed + ' failed') + '\n' + failures.join('\n')
+        : p.done + ' / ' + p.total + ' ' + p.file;
+    }
+  }
+};
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// runUndo implements the undo subcommand: it deletes the outputs listed in a
+// -manifest, but only those whose size and SHA-256 still match what the run
+// recorded, so files replaced or edited since are kept.
+func runUndo(args []string) {
+	flagSet := flag.NewFlagSet("undo", flag.ExitOnError)
+	dryRun := flagSet.Bool("dry-run", false, "Only report what would be removed")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s undo [-dry-run] <manifest.jsonl>\n", filepath.Base(os.Args[0]))
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	records, err := readManifest(flagSet.Arg(0))
+	if err != nil {
+		fmt.Printf("Error reading manifest: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		fmt.Println("\n↩️  === Undo (dry run) ===")
+	} else {
+		fmt.Println("\n↩️  === Undo ===")
+	}
+
+	var removed, kept, missing int
+	for _, rec := range records {
+		if rec.Status != "ok" {
+			continue
+		}
+		keep, err := undoCheck(rec)
+		switch {
+		case os.IsNotExist(err):
+			missing++
+			fmt.Printf("❔ %s: missing\n", rec.Output)
+			continue
+		case err != nil:
+			kept++
+			fmt.Printf("⚠️  %s: kept (%v)\n", rec.Output, err)
+			continue
+		case keep != "":
+			kept++
+			fmt.Printf("🔒 %s: kept (%s)\n", rec.Output, keep)
+			continue
+		}
+
+		if *dryRun {
+			removed++
+			fmt.Printf("🗑️  %s: would remove\n", rec.Output)
+			continue
+		}
+		if err := os.Remove(rec.Output); err != nil {
+			kept++
+			fmt.Printf("⚠️  %s: kept (%v)\n", rec.Output, err)
+			continue
+		}
+		if err := removeStaleSidecar(rec.Output); err != nil {
+			fmt.Printf("⚠️  Could not remove sidecar of %s: %v\n", rec.Output, err)
+		}
+		removed++
+		fmt.Printf("🗑️  %s: removed\n", rec.Output)
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("\n%s: %d, kept: %d, missing: %d\n", verb, removed, kept, missing)
+}
+
+// undoCheck returns why the output of rec must be kept, or "" if it is
+// still exactly the file the run wrote.
+func undoCheck(rec manifestRecord) (string, error) {
+	if rec.Output == "" {
+		return "no output recorded", nil
+	}
+	// Never touch an original: if the output path is an input, the run
+	// overwrote it and the file is all the user has left.
+	if same, err := samePath(rec.Output, rec.Input); err != nil || same {
+		return "output is the original input", nil
+	}
+	if rec.OutputSHA256 == "" {
+		return "no checksum recorded", nil
+	}
+
+	f, err := os.Open(rec.Output)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.Size() != rec.OutputBytes {
+		return "size changed", nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != rec.OutputSHA256 {
+		return "content changed", nil
+	}
+	return "", nil
+}
+
+// samePath reports whether a and b name the same file.
+func samePath(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	if absA == absB {
+		return true, nil
+	}
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fa, fb), nil
+}
+
+// readManifest reads the records of a -manifest file.
+func readManifest(path string) ([]manifestRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []manifestRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec manifestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// verifyTolerance is the per-channel difference (out of 255) allowed when
+// comparing sampled border pixels, to absorb JPEG compression noise.
+const verifyTolerance = 8
+
+// verifyOutput re-opens and decodes a freshly written output, checking its
+// dimensions against the target canvas and that sampled border pixels
+// outside destRect have the expected background color.
+func verifyOutput(path string, config *Config, destRect image.Rectangle, background color.Color) error {
+	img, err := decodeOutput(path)
+	if err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	if b.Dx() != config.targetWidth || b.Dy() != config.targetHeight {
+		return fmt.Errorf("dimensions %dx%d, expected %dx%d", b.Dx(), b.Dy(), config.targetWidth, config.targetHeight)
+	}
+
+	// A blurred border has no single color to check.
+	if config.borderMode == borderModeBlur {
+		return nil
+	}
+
+	// Corners and edge midpoints, skipping any covered by the image itself.
+	w, h := b.Dx(), b.Dy()
+	samples := []image.Point{
+		{0, 0}, {w - 1, 0}, {0, h - 1}, {w - 1, h - 1},
+		{w / 2, 0}, {w / 2, h - 1}, {0, h / 2}, {w - 1, h / 2},
+	}
+	for _, p := range samples {
+		p = p.Add(b.Min)
+		if p.In(destRect) {
+			continue
+		}
+		if !colorsClose(img.At(p.X, p.Y), background, verifyTolerance) {
+			r, g, bl, _ := img.At(p.X, p.Y).RGBA()
+			return fmt.Errorf("border pixel at (%d,%d) is #%02x%02x%02x", p.X, p.Y, r>>8, g>>8, bl>>8)
+		}
+	}
+	return nil
+}
+
+// decodeOutput decodes an output file written by processImage.
+func decodeOutput(path string) (image.Image, error) {
+	openFiles.acquire()
+	defer openFiles.release()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var img image.Image
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		img, err = png.Decode(f)
+	case ".webp":
+		img, err = webp.Decode(f)
+	case ".gif":
+		img, err = gif.Decode(f)
+	case ".tif", ".tiff":
+		img, err = tiff.Decode(f)
+	default:
+		img, err = jpeg.Decode(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %v", err)
+	}
+	return img, nil
+}
+
+// colorsClose reports whether every channel of a and b differs by at most
+// tolerance (out of 255).
+func colorsClose(a, b color.Color, tolerance uint32) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	diff := func(x, y uint32) uint32 {
+		if x > y {
+			return (x - y) >> 8
+		}
+		return (y - x) >> 8
+	}
+	return diff(ar, br) <= tolerance && diff(ag, bg) <= tolerance &&
+		diff(ab, bb) <= tolerance && diff(aa, ba) <= tolerance
+}
+
+// Statuses reported by the verify subcommand.
+const (
+	auditOK          = "ok"
+	auditMissing     = "missing"
+	auditEmpty       = "empty"
+	auditMismatch    = "hash mismatch"
+	auditUndecodable = "undecodable"
+	auditDimensions  = "wrong dimensions"
+)
+
+// auditResult is the outcome of checking one manifest record.
+type auditResult struct {
+	output string
+	status string
+	detail string
+}
+
+// runVerify implements the verify subcommand: it checks concurrently that
+// every output listed in a -manifest still exists, is non-empty, matches its
+// recorded size and SHA-256, decodes, and has the recorded dimensions. It
+// exits with status 1 if any output fails.
+func runVerify(args []string) {
+	flagSet := flag.NewFlagSet("verify", flag.ExitOnError)
+	workers := flagSet.Int("workers", runtime.NumCPU(), "Number of files checked concurrently")
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %s verify [-workers n] <manifest.jsonl>\n", filepath.Base(os.Args[0]))
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 || *workers < 1 {
+		flagSet.Usage()
+		os.Exit(1)
+	}
+
+	records, err := readManifest(flagSet.Arg(0))
+	if err != nil {
+		fmt.Printf("Error reading manifest: %v\n", err)
+		os.Exit(1)
+	}
+	var checked []manifestRecord
+	for _, rec := range records {
+		if rec.Status == "ok" {
+			checked = append(checked, rec)
+		}
+	}
+
+	results := make([]auditResult, len(checked))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				results[idx] = auditOutput(checked[idx])
+			}
+		}()
+	}
+	for i := range checked {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	fmt.Printf("\n🔎 === Verify (%d outputs) ===\n", len(checked))
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.status]++
+		if r.status == auditOK {
+			fmt.Printf("✅ %s\n", r.output)
+		} else if r.detail != "" {
+			fmt.Printf("❌ %s: %s (%s)\n", r.output, r.status, r.detail)
+		} else {
+			fmt.Printf("❌ %s: %s\n", r.output, r.status)
+		}
+	}
+
+	fmt.Printf("\nOK: %d, missing: %d, empty: %d, hash mismatch: %d, undecodable: %d, wrong dimensions: %d\n",
+		counts[auditOK], counts[auditMissing], counts[auditEmpty], counts[auditMismatch],
+		counts[auditUndecodable], counts[auditDimensions])
+	if skipped := len(records) - len(checked); skipped > 0 {
+		fmt.Printf("(%d failed images in the manifest have no output to check)\n", skipped)
+	}
+	if counts[auditOK] != len(checked) {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
+
+// auditOutput checks the output of one manifest record.
+func auditOutput(rec manifestRecord) auditResult {
+	res := auditResult{output: rec.Output, status: auditOK}
+
+	data, err := os.ReadFile(rec.Output)
+	switch {
+	case os.IsNotExist(err):
+		res.status = auditMissing
+		return res
+	case err != nil:
+		res.status, res.detail = auditMissing, err.Error()
+		return res
+	case len(data) == 0:
+		res.status = auditEmpty
+		return res
+	}
+
+	if rec.OutputBytes > 0 && int64(len(data)) != rec.OutputBytes {
+		res.status = auditMismatch
+		res.detail = fmt.Sprintf("%d bytes, recorded %d", len(data), rec.OutputBytes)
+		return res
+	}
+	if rec.OutputSHA256 != "" {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != rec.OutputSHA256 {
+			res.status = auditMismatch
+			return res
+		}
+	}
+
+	// JPEG XL outputs are covered by the hash check alone.
+	if strings.ToLower(filepath.Ext(rec.Output)) == ".jxl" {
+		return res
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		res.status, res.detail = auditUndecodable, err.Error()
+		return res
+	}
+	b := img.Bounds()
+	if rec.OutputWidth > 0 && (b.Dx() != rec.OutputWidth || b.Dy() != rec.OutputHeight) {
+		res.status = auditDimensions
+		res.detail = fmt.Sprintf("%dx%d, recorded %dx%d", b.Dx(), b.Dy(), rec.OutputWidth, rec.OutputHeight)
+	}
+	return res
+}
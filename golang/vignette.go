@@ -0,0 +1,71 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/Autherain/white_border_adder/golang/border"
+)
+
+// vignetteFactor returns what -vignette multiplies the pixel at (x, y) by:
+// 1 at the center of dest, falling off with the fourth power of the
+// distance from it, normalized so the corners of dest get 1-strength. The
+// middle of each side only loses a quarter of that, which keeps the
+// darkening near the edges.
+func vignetteFactor(dest image.Rectangle, x, y int, strength float64) float64 {
+	dx := (float64(x-dest.Min.X)+0.5)/float64(dest.Dx())*2 - 1
+	dy := (float64(y-dest.Min.Y)+0.5)/float64(dest.Dy())*2 - 1
+	r2 := (dx*dx + dy*dy) / 2
+	return 1 - strength*r2*r2
+}
+
+// drawVignette darkens the image drawn at dest on canvas toward its edges
+// by strength (0-1), leaving the border, including what shows through
+// corners rounded by cornerRadius, untouched. Only the color channels are
+// scaled, so premultiplied pixels stay valid.
+func drawVignette(canvas image.Image, dest image.Rectangle, cornerRadius int, strength float64) {
+	dest = dest.Intersect(canvas.Bounds())
+	if dest.Empty() || strength <= 0 {
+		return
+	}
+	factor := func(x, y int) float64 {
+		f := vignetteFactor(dest, x, y, strength)
+		if cornerRadius > 0 {
+			f = 1 - (1-f)*border.Coverage(dest, cornerRadius, x, y)
+		}
+		return f
+	}
+	switch c := canvas.(type) {
+	case *image.RGBA:
+		for y := dest.Min.Y; y < dest.Max.Y; y++ {
+			for x := dest.Min.X; x < dest.Max.X; x++ {
+				f := factor(x, y)
+				p := c.Pix[c.PixOffset(x, y):]
+				p[0] = uint8(float64(p[0])*f + 0.5)
+				p[1] = uint8(float64(p[1])*f + 0.5)
+				p[2] = uint8(float64(p[2])*f + 0.5)
+			}
+		}
+	case *image.Gray:
+		for y := dest.Min.Y; y < dest.Max.Y; y++ {
+			for x := dest.Min.X; x < dest.Max.X; x++ {
+				i := c.PixOffset(x, y)
+				c.Pix[i] = uint8(float64(c.Pix[i])*factor(x, y) + 0.5)
+			}
+		}
+	case interface {
+		RGBA64At(x, y int) color.RGBA64
+		SetRGBA64(x, y int, c color.RGBA64)
+	}:
+		for y := dest.Min.Y; y < dest.Max.Y; y++ {
+			for x := dest.Min.X; x < dest.Max.X; x++ {
+				f := factor(x, y)
+				p := c.RGBA64At(x, y)
+				p.R = uint16(float64(p.R)*f + 0.5)
+				p.G = uint16(float64(p.G)*f + 0.5)
+				p.B = uint16(float64(p.B)*f + 0.5)
+				c.SetRGBA64(x, y, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestVignette(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 80, 60))
+	for i := range gray.Pix {
+		gray.Pix[i] = 160
+	}
+	sources := map[string]image.Image{
+		"rgba": fill(80, 60, color.RGBA{180, 140, 100, 255}),
+		"gray": gray,
+	}
+
+	for name, src := range sources {
+		config := testConfig()
+		var plainInfo imageInfo
+		plain, _ := renderCanvas(src, "out.png", config, &plainInfo)
+		config.vignette = 0.6
+		var info imageInfo
+		vignetted, _ := renderCanvas(src, "out.png", config, &info)
+
+		dest := info.layout.destRect
+		center := image.Pt((dest.Min.X+dest.Max.X)/2, (dest.Min.Y+dest.Max.Y)/2)
+		if !near(vignetted.At(center.X, center.Y), plain.At(center.X, center.Y), 1) {
+			t.Errorf("%s: center %v became %v", name, plain.At(center.X, center.Y), vignetted.At(center.X, center.Y))
+		}
+		r0, _, _, _ := plain.At(dest.Min.X, dest.Min.Y).RGBA()
+		r1, _, _, _ := vignetted.At(dest.Min.X, dest.Min.Y).RGBA()
+		if float64(r1) > 0.6*float64(r0) {
+			t.Errorf("%s: corner of the image only went from %d to %d", name, r0>>8, r1>>8)
+		}
+		if !near(vignetted.At(0, 0), plain.At(0, 0), 0) {
+			t.Errorf("%s: border %v became %v", name, plain.At(0, 0), vignetted.At(0, 0))
+		}
+	}
+}
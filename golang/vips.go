@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Processing backends selectable with -backend.
+const (
+	backendGo   = "go"
+	backendVips = "vips"
+)
+
+// vipsSubsampleMode maps the -jpeg-subsampling values vips supports to
+// its jpegsave subsample-mode.
+var vipsSubsampleMode = map[string]string{
+	subsampling420: "on",
+	subsampling444: "off",
+}
+
+// vipsAvailable reports whether the libvips command-line tool is installed.
+func vipsAvailable() bool {
+	_, err := exec.LookPath("vips")
+	return err == nil
+}
+
+// processImageVips renders job with the libvips command-line tool: vips
+// shrinks the source straight to the fitted size computed by computeLayout,
+// then embeds it at the same offset on a canvas of the border color, so the
+// geometry is identical to the Go path. Only JPEG and PNG inputs are handled
+// here; processImage keeps the others.
+func processImageVips(job imageJob, config *Config) (imageInfo, error) {
+	var info imageInfo
+
+	openFiles.acquire()
+	input, err := os.Open(job.inputPath)
+	if err != nil {
+		openFiles.release()
+		return info, fmt.Errorf("error opening input file: %v", err)
+	}
+	if fi, err := input.Stat(); err == nil {
+		info.inputBytes = fi.Size()
+	}
+	cfg, format, err := image.DecodeConfig(input)
+	if err == nil && format == "jpeg" {
+		if _, err := input.Seek(0, io.SeekStart); err == nil && swapsAxes(readJPEGOrientation(input)) {
+			cfg.Width, cfg.Height = cfg.Height, cfg.Width
+		}
+	}
+	input.Close()
+	openFiles.release()
+	if err != nil {
+		return info, fmt.Errorf("error decoding image: %v", err)
+	}
+	info.format = format
+	info.sourceWidth, info.sourceHeight = cfg.Width, cfg.Height
+	info.outputWidth, info.outputHeight = config.targetWidth, config.targetHeight
+	info.background, info.luminance = config.backgroundColor, -1
+	info.edgeBlend = -1
+	l := computeLayout(cfg.Width, cfg.Height, config)
+	info.layout = l
+
+	tmpDir, err := os.MkdirTemp("", "wbi-vips-*")
+	if err != nil {
+		return info, fmt.Errorf("error creating vips work folder: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fitted := filepath.Join(tmpDir, "fitted.v")
+	if err := runVips("thumbnail", job.inputPath, fitted, fmt.Sprint(l.destRect.Dx()),
+		"--height", fmt.Sprint(l.destRect.Dy()), "--size", "force"); err != nil {
+		return info, err
+	}
+
+	isPNG := strings.ToLower(filepath.Ext(job.outputPath)) == ".png"
+	rendered := filepath.Join(tmpDir, "rendered.png[strip]")
+	if !isPNG {
+		rendered = filepath.Join(tmpDir, fmt.Sprintf("rendered.jpg[Q=%d,strip,subsample-mode=%s]", config.jpegQuality, vipsSubsampleMode[config.jpegSubsampling]))
+	}
+	bg := info.background
+	if err := runVips("embed", fitted, rendered,
+		fmt.Sprint(l.destRect.Min.X), fmt.Sprint(l.destRect.Min.Y),
+		fmt.Sprint(config.targetWidth), fmt.Sprint(config.targetHeight),
+		"--extend", "background", "--background", fmt.Sprintf("%d %d %d", bg.R, bg.G, bg.B)); err != nil {
+		return info, err
+	}
+
+	// Copy the vips output through writeOutput so it gets the processing
+	// marker and -fsync handling like any other output.
+	renderedPath := rendered[:strings.IndexByte(rendered, '[')]
+	info.outputBytes, info.outputSHA256, err = writeOutput(job.outputPath, config, l.isLandscape, nil, func(w io.Writer) error {
+		f, err := os.Open(renderedPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return info, err
+	}
+
+	if config.verifyOutputs {
+		verifyStart := time.Now()
+		err := verifyOutput(job.outputPath, config, l.destRect, info.background)
+		info.verifyDuration = time.Since(verifyStart)
+		if err != nil {
+			os.Remove(job.outputPath)
+			return info, fmt.Errorf("output verification failed: %v", err)
+		}
+	}
+
+	if job.wantThumbnail || job.wantPreview {
+		out, err := decodeOutput(job.outputPath)
+		if err != nil {
+			return info, fmt.Errorf("error decoding output: %v", err)
+		}
+		if job.wantThumbnail {
+			if info.thumbnail, err = encodeThumbnail(out, reportThumbnailSize); err != nil {
+				return info, err
+			}
+		}
+		if job.wantPreview {
+			info.preview = renderPreview(out)
+		}
+	}
+
+	return info, nil
+}
+
+// runVips runs one vips operation, returning its error output on failure.
+func runVips(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("vips", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("vips %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// vipsHandles reports whether the vips backend processes inputs with this
+// extension.
+func vipsHandles(ext string) bool {
+	format, _ := inputFormatFor(ext)
+	return format == "jpeg" || format == "png"
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// TestVipsConformance renders the same inputs with both backends: the
+// outputs must have the same size and place the photo at the same
+// rectangle, with a border of the same color and a photo that differs only
+// by resampling and encoding noise.
+func TestVipsConformance(t *testing.T) {
+	if !vipsAvailable() {
+		t.Skip("vips is not installed")
+	}
+	dir := t.TempDir()
+	inputs := map[string]image.Image{
+		"landscape.jpg": photo(t, 320, 200),
+		"portrait.jpg":  photo(t, 150, 260),
+		"flat.png":      fill(200, 120, color.RGBA{30, 120, 200, 255}),
+	}
+	for name, img := range inputs {
+		writeImage(t, filepath.Join(dir, name), img)
+	}
+
+	for name := range inputs {
+		for _, ext := range []string{".jpg", ".png"} {
+			t.Run(name+ext, func(t *testing.T) {
+				outputs := make(map[string]image.Image)
+				layouts := make(map[string]layout)
+				for _, backend := range []string{backendGo, backendVips} {
+					config := testConfig()
+					config.targetWidth, config.targetHeight = 300, 240
+					config.backend = backend
+					job := imageJob{inputPath: filepath.Join(dir, name), outputPath: filepath.Join(dir, backend+"_"+name+ext)}
+					info, err := processImage(context.Background(), job, config)
+					if err != nil {
+						t.Fatalf("%s backend: %v", backend, err)
+					}
+					outputs[backend], layouts[backend] = readImage(t, job.outputPath), info.layout
+				}
+				goOut, vipsOut := outputs[backendGo], outputs[backendVips]
+				if goOut.Bounds() != vipsOut.Bounds() {
+					t.Fatalf("output bounds %v (go) and %v (vips) differ", goOut.Bounds(), vipsOut.Bounds())
+				}
+				dest := layouts[backendGo].destRect
+				if dest != layouts[backendVips].destRect {
+					t.Fatalf("photo at %v (go) and %v (vips)", dest, layouts[backendVips].destRect)
+				}
+
+				tolerance := 0
+				if ext == ".jpg" {
+					tolerance = 3
+				}
+				var total, pixels int
+				b := goOut.Bounds()
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					for x := b.Min.X; x < b.Max.X; x++ {
+						g, v := goOut.At(x, y), vipsOut.At(x, y)
+						inPhoto := image.Pt(x, y).In(dest)
+						// Pixels right at the edge mix with the border in
+						// JPEG blocks; leave them to the mean.
+						if !inPhoto && !image.Pt(x, y).In(dest.Inset(-8)) && !near(g, v, tolerance) {
+							t.Fatalf("border pixel (%d,%d) is %v (go) and %v (vips)", x, y, g, v)
+						}
+						if inPhoto {
+							gr, gg, gb, _ := g.RGBA()
+							vr, vg, vb, _ := v.RGBA()
+							for _, d := range []int{int(gr>>8) - int(vr>>8), int(gg>>8) - int(vg>>8), int(gb>>8) - int(vb>>8)} {
+								total += max(d, -d)
+							}
+							pixels++
+						}
+					}
+				}
+				if mean := float64(total) / float64(3*pixels); mean > 6 {
+					t.Errorf("photos differ by %.1f levels on average", mean)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// inputFile is a file of the input folder, with its path relative to it.
+type inputFile struct {
+	rel   string
+	entry os.DirEntry
+}
+
+// listInputs returns the files of inputFolder, in listing order. With
+// recursive, files in subfolders are included too, except under
+// outputFolder so a run never picks up its own results.
+func listInputs(inputFolder, outputFolder string, recursive bool) ([]inputFile, error) {
+	if !recursive {
+		entries, err := os.ReadDir(inputFolder)
+		if err != nil {
+			return nil, err
+		}
+		var files []inputFile
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, inputFile{rel: entry.Name(), entry: entry})
+			}
+		}
+		return files, nil
+	}
+
+	skip, _ := filepath.Abs(outputFolder)
+	var files []inputFile
+	err := filepath.WalkDir(inputFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if abs, _ := filepath.Abs(path); abs == skip && path != inputFolder {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(inputFolder, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, inputFile{rel: rel, entry: entry})
+		return nil
+	})
+	return files, err
+}
+
+// filterPattern returns the files whose name, without their folder,
+// matches the -pattern glob; all of them when pattern is empty. The
+// pattern has been checked by parseFlags, so it is well-formed.
+func filterPattern(files []inputFile, pattern string) []inputFile {
+	if pattern == "" {
+		return files
+	}
+	var matched []inputFile
+	for _, file := range files {
+		if ok, _ := filepath.Match(pattern, file.entry.Name()); ok {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// outputPathFor returns where the output of the input at rel is written:
+// under outputFolder, in the same subfolder as the input. Inputs listed by
+// -stdin get the output folder of their own folder instead, unless -output
+// or an SFTP target gathers them.
+func outputPathFor(config *Config, outputFolder, rel, outputName string) string {
+	name := filepath.Join(filepath.Dir(rel), config.outputPrefix+outputName)
+	if config.stdin && config.outputDir == "" && config.sftp == nil {
+		return filepath.Join(outputFolderFor(config, filepath.Dir(rel)), config.outputPrefix+outputName)
+	}
+	if config.sftp != nil {
+		return config.sftp.join(filepath.ToSlash(name))
+	}
+	return filepath.Join(outputFolder, name)
+}
+
+// runInputs returns the input files of a run, filtered by -pattern: the
+// -stdin list, or the files of inputFolder. source names where they came
+// from, for messages.
+func runInputs(config *Config, inputFolder, outputFolder string) (files []inputFile, source string, err error) {
+	if config.stdin {
+		if files, err = readInputList(os.Stdin); err != nil {
+			return nil, "", fmt.Errorf("reading the input list: %v", err)
+		}
+		return filterPattern(files, config.pattern), "the input list", nil
+	}
+	if files, err = listInputs(inputFolder, outputFolder, config.recursive); err != nil {
+		return nil, "", fmt.Errorf("reading directory: %v", err)
+	}
+	return filterPattern(files, config.pattern), inputFolder, nil
+}
+
+// plannedJobs returns the jobs a run over inputFolder would process,
+// without creating any folder, for -diff-settings to find their outputs.
+func plannedJobs(config *Config, inputFolder, outputFolder string) ([]imageJob, error) {
+	files, _, err := runInputs(config, inputFolder, outputFolder)
+	if err != nil {
+		return nil, err
+	}
+	planner := newJobPlanner(config, inputFolder, outputFolder, false)
+	var jobs []imageJob
+	for i, file := range files {
+		planned, _ := planner.plan(i, file.rel)
+		jobs = append(jobs, planned...)
+	}
+	return jobs, nil
+}
+
+// jobPlanner turns the input files of a run into jobs, with the output
+// paths they are written to. runFolder and -diff-settings share it, so
+// the outputs compared are the ones a run writes.
+type jobPlanner struct {
+	config                    *Config
+	inputFolder, outputFolder string
+	// makeDirs creates the output folder of each job as it is planned.
+	makeDirs    bool
+	madeDirs    map[string]bool
+	sizeConfigs []*Config
+	// seenImages counts the images planned, before -shard and
+	// -min-rating; belowRating those -min-rating left out.
+	seenImages  int
+	belowRating int
+}
+
+func newJobPlanner(config *Config, inputFolder, outputFolder string, makeDirs bool) *jobPlanner {
+	return &jobPlanner{
+		config:       config,
+		inputFolder:  inputFolder,
+		outputFolder: outputFolder,
+		makeDirs:     makeDirs,
+		madeDirs:     make(map[string]bool),
+		sizeConfigs:  config.sizeConfigs(),
+	}
+}
+
+// plan returns the jobs for the input file at rel: one per page of a
+// multi-page TIFF and per -size value, or none when it is not an image,
+// belongs to another -shard or is rated below -min-rating.
+func (p *jobPlanner) plan(index int, rel string) ([]imageJob, error) {
+	config := p.config
+	if _, ok := outputNameFor(filepath.Base(rel), config); !ok {
+		return nil, nil
+	}
+	p.seenImages++
+	if !config.shard.owns(rel) {
+		return nil, nil
+	}
+
+	inputPath := filepath.Join(p.inputFolder, rel)
+	if config.minRating > 0 && !passesRating(inputPath, config) {
+		p.belowRating++
+		return nil, nil
+	}
+	outputName, _ := outputNameForContent(inputPath, config)
+	outputPath := outputPathFor(config, p.outputFolder, rel, outputName)
+	if dir := filepath.Dir(outputPath); p.makeDirs && !p.madeDirs[dir] {
+		// Subfolders of a -recursive run are mirrored in the output.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("creating output folder: %v", err)
+		}
+		p.madeDirs[dir] = true
+	}
+
+	// Every page of a multi-page TIFF is an image of its own.
+	pages := 1
+	if format, _ := inputFormatFor(filepath.Ext(inputPath)); format == "tiff" {
+		pages = tiffPageCount(inputPath)
+	}
+	var jobs []imageJob
+	for page := 1; page <= pages; page++ {
+		job := imageJob{index: index, inputPath: inputPath, outputPath: outputPath}
+		if pages > 1 {
+			job.page = page
+			job.outputPath = pageOutputPath(outputPath, page, pages)
+		}
+		jobs = append(jobs, sizeJobs(job, p.sizeConfigs)...)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPattern(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"IMG_001.jpg", "IMG_002.png", "DSC_003.jpg"} {
+		writeImage(t, filepath.Join(dir, name), fill(30, 20, color.RGBA{90, 90, 90, 255}))
+	}
+	// Matching the pattern does not make other files images.
+	if err := os.WriteFile(filepath.Join(dir, "IMG_notes.txt"), []byte("notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("match", func(t *testing.T) {
+		config := testConfig()
+		config.pattern = "IMG_*"
+		var processed []string
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			processed = append(processed, r.filename)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(processed)
+		if want := []string{"IMG_001.jpg", "IMG_002.png"}; !slices.Equal(processed, want) {
+			t.Errorf("processed %v, want %v", processed, want)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		config := testConfig()
+		config.pattern = "*.gif"
+		called := false
+		err := runFolder(context.Background(), config, dir, time.Now(), func(r processingResult, total int) {
+			called = true
+		})
+		if err == nil || !strings.Contains(err.Error(), `match -pattern "*.gif"`) {
+			t.Errorf("runFolder() = %v, want an error naming the pattern", err)
+		}
+		if called {
+			t.Error("an image was processed")
+		}
+	})
+}
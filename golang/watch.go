@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long a file added to a -watch folder must go without
+// being written to before it is processed, so that a file still being
+// copied in is not decoded half-written.
+const watchSettle = 2 * time.Second
+
+// validateWatch checks -watch against the modes it cannot follow.
+func (c *Config) validateWatch() error {
+	if !c.watch {
+		return nil
+	}
+	switch {
+	case c.jobsPath != "" || c.stdin:
+		return fmt.Errorf("-watch works on an input folder, not with -jobs or -stdin")
+	case c.recursive:
+		return fmt.Errorf("-watch only watches the input folder itself and cannot be combined with -recursive")
+	case c.dryRun || c.diffSettings || c.tune:
+		return fmt.Errorf("-watch cannot be combined with -dry-run, -diff-settings or -tune")
+	}
+	return nil
+}
+
+// watchFolder watches folder for files created in it, or moved or copied
+// into it, and sends the name of each once it has settled: watchSettle has
+// passed since it was last written. The channel is closed once ctx is
+// canceled.
+func watchFolder(ctx context.Context, folder string) (<-chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(folder); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	added := make(chan string)
+	go func() {
+		defer close(added)
+		defer watcher.Close()
+
+		// written holds the files still being written, with when they last
+		// were.
+		written := make(map[string]time.Time)
+		tick := time.NewTicker(watchSettle / 4)
+		defer tick.Stop()
+		for {
+			select {
+			case event := <-watcher.Events:
+				switch {
+				case event.Has(fsnotify.Create) || event.Has(fsnotify.Write):
+					written[event.Name] = time.Now()
+				case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+					delete(written, event.Name)
+				}
+			case err := <-watcher.Errors:
+				fmt.Printf("⚠️  Watching %s: %v\n", folder, err)
+			case now := <-tick.C:
+				for path, last := range written {
+					if now.Sub(last) < watchSettle {
+						continue
+					}
+					delete(written, path)
+					if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+						continue
+					}
+					select {
+					case added <- filepath.Base(path):
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return added, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchProcessesAddedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "first.jpg"), fill(60, 40, color.RGBA{200, 0, 0, 255}))
+
+	config := testConfig()
+	config.watch, config.verbose = true, true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan processingResult, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- runFolder(ctx, config, dir, time.Now(), func(r processingResult, total int) { results <- r })
+	}()
+
+	// The folder is watched before the first image is processed.
+	wait := func(name string) {
+		t.Helper()
+		select {
+		case r := <-results:
+			if r.error != nil || filepath.Base(r.inputPath) != name {
+				t.Fatalf("got result for %s (error %v), want %s", r.inputPath, r.error, name)
+			}
+		case <-time.After(watchSettle + 10*time.Second):
+			t.Fatalf("%s was not processed", name)
+		}
+	}
+	wait("first.jpg")
+	writeImage(t, filepath.Join(dir, "second.jpg"), fill(40, 60, color.RGBA{0, 0, 200, 255}))
+	wait("second.jpg")
+
+	if _, err := os.Stat(filepath.Join(dir, "bordered_images", "bordered_second.jpg")); err != nil {
+		t.Fatalf("output of the added file: %v", err)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errInterrupted) {
+			t.Fatalf("runFolder() = %v, want errInterrupted", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("runFolder did not return after cancel")
+	}
+}
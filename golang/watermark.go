@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// watermarkMargin is the gap between a -watermark in a corner and the
+// edges of the canvas, as a fraction of the canvas's shorter side.
+const watermarkMargin = 0.02
+
+// watermarkPositions are the -watermark-position values.
+var watermarkPositions = map[string]bool{"tl": true, "tr": true, "bl": true, "br": true, "center": true}
+
+// loadWatermark decodes the -watermark image and checks its options.
+func (c *Config) loadWatermark() error {
+	if c.watermarkPath == "" {
+		return nil
+	}
+	switch {
+	case !watermarkPositions[c.watermarkPosition]:
+		return fmt.Errorf("invalid -watermark-position value %q (expected tl, tr, bl, br or center)", c.watermarkPosition)
+	case c.watermarkOpacity < 0 || c.watermarkOpacity > 1:
+		return fmt.Errorf("-watermark-opacity must be between 0 and 1, got %g", c.watermarkOpacity)
+	}
+	f, err := os.Open(c.watermarkPath)
+	if err != nil {
+		return fmt.Errorf("-watermark: %v", err)
+	}
+	defer f.Close()
+	img, _, err := decodeSafely(f)
+	if err != nil {
+		return fmt.Errorf("-watermark: %v", err)
+	}
+	if img.Bounds().Empty() {
+		return fmt.Errorf("-watermark: %s is empty", c.watermarkPath)
+	}
+	c.watermark = img
+	return nil
+}
+
+// drawWatermark composites the -watermark onto canvas at
+// -watermark-position, its alpha scaled by -watermark-opacity. A watermark
+// that does not fit inside the margins is scaled down to fit first.
+func drawWatermark(canvas draw.Image, config *Config) {
+	wm := config.watermark
+	cb := canvas.Bounds()
+	margin := int(math.Round(watermarkMargin * min(float64(cb.Dx()), float64(cb.Dy()))))
+	room := cb.Inset(margin)
+	if config.watermarkPosition == "center" {
+		room = cb
+	}
+
+	size := wm.Bounds().Size()
+	if size.X > room.Dx() || size.Y > room.Dy() {
+		scale := math.Min(float64(room.Dx())/float64(size.X), float64(room.Dy())/float64(size.Y))
+		size = image.Pt(max(1, int(float64(size.X)*scale)), max(1, int(float64(size.Y)*scale)))
+		scaled := image.NewRGBA(image.Rectangle{Max: size})
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), wm, wm.Bounds(), draw.Src, nil)
+		wm = scaled
+	}
+
+	at := room.Min
+	switch config.watermarkPosition {
+	case "tr":
+		at.X = room.Max.X - size.X
+	case "bl":
+		at.Y = room.Max.Y - size.Y
+	case "br":
+		at = room.Max.Sub(size)
+	case "center":
+		at = room.Min.Add(room.Size().Sub(size).Div(2))
+	}
+	mask := image.NewUniform(color.Alpha{uint8(math.Round(255 * config.watermarkOpacity))})
+	draw.DrawMask(canvas, image.Rectangle{at, at.Add(size)}, wm, wm.Bounds().Min, mask, image.Point{}, draw.Over)
+}
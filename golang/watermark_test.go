@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatermarkPosition(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.png")
+	writeImage(t, input, fill(40, 40, color.RGBA{0, 0, 200, 255}))
+
+	// A 10x10 watermark on the 120x120 canvas, 2px from the edges.
+	centers := map[string]image.Point{
+		"tl":     {7, 7},
+		"tr":     {112, 7},
+		"bl":     {7, 112},
+		"br":     {112, 112},
+		"center": {60, 60},
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	for position := range centers {
+		config := testConfig()
+		config.watermark, config.watermarkPosition = fill(10, 10, red), position
+		output := filepath.Join(dir, position+".png")
+		if _, err := processImage(context.Background(), imageJob{inputPath: input, outputPath: output}, config); err != nil {
+			t.Fatal(err)
+		}
+		img := readImage(t, output)
+		for other, p := range centers {
+			r, g, b, _ := img.At(p.X, p.Y).RGBA()
+			isRed := r>>8 == 255 && g>>8 == 0 && b>>8 == 0
+			if isRed != (other == position) {
+				t.Errorf("-watermark-position %s: pixel at %v (%s) is %v", position, p, other, img.At(p.X, p.Y))
+			}
+		}
+	}
+}
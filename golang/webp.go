@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WebP output goes through cwebp, the libwebp reference encoder, the same
+// way JPEG XL goes through cjxl: x/image can only decode WebP.
+
+// cwebpAvailable reports whether the libwebp encoder is installed.
+func cwebpAvailable() bool {
+	_, err := exec.LookPath("cwebp")
+	return err == nil
+}
+
+// validateWebP checks the options of -output-encoding webp.
+func (c *Config) validateWebP() error {
+	switch {
+	case !cwebpAvailable():
+		return fmt.Errorf("-output-encoding webp needs the cwebp encoder from libwebp, which was not found in PATH")
+	case c.webpQuality < 0 || c.webpQuality > 100:
+		return fmt.Errorf("-webp-quality must be between 0 and 100, got %d", c.webpQuality)
+	case c.targetSSIM > 0:
+		return fmt.Errorf("-target-ssim only applies to JPEG outputs, not -output-encoding webp")
+	case c.backend == backendVips:
+		return fmt.Errorf("-backend vips does not support -output-encoding webp")
+	}
+	return nil
+}
+
+// encodeWebP encodes canvas as lossy WebP at quality (0-100) with cwebp and
+// writes the result to w.
+func encodeWebP(w io.Writer, canvas *image.RGBA, quality int) error {
+	tmpDir, err := os.MkdirTemp("", "wbi-webp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	input := filepath.Join(tmpDir, "canvas.ppm")
+	if err := writePPM(input, canvas); err != nil {
+		return err
+	}
+
+	output := filepath.Join(tmpDir, "canvas.webp")
+	var stderr bytes.Buffer
+	cmd := exec.Command("cwebp", "-quiet", "-q", fmt.Sprint(quality), input, "-o", output)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cwebp failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
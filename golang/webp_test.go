@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBorderWebPInput(t *testing.T) {
+	// The fixture comes from golang.org/x/image's own WebP tests.
+	data, err := os.ReadFile(filepath.Join("testdata", "blue-purple-pink.lossy.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.webp")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := readImage(t, input)
+
+	config := testConfig()
+	job := imageJob{inputPath: input, outputPath: filepath.Join(dir, "out.jpg")}
+	info, err := processImage(context.Background(), job, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.format != "webp" {
+		t.Errorf("decoded as %q, want webp", info.format)
+	}
+	if info.sourceWidth != src.Bounds().Dx() || info.sourceHeight != src.Bounds().Dy() {
+		t.Errorf("source size %dx%d, want %v", info.sourceWidth, info.sourceHeight, src.Bounds().Size())
+	}
+
+	out := readImage(t, job.outputPath)
+	if out.Bounds().Dx() != config.targetWidth || out.Bounds().Dy() != config.targetHeight {
+		t.Fatalf("output is %v, want %dx%d", out.Bounds().Size(), config.targetWidth, config.targetHeight)
+	}
+	if !near(out.At(0, 0), config.backgroundColor, 2) {
+		t.Errorf("border is %v, want %v", out.At(0, 0), config.backgroundColor)
+	}
+	dest := info.layout.destRect
+	center := src.At(src.Bounds().Dx()/2, src.Bounds().Dy()/2)
+	if got := out.At((dest.Min.X+dest.Max.X)/2, (dest.Min.Y+dest.Max.Y)/2); !near(got, center, 24) {
+		t.Errorf("center of the photo is %v, want about %v", got, center)
+	}
+}
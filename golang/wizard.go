@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// borderFeels are the border choices offered by -wizard, as factors applied
+// to the default ratios. medium is the default look.
+var borderFeels = []struct {
+	name   string
+	factor float64
+}{
+	{"thin", 0.5},
+	{"medium", 1},
+	{"thick", 2},
+}
+
+// wizard asks its questions on the terminal.
+type wizard struct {
+	in *bufio.Reader
+}
+
+// ask prints question with its default and returns the answer, or def when
+// the answer is empty.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		fmt.Println()
+		return "", errors.New("wizard cancelled")
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// askYesNo asks a yes/no question.
+func (w *wizard) askYesNo(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := w.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Println("Please answer y or n.")
+	}
+}
+
+// askInt asks for a positive number.
+func (w *wizard) askInt(question string, def int) (int, error) {
+	for {
+		answer, err := w.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n > 0 {
+			return n, nil
+		}
+		fmt.Println("Please enter a positive whole number.")
+	}
+}
+
+// runWizard asks for the settings of a run and returns the equivalent
+// command-line arguments. The flags already given with -wizard are kept, and
+// the positional argument is the default input folder.
+func runWizard(flagSet *flag.FlagSet) ([]string, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("-wizard needs an interactive terminal; pass flags instead when stdin is not a TTY")
+	}
+
+	var args []string
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name != "wizard" && f.Name != "input" {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	defaultInput := flagSet.Lookup("input").Value.String()
+	if defaultInput == "" {
+		defaultInput = flagSet.Arg(0)
+	}
+	if defaultInput == "" {
+		defaultInput = "."
+	}
+
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+	fmt.Println("\n=== Setup wizard (press Enter to accept the default in brackets) ===")
+
+	var inputFolder string
+	for {
+		answer, err := w.ask("Input folder", defaultInput)
+		if err != nil {
+			return nil, err
+		}
+		n, err := countInputImages(answer, flagSet.Lookup("recursive").Value.String() == "true")
+		if err != nil {
+			fmt.Printf("Cannot use %s: %v\n", answer, err)
+			continue
+		}
+		if n == 0 {
+			fmt.Printf("No supported images in %s.\n", answer)
+			continue
+		}
+		fmt.Printf("Found %d image(s).\n", n)
+		inputFolder = answer
+		break
+	}
+
+	usedPreset, err := w.askPreset(&args)
+	if err != nil {
+		return nil, err
+	}
+	if !usedPreset {
+		if err := w.askLook(&args); err != nil {
+			return nil, err
+		}
+	}
+
+	defaultOutput := outputFolderFor(&defaultConfig, inputFolder)
+	output, err := w.ask("Output folder", defaultOutput)
+	if err != nil {
+		return nil, err
+	}
+	if output != defaultOutput {
+		args = append(args, "-output="+output)
+	}
+
+	name, err := w.ask("Save these settings as a preset named (Enter to skip)", "")
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		args = append(args, "-save-preset="+name)
+		if presetExists(name) {
+			overwrite, err := w.askYesNo(fmt.Sprintf("Preset %q exists. Overwrite it?", name), false)
+			if err != nil {
+				return nil, err
+			}
+			if !overwrite {
+				return nil, fmt.Errorf("preset %q already exists", name)
+			}
+			args = append(args, "-force")
+		}
+	}
+	args = append(args, inputFolder)
+
+	fmt.Printf("\nEquivalent command:\n  %s\n\n", shellJoin(append([]string{filepath.Base(os.Args[0])}, args...)))
+	run, err := w.askYesNo("Run it now?", true)
+	if err != nil {
+		return nil, err
+	}
+	if !run {
+		if name != "" {
+			// Saving the preset still happens when asked for.
+			return args[:len(args)-1], nil
+		}
+		os.Exit(0)
+	}
+	return args, nil
+}
+
+// askPreset offers the saved presets and reports whether one was picked.
+func (w *wizard) askPreset(args *[]string) (bool, error) {
+	path, err := userConfigPath()
+	if err != nil {
+		return false, nil
+	}
+	_, presets, err := readUserConfig(path)
+	if err != nil || len(presets) == 0 {
+		return false, nil
+	}
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("Saved presets: %s\n", strings.Join(names, ", "))
+	for {
+		answer, err := w.ask("Preset to use (Enter to choose size and borders)", "")
+		if err != nil {
+			return false, err
+		}
+		if answer == "" {
+			return false, nil
+		}
+		if _, ok := presets[answer]; ok {
+			*args = append(*args, "-preset="+answer)
+			return true, nil
+		}
+		fmt.Printf("No preset %q.\n", answer)
+	}
+}
+
+// askLook asks for the output size and border thickness.
+func (w *wizard) askLook(args *[]string) error {
+	width, err := w.askInt("Output width in pixels", defaultConfig.targetWidth)
+	if err != nil {
+		return err
+	}
+	height, err := w.askInt("Output height in pixels", defaultConfig.targetHeight)
+	if err != nil {
+		return err
+	}
+	if width != defaultConfig.targetWidth {
+		*args = append(*args, "-width="+strconv.Itoa(width))
+	}
+	if height != defaultConfig.targetHeight {
+		*args = append(*args, "-height="+strconv.Itoa(height))
+	}
+
+	names := make([]string, len(borderFeels))
+	for i, feel := range borderFeels {
+		names[i] = feel.name
+	}
+	for {
+		answer, err := w.ask("Borders ("+strings.Join(names, ", ")+")", "medium")
+		if err != nil {
+			return err
+		}
+		for _, feel := range borderFeels {
+			if strings.EqualFold(answer, feel.name) {
+				if feel.factor != 1 {
+					for _, r := range []struct {
+						flag  string
+						ratio float64
+					}{
+						{"landscape-vert", defaultConfig.landscapeVertBorder},
+						{"landscape-horiz", defaultConfig.landscapeHorizBorder},
+						{"portrait-vert", defaultConfig.portraitVertBorder},
+						{"portrait-horiz", defaultConfig.portraitHorizBorder},
+					} {
+						*args = append(*args, "-"+r.flag+"="+strconv.FormatFloat(r.ratio*feel.factor, 'f', -1, 64))
+					}
+				}
+				return nil
+			}
+		}
+		fmt.Printf("Please choose %s.\n", strings.Join(names, ", "))
+	}
+}
+
+// countInputImages returns the number of supported images in folder.
+func countInputImages(folder string, recursive bool) (int, error) {
+	files, err := listInputs(folder, outputFolderFor(&defaultConfig, folder), recursive)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, file := range files {
+		if _, ok := outputNameFor(file.entry.Name(), &defaultConfig); ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func presetExists(name string) bool {
+	path, err := userConfigPath()
+	if err != nil {
+		return false
+	}
+	_, presets, err := readUserConfig(path)
+	if err != nil {
+		return false
+	}
+	_, ok := presets[name]
+	return ok
+}
+
+// shellJoin quotes args for a POSIX shell where needed.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:@#,+") == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
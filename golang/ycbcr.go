@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+)
+
+// canUseYCbCr reports whether img can be rendered without leaving YCbCr:
+// a decoded JPEG written back as JPEG, with a solid gray border and no
+// feature that draws on an RGBA canvas. Gray borders keep the chroma planes
+// constant, so the border edge is exact even after chroma subsampling.
+func canUseYCbCr(img image.Image, outputPath string, background color.RGBA, config *Config) bool {
+	if _, ok := img.(*image.YCbCr); !ok {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".jpg", ".jpeg":
+	default:
+		return false
+	}
+	if config.autoKeyline || config.borderMode == borderModeBlur || config.watermark != nil || config.vignette > 0 {
+		return false
+	}
+	return background.R == background.G && background.G == background.B
+}
+
+// renderYCbCr places src, scaled to destRect, on a 4:4:4 YCbCr canvas of
+// width x height filled with background. Each plane is scaled on its own, so
+// the photo never goes through RGBA and the JPEG encoder can read the planes
+// directly.
+func renderYCbCr(src *image.YCbCr, width, height int, destRect image.Rectangle, background color.RGBA) *image.YCbCr {
+	canvas := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio444)
+	y, cb, cr := color.RGBToYCbCr(background.R, background.G, background.B)
+	fillPlane(canvas.Y, y)
+	fillPlane(canvas.Cb, cb)
+	fillPlane(canvas.Cr, cr)
+
+	chroma := chromaRect(src.Rect, src.SubsampleRatio)
+	scalePlane(canvas.Y, canvas.YStride, destRect, src.Y, src.YStride, src.Rect.Size())
+	scalePlane(canvas.Cb, canvas.CStride, destRect, src.Cb, src.CStride, chroma.Size())
+	scalePlane(canvas.Cr, canvas.CStride, destRect, src.Cr, src.CStride, chroma.Size())
+	return canvas
+}
+
+// chromaRect returns the bounds of the chroma planes of a YCbCr image with
+// bounds r, in chroma sample coordinates.
+func chromaRect(r image.Rectangle, ratio image.YCbCrSubsampleRatio) image.Rectangle {
+	sx, sy := 1, 1
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		sx = 2
+	case image.YCbCrSubsampleRatio420:
+		sx, sy = 2, 2
+	case image.YCbCrSubsampleRatio440:
+		sy = 2
+	case image.YCbCrSubsampleRatio411:
+		sx = 4
+	case image.YCbCrSubsampleRatio410:
+		sx, sy = 4, 2
+	}
+	return image.Rect(r.Min.X/sx, r.Min.Y/sy, (r.Max.X+sx-1)/sx, (r.Max.Y+sy-1)/sy)
+}
+
+// scalePlane bilinearly scales the size.X x size.Y samples of src into dr
+// of dst, using the same sample positions as draw.ApproxBiLinear.
+// x/image/draw only has fast paths for RGBA destinations, so planes are
+// scaled here instead.
+func scalePlane(dst []byte, dstStride int, dr image.Rectangle, src []byte, srcStride int, size image.Point) {
+	type tap struct {
+		i0, i1 int
+		w      float64
+	}
+	taps := func(dstLen, srcLen int) []tap {
+		t := make([]tap, dstLen)
+		ratio := float64(srcLen) / float64(dstLen)
+		for d := range t {
+			s := (float64(d)+0.5)*ratio - 0.5
+			if s < 0 {
+				s = 0
+			}
+			i0 := int(s)
+			if i0 > srcLen-1 {
+				i0 = srcLen - 1
+			}
+			i1 := i0 + 1
+			if i1 > srcLen-1 {
+				i1 = srcLen - 1
+			}
+			t[d] = tap{i0, i1, s - float64(i0)}
+		}
+		return t
+	}
+	xs := taps(dr.Dx(), size.X)
+	ys := taps(dr.Dy(), size.Y)
+
+	for dy, ty := range ys {
+		row0 := src[ty.i0*srcStride:]
+		row1 := src[ty.i1*srcStride:]
+		out := dst[(dr.Min.Y+dy)*dstStride+dr.Min.X:]
+		for dx, tx := range xs {
+			top := float64(row0[tx.i0]) + (float64(row0[tx.i1])-float64(row0[tx.i0]))*tx.w
+			bottom := float64(row1[tx.i0]) + (float64(row1[tx.i1])-float64(row1[tx.i0]))*tx.w
+			out[dx] = uint8(top + (bottom-top)*ty.w + 0.5)
+		}
+	}
+}
+
+func fillPlane(plane []byte, v uint8) {
+	for i := range plane {
+		plane[i] = v
+	}
+}
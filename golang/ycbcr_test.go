@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// photo returns a JPEG-decoded width x height gradient with a soft ripple,
+// as a 4:2:0 *image.YCbCr like most camera files.
+func photo(t testing.TB, width, height int) *image.YCbCr {
+	t.Helper()
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8(128 + 60*math.Sin(float64(x)/9)*math.Cos(float64(y)/7)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.(*image.YCbCr)
+}
+
+func TestRenderYCbCrMatchesRGBA(t *testing.T) {
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 300, 200
+	src := photo(t, 640, 360)
+
+	var fastInfo, slowInfo imageInfo
+	fast, _ := renderCanvas(src, "out.jpg", config, &fastInfo)
+	if _, ok := fast.(*image.YCbCr); !ok {
+		t.Fatalf("JPEG to JPEG rendered as %T, want the YCbCr fast path", fast)
+	}
+	// A PNG output takes the RGBA path with the same layout.
+	slow, _ := renderCanvas(src, "out.png", config, &slowInfo)
+	if _, ok := slow.(*image.RGBA); !ok {
+		t.Fatalf("JPEG to PNG rendered as %T, want RGBA", slow)
+	}
+	if fast.Bounds() != slow.Bounds() {
+		t.Fatalf("bounds %v and %v differ", fast.Bounds(), slow.Bounds())
+	}
+
+	var total, worst int
+	b := fast.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			fr, fg, fb, _ := fast.At(x, y).RGBA()
+			sr, sg, sb, _ := slow.At(x, y).RGBA()
+			for _, d := range []int{int(fr>>8) - int(sr>>8), int(fg>>8) - int(sg>>8), int(fb>>8) - int(sb>>8)} {
+				d = max(d, -d)
+				total += d
+				worst = max(worst, d)
+			}
+		}
+	}
+	// Chroma is interpolated before rather than after conversion to RGB,
+	// which moves edges of strong color by a few levels at most.
+	if mean := float64(total) / float64(3*b.Dx()*b.Dy()); mean > 1 || worst > 8 {
+		t.Errorf("fast path differs from RGBA by %.2f levels on average, %d at most", mean, worst)
+	}
+	// The border itself is exact.
+	if !near(fast.At(0, 0), slow.At(0, 0), 0) {
+		t.Errorf("border is %v on the fast path, %v on the RGBA path", fast.At(0, 0), slow.At(0, 0))
+	}
+}
+
+func TestCanUseYCbCrFallsBack(t *testing.T) {
+	src := photo(t, 32, 32)
+	gray := color.RGBA{255, 255, 255, 255}
+	tests := []struct {
+		name       string
+		img        image.Image
+		output     string
+		background color.RGBA
+		modify     func(c *Config)
+		want       bool
+	}{
+		{"jpeg to jpeg", src, "a.jpg", gray, func(c *Config) {}, true},
+		{"png output", src, "a.png", gray, func(c *Config) {}, false},
+		{"rgba source", fill(32, 32, gray), "a.jpg", gray, func(c *Config) {}, false},
+		{"colored border", src, "a.jpg", color.RGBA{255, 0, 0, 255}, func(c *Config) {}, false},
+		{"keyline", src, "a.jpg", gray, func(c *Config) { c.autoKeyline = true }, false},
+		{"vignette", src, "a.jpg", gray, func(c *Config) { c.vignette = 0.3 }, false},
+		{"blurred border", src, "a.jpg", gray, func(c *Config) { c.borderMode = borderModeBlur }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(c)
+			if got := canUseYCbCr(tt.img, tt.output, tt.background, c); got != tt.want {
+				t.Errorf("canUseYCbCr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkRenderCanvas(b *testing.B) {
+	config := testConfig()
+	config.targetWidth, config.targetHeight = 1080, 1080
+	src := photo(b, 2400, 1600)
+	for _, bm := range []struct{ name, output string }{{"ycbcr", "out.jpg"}, {"rgba", "out.png"}} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var info imageInfo
+				canvas, _ := renderCanvas(src, bm.output, config, &info)
+				if err := jpeg.Encode(&bytes.Buffer{}, canvas, &jpeg.Options{Quality: 90}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}